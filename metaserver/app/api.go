@@ -3,37 +3,52 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/textproto"
-	"runtime"
+	"strconv"
+	"strings"
+	"time"
 )
 
 import (
-	"github.com/AlexStocks/goext/database/redis"
-	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // LogMiddleware access
 func LogMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		Log.Info("%s\t%s\t%s", r.RemoteAddr, r.Method, r.URL)
+		Log.Info("%s\t%s\t%s\t%s", r.RemoteAddr, getCaller(r).Identity, r.Method, r.URL)
 		handler.ServeHTTP(w, r)
 	})
 }
 
-// dumpStackHandler dump the stack of goroutines into response
-func dumpStackHandler(w http.ResponseWriter, r *http.Request) {
-	buf := make([]byte, 1<<16)
-	runtime.Stack(buf, true)
-	fmt.Fprintf(w, "%s", buf)
+// ReadOnlyMiddleware rejects the requests that need more than reader role. They
+// are served on the admin listener.
+func ReadOnlyMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if RoleReader < requiredRole(r) {
+			writeResponse(w, EC_FORBIDDEN, "served on admin listener only")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
 }
 
-// getMetaHandler return the metadata of redis cluster
+// getMetaHandler return the metadata of redis cluster. The instances can be
+// selected by labels like "/cluster/meta?selector=tier=hot,zone!=b", and the
+// slaves in the zone of the client come first by "/cluster/meta?zone=a".
 func getMetaHandler(w http.ResponseWriter, r *http.Request) {
 	Log.Debug("get request from %#v", r.RemoteAddr)
+	sw := workerOf(r)
 
-	meta, err := json.Marshal(worker.meta)
+	query, err := parseMetaQuery(r)
+	if err != nil {
+		json.NewEncoder(w).Encode(&Response{Code: EC_ILLEGAL_PARAM, Message: err.Error()})
+		return
+	}
+	sw.RLock()
+	meta, err := json.Marshal(query.Apply(sw.metaView()))
+	sw.RUnlock()
 	if err != nil {
 		json.NewEncoder(w).Encode(&Response{Code: EC_SYS_ERROR, Message: err.Error()})
 		return
@@ -46,39 +61,41 @@ func getMetaHandler(w http.ResponseWriter, r *http.Request) {
 func addInstanceHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	Log.Debug("get request from %#v, form:%#v", r.RemoteAddr, r.Form)
+	sw := workerOf(r)
 	if r.Method != "POST" {
 		Log.Error("illegal add instance request method:%s", r.Method)
 		json.NewEncoder(w).Encode(&Response{Code: EC_ILLEGAL_HTTP_METHOD, Message: r.Method})
 		return
 	}
-	reqData, reqError := ioutil.ReadAll(r.Body)
-	defer r.Body.Close()
-	if len(reqData) <= 0 || reqError != nil {
-		json.NewEncoder(w).Encode(&Response{
-			Code:    EC_ILLEGAL_PARAM,
-			Message: fmt.Errorf("req.Body.Read() = {len:%d, err:%v}", len(reqData), reqError).Error(),
-		})
-		return
-	}
-
-	var inst gxredis.RawInstance
-	err := proto.Unmarshal(reqData, &inst)
+	req, err := parseRawInstance(r)
 	if err != nil {
 		json.NewEncoder(w).Encode(&Response{Code: EC_ILLEGAL_PARAM, Message: err.Error()})
 		return
 	}
-	err = inst.Validate()
+	err = sw.provisionInstance(req)
+	Log.Info("got add instance %#v request, replicas:%v, error:%#v", req.Inst, req.Replicas, err)
 	if err != nil {
-		json.NewEncoder(w).Encode(&Response{Code: EC_ILLEGAL_PARAM, Message: err.Error()})
+		json.NewEncoder(w).Encode(&Response{Code: provisionErrorCode(err), Message: err.Error()})
 		return
 	}
-	err = inst.Addr.Validate()
-	if err != nil {
-		json.NewEncoder(w).Encode(&Response{Code: EC_ILLEGAL_PARAM, Message: err.Error()})
+
+	json.NewEncoder(w).Encode(&Response{Code: EC_OK, Message: ErrorCode(EC_OK).String()})
+}
+
+// removeInstanceHandler removes one redis instance from cluster
+func removeInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	Log.Debug("get request from %#v, form:%#v", r.RemoteAddr, r.Form)
+	sw := workerOf(r)
+	if r.Method != "POST" {
+		Log.Error("illegal add instance request method:%s", r.Method)
+		json.NewEncoder(w).Encode(&Response{Code: EC_ILLEGAL_HTTP_METHOD, Message: r.Method})
 		return
 	}
-	err = worker.addInstance(inst)
-	Log.Info("got add instance %#v request, error:%#v", inst, err)
+
+	instanceName := r.Header.Get(textproto.CanonicalMIMEHeaderKey("Instance-Name"))
+	err := sw.removeInstance(instanceName)
+	Log.Info("got remove instance %s request, error:%#v", instanceName, err)
 	if err != nil {
 		json.NewEncoder(w).Encode(&Response{Code: EC_SYS_ERROR, Message: err.Error()})
 		return
@@ -87,19 +104,88 @@ func addInstanceHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(&Response{Code: EC_OK, Message: ErrorCode(EC_OK).String()})
 }
 
-// removeInstanceHandler removes one redis instance from cluster
-func removeInstanceHandler(w http.ResponseWriter, r *http.Request) {
+// getSplitBrainHandler returns the instances that have more than one node accepting writes
+func getSplitBrainHandler(w http.ResponseWriter, r *http.Request) {
+	Log.Debug("get request from %#v", r.RemoteAddr)
+	sw := workerOf(r)
+
+	alerts, err := json.Marshal(sw.splitBrain.Alerts())
+	if err != nil {
+		json.NewEncoder(w).Encode(&Response{Code: EC_SYS_ERROR, Message: err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(&Response{Code: EC_OK, Message: string(alerts)})
+}
+
+// resolveSplitBrainHandler turns a stale master confirmed by the operator into
+// a slave of the sentinel-elected master
+func resolveSplitBrainHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	Log.Debug("get request from %#v, form:%#v", r.RemoteAddr, r.Form)
+	sw := workerOf(r)
 	if r.Method != "POST" {
-		Log.Error("illegal add instance request method:%s", r.Method)
+		Log.Error("illegal resolve split brain request method:%s", r.Method)
 		json.NewEncoder(w).Encode(&Response{Code: EC_ILLEGAL_HTTP_METHOD, Message: r.Method})
 		return
 	}
 
 	instanceName := r.Header.Get(textproto.CanonicalMIMEHeaderKey("Instance-Name"))
-	err := worker.removeInstance(instanceName)
-	Log.Info("got remove instance %s request, error:%#v", instanceName, err)
+	nodeAddr := r.Header.Get(textproto.CanonicalMIMEHeaderKey("Node-Addr"))
+	if instanceName == "" || nodeAddr == "" {
+		json.NewEncoder(w).Encode(&Response{
+			Code:    EC_ILLEGAL_PARAM,
+			Message: fmt.Sprintf("Instance-Name:%q, Node-Addr:%q", instanceName, nodeAddr),
+		})
+		return
+	}
+	err := sw.resolveSplitBrain(instanceName, nodeAddr)
+	Log.Info("got resolve split brain{instance:%s, node:%s} request, error:%#v", instanceName, nodeAddr, err)
+	if err != nil {
+		json.NewEncoder(w).Encode(&Response{Code: EC_SYS_ERROR, Message: err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(&Response{Code: EC_OK, Message: ErrorCode(EC_OK).String()})
+}
+
+// getConfStateHandler returns the version and the last reload result of the running config
+func getConfStateHandler(w http.ResponseWriter, r *http.Request) {
+	Log.Debug("get request from %#v", r.RemoteAddr)
+
+	state, err := json.Marshal(confState.get())
+	if err != nil {
+		json.NewEncoder(w).Encode(&Response{Code: EC_SYS_ERROR, Message: err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(&Response{Code: EC_OK, Message: string(state)})
+}
+
+// rotatePasswordHandler rotates the password of one redis instance
+func rotatePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	Log.Debug("get request from %#v", r.RemoteAddr)
+	sw := workerOf(r)
+	if r.Method != "POST" {
+		Log.Error("illegal rotate password request method:%s", r.Method)
+		json.NewEncoder(w).Encode(&Response{Code: EC_ILLEGAL_HTTP_METHOD, Message: r.Method})
+		return
+	}
+
+	var req RotatePasswordRequest
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		json.NewEncoder(w).Encode(&Response{Code: EC_ILLEGAL_PARAM, Message: err.Error()})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		json.NewEncoder(w).Encode(&Response{Code: EC_ILLEGAL_PARAM, Message: err.Error()})
+		return
+	}
+	err := sw.rotatePassword(req)
+	// do not log the passwords
+	Log.Info("got rotate password{instance:%s, user:%s, overlap:%d} request, error:%#v",
+		req.Name, req.Username, req.Overlap, err)
 	if err != nil {
 		json.NewEncoder(w).Encode(&Response{Code: EC_SYS_ERROR, Message: err.Error()})
 		return
@@ -108,11 +194,98 @@ func removeInstanceHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(&Response{Code: EC_OK, Message: ErrorCode(EC_OK).String()})
 }
 
+// failoverHandler serves POST /cluster/instances/{name}/failover?timeout={seconds}.
+// It responds after the new master is elected.
+func failoverHandler(w http.ResponseWriter, r *http.Request) {
+	Log.Debug("get request from %#v", r.RemoteAddr)
+	sw := workerOf(r)
+
+	fields := strings.Split(strings.TrimPrefix(r.URL.Path, "/cluster/instances/"), "/")
+	if len(fields) != 2 || fields[0] == "" || fields[1] != "failover" {
+		writeResponse(w, EC_NOT_FOUND, r.URL.Path)
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+		return
+	}
+	name := fields[0]
+	if _, ok := sw.getInstance(name); !ok {
+		writeResponse(w, EC_NOT_FOUND, fmt.Sprintf("instance %s not found", name))
+		return
+	}
+	timeout := time.Duration(FailoverWaitTimeout)
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds <= 0 || time.Duration(MaxFailoverWaitTimeout) < time.Duration(seconds)*time.Second {
+			writeResponse(w, EC_ILLEGAL_PARAM, fmt.Sprintf("illegal timeout %q", s))
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	result, err := sw.failover(name, timeout)
+	Log.Info("got failover instance %s request, result:%+v, error:%#v", name, result, err)
+	if err != nil {
+		writeResponse(w, EC_SYS_ERROR, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &result)
+}
+
+// newAPIMux returns the mux of client-facing routes. Debug routes are served by
+// the debug listener only.
+func newAPIMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cluster/meta", deprecated(APIV1Prefix+"/instances", getMetaHandler))
+	mux.HandleFunc("/cluster/addInstance", deprecated(APIV1Prefix+"/instances", addInstanceHandler))
+	mux.HandleFunc("/cluster/removeInstance", deprecated(APIV1Prefix+"/instances/{name}", removeInstanceHandler))
+	mux.HandleFunc(APIV1Prefix+"/instances", v1InstancesHandler)
+	mux.HandleFunc(APIV1Prefix+"/instances/", v1InstanceHandler)
+	mux.HandleFunc(APIV1Prefix+"/batch", v1BatchHandler)
+	mux.HandleFunc(APIV1Prefix+"/namespaces", v1NamespacesHandler)
+	mux.HandleFunc(APIV1Prefix+"/namespaces/", v1NamespacesHandler)
+	mux.HandleFunc(APIV1Prefix+"/zones", v1ZonesHandler)
+	mux.HandleFunc(APIV1Prefix+"/stream", v1StreamHandler)
+	mux.HandleFunc(APIV1Prefix+"/federation", v1FederationHandler)
+	mux.HandleFunc("/cluster/splitBrain", getSplitBrainHandler)
+	mux.HandleFunc("/cluster/resolveSplitBrain", resolveSplitBrainHandler)
+	mux.HandleFunc("/cluster/rotatePassword", rotatePasswordHandler)
+	mux.HandleFunc("/cluster/instances/", failoverHandler)
+	mux.HandleFunc("/config/state", getConfStateHandler)
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/readyz", readyHandler)
+	mux.Handle(MetricsPath, promhttp.Handler())
+	mux.HandleFunc(ClusterPrefix, clusterHandler(mux))
+	mux.HandleFunc(ClusterPrefix+"/", clusterHandler(mux))
+
+	return mux
+}
+
 // startHTTP start a HTTP server to serve.
 func startHTTP(addr string) {
-	http.HandleFunc("/stack", dumpStackHandler)
-	http.HandleFunc("/cluster/meta", getMetaHandler)
-	http.HandleFunc("/cluster/addInstance", addInstanceHandler)
-	http.HandleFunc("/cluster/removeInstance", removeInstanceHandler)
-	Log.Critical(http.ListenAndServe(addr, LogMiddleware(http.DefaultServeMux)))
+	mux := newAPIMux()
+	handler := MetricsMiddleware(mux, AuthMiddleware(LogMiddleware(mux)))
+	tlsConf := getConf().Core.TLS
+	if !tlsConf.Enabled {
+		Log.Critical(http.ListenAndServe(addr, handler))
+		return
+	}
+
+	reloader, err := NewCertReloader(tlsConf)
+	if err != nil {
+		Log.Critical("NewCertReloader() = error:%#v", err)
+		return
+	}
+	if adminAddr := tlsConf.AdminBindAddr; adminAddr != "" {
+		go func() {
+			server := &http.Server{Addr: adminAddr, Handler: handler, TLSConfig: reloader.TLSConfig(true)}
+			Log.Critical(server.ListenAndServeTLS("", ""))
+		}()
+		handler = ReadOnlyMiddleware(handler)
+	}
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: reloader.TLSConfig(false)}
+	Log.Critical(server.ListenAndServeTLS("", ""))
 }
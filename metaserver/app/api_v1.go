@@ -0,0 +1,674 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	APIV1Prefix = "/v1"
+)
+
+type (
+	// InstanceSpec is the JSON form of gxredis.RawInstance. Sdowntime, FailoverTimeout
+	// and ReplicaSyncTimeout are in seconds.
+	InstanceSpec struct {
+		Name            string `json:"name"`
+		Addr            string `json:"addr"` // ip:port of the master
+		Epoch           int32  `json:"epoch"`
+		Sdowntime       int32  `json:"sdowntime"`
+		FailoverTimeout int32  `json:"failover_timeout"`
+		// ip:port of the replicas attached to the master before the sentinels monitor it
+		Replicas           []string `json:"replicas,omitempty"`
+		ReplicaSyncTimeout int      `json:"replica_sync_timeout,omitempty"`
+		// attach the replicas even if they hold data or have slaves
+		ForceReplicas bool `json:"force_replicas,omitempty"`
+	}
+
+	// MetaQuery is the query string of the meta: "selector" selects the instances
+	// by labels, and the slaves in "zone" come first.
+	MetaQuery struct {
+		Selector LabelSelector
+		Zone     string
+	}
+
+	// InstancePatch is the body of PATCH /v1/instances/{name}. Absent fields are not changed.
+	InstancePatch struct {
+		Sdowntime       *int32 `json:"sdowntime"`
+		FailoverTimeout *int32 `json:"failover_timeout"`
+	}
+)
+
+func (s InstanceSpec) RawInstance() (gxredis.RawInstance, error) {
+	host, portStr, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return gxredis.RawInstance{}, fmt.Errorf("illegal addr %q", s.Addr)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return gxredis.RawInstance{}, fmt.Errorf("illegal port of addr %q", s.Addr)
+	}
+
+	return gxredis.RawInstance{
+		Name:            s.Name,
+		Addr:            &gxredis.IPAddr{IP: host, Port: int32(port)},
+		Epoch:           s.Epoch,
+		Sdowntime:       s.Sdowntime,
+		FailoverTimeout: s.FailoverTimeout,
+	}, nil
+}
+
+// httpStatus maps an error code to a HTTP status code
+func httpStatus(code ErrorCode) int {
+	switch code {
+	case EC_OK:
+		return http.StatusOK
+	case EC_ILLEGAL_PARAM:
+		return http.StatusBadRequest
+	case EC_ILLEGAL_HTTP_METHOD:
+		return http.StatusMethodNotAllowed
+	case EC_NOT_FOUND:
+		return http.StatusNotFound
+	case EC_UNAUTHORIZED:
+		return http.StatusUnauthorized
+	case EC_FORBIDDEN:
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeResponse writes a Response with the HTTP status mapped from @code
+func writeResponse(w http.ResponseWriter, code ErrorCode, msg string) {
+	writeJSON(w, httpStatus(code), &Response{Code: code, Message: msg})
+}
+
+// isJSONRequest returns true if the body of @r is JSON. A body without content type is
+// taken as protobuf to be compatible with the old clients.
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// checkReplicas checks the replica addresses of @spec
+func (s InstanceSpec) checkReplicas() error {
+	if s.ReplicaSyncTimeout < 0 || time.Duration(MaxReplicaSyncTimeout) < time.Duration(s.ReplicaSyncTimeout)*time.Second {
+		return fmt.Errorf("illegal replica_sync_timeout %d", s.ReplicaSyncTimeout)
+	}
+	replicas := make(map[string]struct{}, len(s.Replicas))
+	for _, replica := range s.Replicas {
+		if err := checkAddr(replica); err != nil {
+			return fmt.Errorf("illegal replica %q: %v", replica, err)
+		}
+		if strings.HasPrefix(replica, ":") {
+			return fmt.Errorf("ip of replica %s is empty", replica)
+		}
+		if replica == s.Addr {
+			return fmt.Errorf("replica %s is the master", replica)
+		}
+		if _, ok := replicas[replica]; ok {
+			return fmt.Errorf("duplicate replica %s", replica)
+		}
+		replicas[replica] = struct{}{}
+	}
+
+	return nil
+}
+
+// parseRawInstance reads a RawInstance in JSON or protobuf from the body of @r and validates it.
+// Replicas can only be given in JSON.
+func parseRawInstance(r *http.Request) (AddInstanceRequest, error) {
+	var req AddInstanceRequest
+
+	reqData, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if len(reqData) <= 0 || err != nil {
+		return req, fmt.Errorf("req.Body.Read() = {len:%d, err:%v}", len(reqData), err)
+	}
+
+	req.SyncTimeout = time.Duration(ReplicaSyncTimeout)
+	if isJSONRequest(r) {
+		var spec InstanceSpec
+		if err = json.Unmarshal(reqData, &spec); err != nil {
+			return req, err
+		}
+		if req.Inst, err = spec.RawInstance(); err != nil {
+			return req, err
+		}
+		if err = spec.checkReplicas(); err != nil {
+			return req, err
+		}
+		req.Replicas = spec.Replicas
+		req.Force = spec.ForceReplicas
+		if spec.ReplicaSyncTimeout != 0 {
+			req.SyncTimeout = time.Duration(spec.ReplicaSyncTimeout) * time.Second
+		}
+	} else if err = proto.Unmarshal(reqData, &req.Inst); err != nil {
+		return req, err
+	}
+
+	inst := &req.Inst
+	if err = inst.Validate(); err != nil {
+		return req, err
+	}
+	if inst.Addr == nil {
+		return req, fmt.Errorf("addr of instance %s is empty", inst.Name)
+	}
+	if err = inst.Addr.Validate(); err != nil {
+		return req, err
+	}
+
+	return req, nil
+}
+
+func parseMetaQuery(r *http.Request) (MetaQuery, error) {
+	var (
+		err   error
+		query MetaQuery
+	)
+
+	if query.Selector, err = ParseLabelSelector(r.URL.Query().Get("selector")); err != nil {
+		return query, err
+	}
+	query.Zone = r.URL.Query().Get("zone")
+	if err = checkLabelValue(query.Zone); err != nil {
+		return query, fmt.Errorf("illegal zone %q", query.Zone)
+	}
+
+	return query, nil
+}
+
+// Apply returns the instances of @view selected by @q
+func (q MetaQuery) Apply(view MetaView) MetaView {
+	return q.Selector.Select(view).PreferZone(q.Zone)
+}
+
+// deprecated marks @handler as the deprecated alias of @successor
+func deprecated(successor string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		handler(w, r)
+	}
+}
+
+// v1InstancesHandler serves GET /v1/instances[?selector=...&zone=...] and POST /v1/instances
+func v1InstancesHandler(w http.ResponseWriter, r *http.Request) {
+	Log.Debug("get request from %#v", r.RemoteAddr)
+	sw := workerOf(r)
+
+	switch r.Method {
+	case "GET":
+		query, err := parseMetaQuery(r)
+		if err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		sw.RLock()
+		body, err := json.Marshal(query.Apply(sw.metaView()))
+		sw.RUnlock()
+		if err != nil {
+			writeResponse(w, EC_SYS_ERROR, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, json.RawMessage(body))
+
+	case "POST":
+		req, err := parseRawInstance(r)
+		if err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		err = sw.provisionInstance(req)
+		Log.Info("got add instance %#v request, replicas:%v, error:%#v", req.Inst, req.Replicas, err)
+		if err != nil {
+			writeResponse(w, provisionErrorCode(err), err.Error())
+			return
+		}
+		w.Header().Set("Location", APIV1Prefix+"/instances/"+req.Inst.Name)
+		writeJSON(w, http.StatusCreated, &Response{Code: EC_OK, Message: ErrorCode(EC_OK).String()})
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+	}
+}
+
+// v1InstanceHandler serves GET/PATCH/DELETE /v1/instances/{name} and its sub-resources
+func v1InstanceHandler(w http.ResponseWriter, r *http.Request) {
+	Log.Debug("get request from %#v", r.RemoteAddr)
+
+	serveV1Instance(w, r, strings.TrimPrefix(r.URL.Path, APIV1Prefix+"/instances/"))
+}
+
+// serveV1Instance serves instance @path, which is "{name}" or "{name}/{sub-resource}"
+func serveV1Instance(w http.ResponseWriter, r *http.Request, path string) {
+	sw := workerOf(r)
+	var name, sub string
+	fields := strings.SplitN(path, "/", 2)
+	name = fields[0]
+	if len(fields) == 2 {
+		sub = fields[1]
+	}
+	switch {
+	case name == "":
+		writeResponse(w, EC_NOT_FOUND, r.URL.Path)
+		return
+	case sub == "params":
+		v1InstanceParamsHandler(w, r, name)
+		return
+	case sub == "state":
+		v1InstanceStateHandler(w, r, name)
+		return
+	case sub == "labels":
+		v1InstanceLabelsHandler(w, r, name)
+		return
+	case sub == "namespace":
+		v1InstanceNamespaceHandler(w, r, name)
+		return
+	case len(fields) == 2:
+		writeResponse(w, EC_NOT_FOUND, r.URL.Path)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		inst, ok := sw.getInstance(name)
+		if !ok {
+			writeResponse(w, EC_NOT_FOUND, fmt.Sprintf("instance %s not found", name))
+			return
+		}
+		writeJSON(w, http.StatusOK, MetaInstance{Instance: &inst, InstanceAttrs: sw.getInstanceAttrs(name)})
+
+	case "PATCH":
+		var patch InstancePatch
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		params := make(map[string]int64, 2)
+		if patch.Sdowntime != nil {
+			params["down-after-milliseconds"] = int64(*patch.Sdowntime) * 1000
+		}
+		if patch.FailoverTimeout != nil {
+			params["failover-timeout"] = int64(*patch.FailoverTimeout) * 1000
+		}
+		if err := checkMonitorParams(params); err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		if _, ok := sw.getInstance(name); !ok {
+			writeResponse(w, EC_NOT_FOUND, fmt.Sprintf("instance %s not found", name))
+			return
+		}
+		_, err := sw.setMonitorParams(name, params)
+		Log.Info("got update instance %s request %v, error:%#v", name, params, err)
+		if err != nil {
+			writeResponse(w, EC_SYS_ERROR, err.Error())
+			return
+		}
+		writeResponse(w, EC_OK, ErrorCode(EC_OK).String())
+
+	case "DELETE":
+		if _, ok := sw.getInstance(name); !ok {
+			writeResponse(w, EC_NOT_FOUND, fmt.Sprintf("instance %s not found", name))
+			return
+		}
+		err := sw.removeInstance(name)
+		Log.Info("got remove instance %s request, error:%#v", name, err)
+		if err != nil {
+			writeResponse(w, EC_SYS_ERROR, err.Error())
+			return
+		}
+		writeResponse(w, EC_OK, ErrorCode(EC_OK).String())
+
+	default:
+		w.Header().Set("Allow", "GET, PATCH, DELETE")
+		writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+	}
+}
+
+// v1BatchHandler serves POST /v1/batch. It responds the result of every item.
+func v1BatchHandler(w http.ResponseWriter, r *http.Request) {
+	Log.Debug("get request from %#v", r.RemoteAddr)
+	sw := workerOf(r)
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+		return
+	}
+
+	var req BatchRequest
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+		return
+	}
+	results, err := sw.applyBatch(req)
+	Log.Info("got batch request{add:%d, remove:%d}, error:%#v", len(req.Add), len(req.Remove), err)
+
+	rsp := struct {
+		Code    ErrorCode         `json:"Code"`
+		Message string            `json:"Message"`
+		Results []BatchItemResult `json:"Results"`
+	}{Code: EC_OK, Message: ErrorCode(EC_OK).String(), Results: results}
+	if err != nil {
+		rsp.Code, rsp.Message = EC_SYS_ERROR, err.Error()
+		if err == ErrIllegalBatch {
+			rsp.Code = EC_ILLEGAL_PARAM
+		}
+	}
+	writeJSON(w, httpStatus(rsp.Code), &rsp)
+}
+
+// v1InstanceParamsHandler serves GET/PATCH /v1/instances/{name}/params. The body of
+// PATCH is like {"quorum": 2, "down-after-milliseconds": 15000}. Both respond the
+// params on every sentinel.
+func v1InstanceParamsHandler(w http.ResponseWriter, r *http.Request, name string) {
+	sw := workerOf(r)
+	if _, ok := sw.getInstance(name); !ok {
+		writeResponse(w, EC_NOT_FOUND, fmt.Sprintf("instance %s not found", name))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, sw.getMonitorParamsState(name))
+
+	case "PATCH":
+		var params map[string]int64
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		if err := checkMonitorParams(params); err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		state, err := sw.setMonitorParams(name, params)
+		Log.Info("got set monitor params of instance %s request %v, error:%#v", name, params, err)
+		rsp := struct {
+			Code    ErrorCode          `json:"Code"`
+			Message string             `json:"Message"`
+			State   MonitorParamsState `json:"State"`
+		}{Code: EC_OK, Message: ErrorCode(EC_OK).String(), State: state}
+		if err != nil {
+			rsp.Code, rsp.Message = EC_SYS_ERROR, err.Error()
+		}
+		writeJSON(w, httpStatus(rsp.Code), &rsp)
+
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+	}
+}
+
+// v1InstanceStateHandler serves GET/PUT /v1/instances/{name}/state. The body of PUT
+// is like {"state": "draining"}.
+func v1InstanceStateHandler(w http.ResponseWriter, r *http.Request, name string) {
+	sw := workerOf(r)
+	if _, ok := sw.getInstance(name); !ok {
+		writeResponse(w, EC_NOT_FOUND, fmt.Sprintf("instance %s not found", name))
+		return
+	}
+
+	var state struct {
+		Name  string `json:"name"`
+		State string `json:"state"`
+	}
+	switch r.Method {
+	case "GET":
+		state.Name, state.State = name, sw.getInstanceAttrs(name).State
+		writeJSON(w, http.StatusOK, &state)
+
+	case "PUT":
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		if err := checkInstanceState(state.State); err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		err := sw.setInstanceState(name, state.State)
+		Log.Info("got set state of instance %s to %s request, error:%#v", name, state.State, err)
+		if err != nil {
+			writeResponse(w, EC_SYS_ERROR, err.Error())
+			return
+		}
+		writeResponse(w, EC_OK, ErrorCode(EC_OK).String())
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+	}
+}
+
+// v1InstanceLabelsHandler serves GET/PATCH /v1/instances/{name}/labels. The body of
+// PATCH is like {"labels": {"tier": "hot", "zone": null}, "annotations": {"owner": "team a"}},
+// in which null deletes the key.
+func v1InstanceLabelsHandler(w http.ResponseWriter, r *http.Request, name string) {
+	sw := workerOf(r)
+	if _, ok := sw.getInstance(name); !ok {
+		writeResponse(w, EC_NOT_FOUND, fmt.Sprintf("instance %s not found", name))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		attrs := sw.getInstanceAttrs(name)
+		writeJSON(w, http.StatusOK, &struct {
+			Labels      map[string]string `json:"labels"`
+			Annotations map[string]string `json:"annotations"`
+		}{attrs.Labels, attrs.Annotations})
+
+	case "PATCH":
+		var patch LabelsPatch
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		if err := patch.Validate(); err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		err := sw.patchLabels(name, patch)
+		Log.Info("got patch labels of instance %s request, error:%#v", name, err)
+		if err != nil {
+			writeResponse(w, EC_SYS_ERROR, err.Error())
+			return
+		}
+		writeResponse(w, EC_OK, ErrorCode(EC_OK).String())
+
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+	}
+}
+
+// v1InstanceNamespaceHandler serves GET/PUT /v1/instances/{name}/namespace. The body
+// of PUT is like {"namespace": "product-a"}. The caller should be able to access
+// the new namespace.
+func v1InstanceNamespaceHandler(w http.ResponseWriter, r *http.Request, name string) {
+	sw := workerOf(r)
+	if _, ok := sw.getInstance(name); !ok {
+		writeResponse(w, EC_NOT_FOUND, fmt.Sprintf("instance %s not found", name))
+		return
+	}
+
+	var ns struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	}
+	switch r.Method {
+	case "GET":
+		ns.Name, ns.Namespace = name, sw.getInstanceAttrs(name).Namespace
+		writeJSON(w, http.StatusOK, &ns)
+
+	case "PUT":
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&ns); err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		if err := checkNamespace(ns.Namespace); err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		if !getCaller(r).CanAccessNamespace(ns.Namespace) {
+			writeResponse(w, EC_FORBIDDEN, fmt.Sprintf("namespace %s can not be accessed", ns.Namespace))
+			return
+		}
+		err := sw.setInstanceNamespace(name, ns.Namespace)
+		Log.Info("got set namespace of instance %s to %s request, error:%#v", name, ns.Namespace, err)
+		if err != nil {
+			writeResponse(w, EC_SYS_ERROR, err.Error())
+			return
+		}
+		writeResponse(w, EC_OK, ErrorCode(EC_OK).String())
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+	}
+}
+
+// v1NamespacesHandler serves the namespace-scoped API:
+//
+//	GET /v1/namespaces: the namespaces that the caller can access
+//	GET /v1/namespaces/{ns}[/instances][?selector=...&zone=...]: the meta of a namespace with its own version
+//	POST /v1/namespaces/{ns}/instances: add an instance into a namespace
+//	/v1/namespaces/{ns}/instances/{name}[/...]: same as /v1/instances/{name}[/...]
+func v1NamespacesHandler(w http.ResponseWriter, r *http.Request) {
+	Log.Debug("get request from %#v", r.RemoteAddr)
+	sw := workerOf(r)
+
+	caller := getCaller(r)
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, APIV1Prefix+"/namespaces"), "/")
+	if path == "" {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+			return
+		}
+		sw.RLock()
+		body, err := json.Marshal(sw.namespaceInfos(caller.CanAccessNamespace))
+		sw.RUnlock()
+		if err != nil {
+			writeResponse(w, EC_SYS_ERROR, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, json.RawMessage(body))
+		return
+	}
+
+	fields := strings.SplitN(path, "/", 3)
+	ns := fields[0]
+	if err := checkNamespace(ns); err != nil {
+		writeResponse(w, EC_NOT_FOUND, err.Error())
+		return
+	}
+	if !caller.CanAccessNamespace(ns) {
+		writeResponse(w, EC_FORBIDDEN, fmt.Sprintf("namespace %s can not be accessed", ns))
+		return
+	}
+	if 1 < len(fields) && fields[1] != "instances" {
+		writeResponse(w, EC_NOT_FOUND, r.URL.Path)
+		return
+	}
+
+	if len(fields) == 3 {
+		name := strings.SplitN(fields[2], "/", 2)[0]
+		if _, ok := sw.getInstance(name); !ok || sw.getInstanceAttrs(name).Namespace != ns {
+			writeResponse(w, EC_NOT_FOUND, fmt.Sprintf("instance %s not found in namespace %s", name, ns))
+			return
+		}
+		serveV1Instance(w, r, fields[2])
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		query, err := parseMetaQuery(r)
+		if err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		sw.RLock()
+		body, err := json.Marshal(query.Apply(sw.namespaceView(ns)))
+		sw.RUnlock()
+		if err != nil {
+			writeResponse(w, EC_SYS_ERROR, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, json.RawMessage(body))
+
+	case "POST":
+		if len(fields) == 1 {
+			w.Header().Set("Allow", "GET")
+			writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+			return
+		}
+		req, err := parseRawInstance(r)
+		if err != nil {
+			writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+			return
+		}
+		req.Namespace = ns
+		err = sw.provisionInstance(req)
+		Log.Info("got add instance %#v into namespace %s request, replicas:%v, error:%#v", req.Inst, ns, req.Replicas, err)
+		if err != nil {
+			writeResponse(w, provisionErrorCode(err), err.Error())
+			return
+		}
+		w.Header().Set("Location", APIV1Prefix+"/namespaces/"+ns+"/instances/"+req.Inst.Name)
+		writeJSON(w, http.StatusCreated, &Response{Code: EC_OK, Message: ErrorCode(EC_OK).String()})
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+	}
+}
+
+// v1ZonesHandler serves GET /v1/zones. It responds the node count of every zone
+// and the instances whose master and slaves are all in one zone.
+func v1ZonesHandler(w http.ResponseWriter, r *http.Request) {
+	Log.Debug("get request from %#v", r.RemoteAddr)
+	sw := workerOf(r)
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+		return
+	}
+
+	sw.RLock()
+	body, err := json.Marshal(sw.metaView().ZoneReport())
+	sw.RUnlock()
+	if err != nil {
+		writeResponse(w, EC_SYS_ERROR, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(body))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+func Test_parseRawInstance(t *testing.T) {
+	body := `{"name":"cache1","addr":"192.168.11.100:4001","epoch":2,"sdowntime":15,"failover_timeout":450}`
+	r := httptest.NewRequest("POST", "/v1/instances", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req, err := parseRawInstance(r)
+	if err != nil {
+		t.Fatalf("parseRawInstance() = error:%#v", err)
+	}
+	inst := req.Inst
+	if len(req.Replicas) != 0 || req.SyncTimeout != time.Duration(ReplicaSyncTimeout) {
+		t.Errorf("parseRawInstance() = %#v", req)
+	}
+	if inst.Name != "cache1" || inst.Addr.IP != "192.168.11.100" || inst.Addr.Port != 4001 ||
+		inst.Epoch != 2 || inst.Sdowntime != 15 || inst.FailoverTimeout != 450 {
+		t.Errorf("parseRawInstance() = %#v", inst)
+	}
+
+	body = `{"name":"cache1","addr":"192.168.11.100:4001","replicas":["192.168.11.101:4001"],"replica_sync_timeout":60}`
+	r = httptest.NewRequest("POST", "/v1/instances", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if req, err = parseRawInstance(r); err != nil {
+		t.Fatalf("parseRawInstance() = error:%#v", err)
+	}
+	if len(req.Replicas) != 1 || req.Replicas[0] != "192.168.11.101:4001" || req.SyncTimeout != time.Minute {
+		t.Errorf("parseRawInstance() = %#v", req)
+	}
+
+	for _, body := range []string{
+		``,
+		`{"name":"cache1","addr":"192.168.11.100:4001","replicas":["192.168.11.100:4001"]}`,
+		`{"name":"cache1","addr":"192.168.11.100:4001","replicas":["192.168.11.101:4001","192.168.11.101:4001"]}`,
+		`{"name":"cache1","addr":"192.168.11.100:4001","replicas":[":4002"]}`,
+		`{"name":"cache1","addr":"192.168.11.100"}`,
+		`{"name":"cache1","addr":"192.168.11.100:port"}`,
+		`{"name":`,
+	} {
+		r = httptest.NewRequest("POST", "/v1/instances", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if _, err = parseRawInstance(r); err == nil {
+			t.Errorf("parseRawInstance(%q) should fail", body)
+		}
+	}
+}
+
+func Test_httpStatus(t *testing.T) {
+	cases := map[ErrorCode]int{
+		EC_OK:                  http.StatusOK,
+		EC_ILLEGAL_PARAM:       http.StatusBadRequest,
+		EC_ILLEGAL_HTTP_METHOD: http.StatusMethodNotAllowed,
+		EC_NOT_FOUND:           http.StatusNotFound,
+		EC_UNAUTHORIZED:        http.StatusUnauthorized,
+		EC_FORBIDDEN:           http.StatusForbidden,
+		EC_SYS_ERROR:           http.StatusInternalServerError,
+	}
+	for code, status := range cases {
+		if got := httpStatus(code); got != status {
+			t.Errorf("httpStatus(%s) = %d, want %d", code, got, status)
+		}
+	}
+}
+
+// lockProbeWriter records whether the lock of the worker is free when the body is written
+type lockProbeWriter struct {
+	*httptest.ResponseRecorder
+	sw     *SentinelWorker
+	locked bool
+}
+
+func (w *lockProbeWriter) Write(p []byte) (int, error) {
+	if w.sw.TryLock() {
+		w.sw.Unlock()
+	} else {
+		w.locked = true
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+func Test_v1Handlers(t *testing.T) {
+	sentinel := newRedisStandIn(t, "", nil)
+	defer sentinel.Close()
+	oldConf := *getConf()
+	conf := oldConf
+	conf.Redis.Namespaces = []string{"payment"}
+	runningConf.set(conf)
+	oldLog := Log
+	Log = newReloadableLogger(&fakeLogger{})
+	defer func() {
+		runningConf.set(oldConf)
+		Log = oldLog
+	}()
+
+	translator, _ := NewAddrTranslator(nil, nil)
+	sw := &SentinelWorker{
+		cluster:    DefaultCluster,
+		sentinels:  []string{sentinel.Addr()},
+		translator: translator,
+		meta: ClusterMeta{Instances: map[string]*gxredis.Instance{
+			"cache1": {Name: "cache1", Master: &gxredis.IPAddr{IP: "192.168.11.100", Port: 4001}},
+		}},
+		attrs:    map[string]InstanceAttrs{},
+		nsStates: map[string]namespaceState{},
+	}
+	sw.sntl = newSentinelClient(sw.getSentinels)
+
+	cases := []struct {
+		handler  http.HandlerFunc
+		method   string
+		path     string
+		body     string
+		status   int
+		allow    string
+		location string
+	}{
+		{v1InstancesHandler, "GET", "/v1/instances", "", http.StatusOK, "", ""},
+		{v1InstancesHandler, "PUT", "/v1/instances", "", http.StatusMethodNotAllowed, "GET, POST", ""},
+		{v1InstancesHandler, "POST", "/v1/instances", `{"name":"cache2","addr":"192.168.11.101:4001"}`,
+			http.StatusCreated, "", "/v1/instances/cache2"},
+		{v1InstanceHandler, "GET", "/v1/instances/cache1", "", http.StatusOK, "", ""},
+		{v1InstanceHandler, "GET", "/v1/instances/cache3", "", http.StatusNotFound, "", ""},
+		{v1InstanceHandler, "GET", "/v1/instances/cache1/unknown", "", http.StatusNotFound, "", ""},
+		{v1InstanceHandler, "PUT", "/v1/instances/cache1", "", http.StatusMethodNotAllowed, "GET, PATCH, DELETE", ""},
+		{v1NamespacesHandler, "GET", "/v1/namespaces", "", http.StatusOK, "", ""},
+		{v1NamespacesHandler, "POST", "/v1/namespaces", "", http.StatusMethodNotAllowed, "GET", ""},
+		{v1NamespacesHandler, "GET", "/v1/namespaces/payment", "", http.StatusOK, "", ""},
+		{v1NamespacesHandler, "GET", "/v1/namespaces/unknown", "", http.StatusNotFound, "", ""},
+		{v1NamespacesHandler, "POST", "/v1/namespaces/payment", "", http.StatusMethodNotAllowed, "GET", ""},
+		{v1NamespacesHandler, "POST", "/v1/namespaces/payment/instances", `{"name":"cache3","addr":"192.168.11.102:4001"}`,
+			http.StatusCreated, "", "/v1/namespaces/payment/instances/cache3"},
+		{v1NamespacesHandler, "GET", "/v1/namespaces/default/instances/cache1", "", http.StatusOK, "", ""},
+		{v1NamespacesHandler, "GET", "/v1/namespaces/payment/instances/cache1", "", http.StatusNotFound, "", ""},
+		{v1ZonesHandler, "GET", "/v1/zones", "", http.StatusOK, "", ""},
+		{v1ZonesHandler, "POST", "/v1/zones", "", http.StatusMethodNotAllowed, "GET", ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		r.Header.Set("Content-Type", "application/json")
+		r = r.WithContext(context.WithValue(r.Context(), workerKey{}, sw))
+		w := &lockProbeWriter{ResponseRecorder: httptest.NewRecorder(), sw: sw}
+		c.handler(w, r)
+		if w.Code != c.status || w.Header().Get("Allow") != c.allow || w.Header().Get("Location") != c.location {
+			t.Errorf("%s %s = %d{Allow:%q, Location:%q}, want %d{Allow:%q, Location:%q}, body:%s", c.method, c.path,
+				w.Code, w.Header().Get("Allow"), w.Header().Get("Location"), c.status, c.allow, c.location, w.Body.String())
+		}
+		if w.locked {
+			t.Errorf("%s %s writes the response with the worker locked", c.method, c.path)
+		}
+	}
+}
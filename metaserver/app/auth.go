@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Role is the permission level of an API caller. A higher role has all the
+// permissions of the lower ones.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleReader
+	RoleOperator
+	RoleAdmin
+)
+
+const (
+	HMACScheme          = "HMAC-SHA256"
+	HMACTimestampHeader = "X-Auth-Timestamp"
+	HMACNonceHeader     = "X-Auth-Nonce"
+	DefaultHMACMaxSkew  = 300     // 300s
+	MaxHMACBodySize     = 1 << 20 // 1MB
+)
+
+var roleNames = map[Role]string{
+	RoleNone:     "none",
+	RoleReader:   "reader",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return "Role(" + strconv.Itoa(int(r)) + ")"
+}
+
+func ParseRole(name string) (Role, error) {
+	for role, roleName := range roleNames {
+		if role != RoleNone && roleName == name {
+			return role, nil
+		}
+	}
+
+	return RoleNone, fmt.Errorf("illegal role %q", name)
+}
+
+type (
+	// Caller is the authenticated API caller
+	Caller struct {
+		Identity string
+		Role     Role
+		// a caller with namespaces can only access them under /v1/namespaces/.
+		// nil means all namespaces and all routes.
+		Namespaces []string
+	}
+
+	hmacKey struct {
+		secret     []byte
+		role       Role
+		namespaces []string
+	}
+
+	// APIAuthenticator authenticates API callers by client certificate, bearer token
+	// and HMAC signature in turn.
+	APIAuthenticator struct {
+		enabled   bool
+		tokens    map[string]Caller // token -> caller
+		hmacKeys  map[string]hmacKey
+		maxSkew   time.Duration
+		replays   *replayCache
+		certRoles map[string]Role
+		anonymous Role
+	}
+
+	// replayCache keeps the signed requests until their timestamps are out of the
+	// max skew, so a captured request can not be replayed
+	replayCache struct {
+		sync.Mutex
+		seen      map[string]time.Time // request key -> expiry
+		nextPrune time.Time
+	}
+
+	// routeRole is the role needed by a route. A GET or HEAD request needs read,
+	// and any other method needs write.
+	routeRole struct {
+		prefix string
+		read   Role
+		write  Role
+	}
+
+	authenticatorHolder struct {
+		sync.RWMutex
+		auth *APIAuthenticator
+	}
+
+	callerKey struct{}
+)
+
+var (
+	// routeRoles is matched in order by path prefix. A route not in it needs admin.
+	routeRoles = []routeRole{
+		{"/healthz", RoleNone, RoleNone},
+		{"/readyz", RoleNone, RoleNone},
+		{MetricsPath, RoleReader, RoleReader},
+		{"/stack", RoleAdmin, RoleAdmin},
+		{"/debug/", RoleAdmin, RoleAdmin},
+		{"/config/", RoleReader, RoleReader},
+		{"/cluster/meta", RoleReader, RoleReader},
+		{"/cluster/splitBrain", RoleReader, RoleReader},
+		{"/cluster/addInstance", RoleOperator, RoleOperator},
+		{"/cluster/removeInstance", RoleOperator, RoleOperator},
+		{"/cluster/resolveSplitBrain", RoleOperator, RoleOperator},
+		{"/cluster/rotatePassword", RoleAdmin, RoleAdmin},
+		{"/cluster/instances/", RoleOperator, RoleOperator},
+		{APIV1Prefix + "/instances", RoleReader, RoleOperator},
+		{APIV1Prefix + "/batch", RoleOperator, RoleOperator},
+		{APIV1Prefix + "/namespaces", RoleReader, RoleOperator},
+		{APIV1Prefix + "/zones", RoleReader, RoleReader},
+		{APIV1Prefix + "/stream", RoleReader, RoleReader},
+		{APIV1Prefix + "/federation", RoleReader, RoleReader},
+		{ClusterPrefix, RoleReader, RoleReader},
+	}
+
+	apiAuth authenticatorHolder
+)
+
+// readCredentialFile returns the fields of every line of @file, which has @minFieldNum
+// to @maxFieldNum fields. Empty lines and lines starting with '#' are skipped.
+func readCredentialFile(file string, minFieldNum int, maxFieldNum int) ([][]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines [][]string
+	scanner := bufio.NewScanner(f)
+	for no := 1; scanner.Scan(); no++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < minFieldNum || maxFieldNum < len(fields) {
+			return nil, fmt.Errorf("line %d of %s should have %d to %d fields", no, file, minFieldNum, maxFieldNum)
+		}
+		lines = append(lines, fields)
+	}
+
+	return lines, scanner.Err()
+}
+
+// parseCredentialNamespaces parses the optional last field of a credential, which
+// is a comma separated namespace list.
+func parseCredentialNamespaces(fields []string) ([]string, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	namespaces := strings.Split(fields[0], ",")
+	for _, ns := range namespaces {
+		if err := checkNamespaceName(ns); err != nil {
+			return nil, err
+		}
+	}
+	return namespaces, nil
+}
+
+// NewAPIAuthenticator loads the token file and the hmac key file of @conf.
+func NewAPIAuthenticator(conf SectionAPIAuth) (*APIAuthenticator, error) {
+	a := &APIAuthenticator{
+		enabled:   conf.Enabled,
+		tokens:    make(map[string]Caller),
+		hmacKeys:  make(map[string]hmacKey),
+		maxSkew:   time.Duration(conf.HMACMaxSkew) * time.Second,
+		replays:   newReplayCache(),
+		certRoles: make(map[string]Role, len(conf.ClientCertRoles)),
+	}
+	if !a.enabled {
+		return a, nil
+	}
+
+	if conf.TokenFile != "" {
+		lines, err := readCredentialFile(conf.TokenFile, 3, 4)
+		if err != nil {
+			return nil, errors.Wrapf(err, "token file %s", conf.TokenFile)
+		}
+		for _, fields := range lines {
+			role, err := ParseRole(fields[1])
+			if err != nil {
+				return nil, errors.Wrapf(err, "token of %s", fields[2])
+			}
+			namespaces, err := parseCredentialNamespaces(fields[3:])
+			if err != nil {
+				return nil, errors.Wrapf(err, "token of %s", fields[2])
+			}
+			a.tokens[fields[0]] = Caller{Identity: "token:" + fields[2], Role: role, Namespaces: namespaces}
+		}
+	}
+	if conf.HMACKeyFile != "" {
+		lines, err := readCredentialFile(conf.HMACKeyFile, 3, 4)
+		if err != nil {
+			return nil, errors.Wrapf(err, "hmac key file %s", conf.HMACKeyFile)
+		}
+		for _, fields := range lines {
+			role, err := ParseRole(fields[2])
+			if err != nil {
+				return nil, errors.Wrapf(err, "hmac key %s", fields[0])
+			}
+			namespaces, err := parseCredentialNamespaces(fields[3:])
+			if err != nil {
+				return nil, errors.Wrapf(err, "hmac key %s", fields[0])
+			}
+			a.hmacKeys[fields[0]] = hmacKey{secret: []byte(fields[1]), role: role, namespaces: namespaces}
+		}
+	}
+	for cn, name := range conf.ClientCertRoles {
+		role, err := ParseRole(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "client certificate %s", cn)
+		}
+		a.certRoles[cn] = role
+	}
+	if conf.AnonymousRole != "" {
+		role, err := ParseRole(conf.AnonymousRole)
+		if err != nil {
+			return nil, err
+		}
+		a.anonymous = role
+	}
+
+	return a, nil
+}
+
+// HMACSignature returns the hex signature of a request. The signed string is
+// "<method>\n<request uri>\n<unix timestamp>\n<hex sha256 of body>".
+func HMACSignature(secret []byte, method, uri, timestamp string, body []byte) string {
+	bodySum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, uri, timestamp, hex.EncodeToString(bodySum[:]))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HMACNonceSignature returns the hex signature of a request with HMACNonceHeader. The
+// nonce is signed after the timestamp, so a client can send the same request again.
+func HMACNonceSignature(secret []byte, method, uri, timestamp, nonce string, body []byte) string {
+	return HMACSignature(secret, method, uri, timestamp+"\n"+nonce, body)
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{seen: make(map[string]time.Time)}
+}
+
+// add returns false if @key has been added and does not expire at @now. The
+// expired keys are pruned at most once a second.
+func (c *replayCache) add(key string, expiry time.Time, now time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if !now.Before(c.nextPrune) {
+		for k, t := range c.seen {
+			if t.Before(now) {
+				delete(c.seen, k)
+			}
+		}
+		c.nextPrune = now.Add(time.Second)
+	}
+	if t, ok := c.seen[key]; ok && !t.Before(now) {
+		return false
+	}
+	c.seen[key] = expiry
+
+	return true
+}
+
+// verifyHMAC checks "Authorization: HMAC-SHA256 <key id>:<signature>". A body larger
+// than MaxHMACBodySize is rejected, and so is a request whose signature, or nonce if
+// it has HMACNonceHeader, has been seen within the max skew.
+func (a *APIAuthenticator) verifyHMAC(r *http.Request, credential string) (Caller, error) {
+	fields := strings.SplitN(credential, ":", 2)
+	if len(fields) != 2 {
+		return Caller{}, fmt.Errorf("illegal %s credential", HMACScheme)
+	}
+	key, ok := a.hmacKeys[fields[0]]
+	if !ok {
+		return Caller{}, fmt.Errorf("unknown hmac key %q", fields[0])
+	}
+
+	timestamp := r.Header.Get(HMACTimestampHeader)
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Caller{}, fmt.Errorf("illegal %s %q", HMACTimestampHeader, timestamp)
+	}
+	skew := time.Since(time.Unix(sec, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if a.maxSkew < skew {
+		return Caller{}, fmt.Errorf("request was signed %s ago", skew)
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, MaxHMACBodySize))
+		r.Body.Close()
+		if err != nil {
+			return Caller{}, errors.Wrapf(err, "body of request signed by hmac key %s", fields[0])
+		}
+		// the handler reads the body again
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	expected := HMACSignature(key.secret, r.Method, r.URL.RequestURI(), timestamp, body)
+	nonce := r.Header.Get(HMACNonceHeader)
+	if nonce != "" {
+		expected = HMACNonceSignature(key.secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	}
+	if !hmac.Equal([]byte(expected), []byte(fields[1])) {
+		return Caller{}, fmt.Errorf("signature mismatch of hmac key %s", fields[0])
+	}
+
+	// the signature is accepted until its timestamp is out of the max skew
+	replayKey := fields[0] + ":" + expected
+	if nonce != "" {
+		replayKey = fields[0] + ":nonce:" + nonce
+	}
+	if !a.replays.add(replayKey, time.Unix(sec, 0).Add(a.maxSkew), time.Now()) {
+		return Caller{}, fmt.Errorf("replayed request of hmac key %s", fields[0])
+	}
+
+	return Caller{Identity: "hmac:" + fields[0], Role: key.role, Namespaces: key.namespaces}, nil
+}
+
+// Authenticate returns the caller of @r. A request with an illegal credential is
+// rejected even if anonymous callers are allowed.
+func (a *APIAuthenticator) Authenticate(r *http.Request) (Caller, error) {
+	if !a.enabled {
+		return Caller{Identity: "anonymous", Role: RoleAdmin}, nil
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 {
+		cn := r.TLS.PeerCertificates[0].Subject.CommonName
+		if role, ok := a.certRoles[cn]; ok {
+			return Caller{Identity: "cert:" + cn, Role: role}, nil
+		}
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		if a.anonymous == RoleNone {
+			return Caller{}, fmt.Errorf("no credential")
+		}
+		return Caller{Identity: "anonymous", Role: a.anonymous}, nil
+	}
+	fields := strings.SplitN(authorization, " ", 2)
+	if len(fields) != 2 {
+		return Caller{}, fmt.Errorf("illegal Authorization header")
+	}
+	switch scheme, credential := fields[0], strings.TrimSpace(fields[1]); {
+	case strings.EqualFold(scheme, "Bearer"):
+		if caller, ok := a.tokens[credential]; ok {
+			return caller, nil
+		}
+		return Caller{}, fmt.Errorf("invalid bearer token")
+	case scheme == HMACScheme:
+		return a.verifyHMAC(r, credential)
+	}
+
+	return Caller{}, fmt.Errorf("unsupported authorization scheme %q", fields[0])
+}
+
+// set replaces the authenticator. The seen signed requests are kept, so a reload
+// can not be used to replay them.
+func (h *authenticatorHolder) set(auth *APIAuthenticator) {
+	h.Lock()
+	defer h.Unlock()
+	if h.auth != nil && auth != nil {
+		auth.replays = h.auth.replays
+	}
+	h.auth = auth
+}
+
+func (h *authenticatorHolder) get() *APIAuthenticator {
+	h.RLock()
+	defer h.RUnlock()
+	return h.auth
+}
+
+// requiredRole returns the role needed by the request @r. A route under
+// "/clusters/{cluster}" needs the same role as the one without the prefix.
+func requiredRole(r *http.Request) Role {
+	path := trimClusterPrefix(r.URL.Path)
+	for _, route := range routeRoles {
+		if strings.HasPrefix(path, route.prefix) {
+			if r.Method == "GET" || r.Method == "HEAD" {
+				return route.read
+			}
+			return route.write
+		}
+	}
+
+	return RoleAdmin
+}
+
+// CanAccessNamespace returns true if the caller can access namespace @ns
+func (c Caller) CanAccessNamespace(ns string) bool {
+	if c.Namespaces == nil {
+		return true
+	}
+	for _, name := range c.Namespaces {
+		if name == ns {
+			return true
+		}
+	}
+
+	return false
+}
+
+// canAccessPath returns true if the caller can access @path. A caller with
+// namespaces can only list namespaces and access its own ones of any cluster.
+func (c Caller) canAccessPath(path string) bool {
+	if c.Namespaces == nil {
+		return true
+	}
+
+	path = trimClusterPrefix(path)
+	prefix := APIV1Prefix + "/namespaces"
+	if path == prefix || path == prefix+"/" {
+		return true
+	}
+	if !strings.HasPrefix(path, prefix+"/") {
+		return false
+	}
+	ns := strings.SplitN(strings.TrimPrefix(path, prefix+"/"), "/", 2)[0]
+
+	return c.CanAccessNamespace(ns)
+}
+
+// getCaller returns the caller of @r set by AuthMiddleware
+func getCaller(r *http.Request) Caller {
+	if caller, ok := r.Context().Value(callerKey{}).(Caller); ok {
+		return caller
+	}
+	return Caller{Identity: "-"}
+}
+
+// AuthMiddleware authenticates the caller and checks its role against the route.
+func AuthMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := requiredRole(r)
+		if role == RoleNone {
+			// a caller is not needed, but an authenticated one may get more details
+			if caller, err := apiAuth.get().Authenticate(r); err == nil {
+				r = r.WithContext(context.WithValue(r.Context(), callerKey{}, caller))
+			}
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		caller, err := apiAuth.get().Authenticate(r)
+		if err != nil {
+			Log.Warn("%s\t%s\t%s\tunauthorized: %v", r.RemoteAddr, r.Method, r.URL, err)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeResponse(w, EC_UNAUTHORIZED, ErrorCode(EC_UNAUTHORIZED).String())
+			return
+		}
+		if caller.Role < role {
+			Log.Warn("%s\t%s\t%s\t%s(%s) is forbidden, %s is needed",
+				r.RemoteAddr, r.Method, r.URL, caller.Identity, caller.Role, role)
+			writeResponse(w, EC_FORBIDDEN, fmt.Sprintf("role %s is needed", role))
+			return
+		}
+		if !caller.canAccessPath(r.URL.Path) {
+			Log.Warn("%s\t%s\t%s\t%s is forbidden, it can only access namespaces %v",
+				r.RemoteAddr, r.Method, r.URL, caller.Identity, caller.Namespaces)
+			writeResponse(w, EC_FORBIDDEN, fmt.Sprintf("only namespaces %v can be accessed", caller.Namespaces))
+			return
+		}
+
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)))
+	})
+}
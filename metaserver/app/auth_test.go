@@ -0,0 +1,243 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAuthenticator(t *testing.T) *APIAuthenticator {
+	dir, err := ioutil.TempDir("", "metaserver")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() = error:%#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "tokens")
+	keyFile := filepath.Join(dir, "hmac_keys")
+	ioutil.WriteFile(tokenFile, []byte("# token role identity\nt-reader reader alice\nt-admin admin ops\nt-deployer operator deployer product-a,product-b\n"), 0600)
+	ioutil.WriteFile(keyFile, []byte("proxy secret operator\n"), 0600)
+
+	auth, err := NewAPIAuthenticator(SectionAPIAuth{
+		Enabled:     true,
+		TokenFile:   tokenFile,
+		HMACKeyFile: keyFile,
+		HMACMaxSkew: DefaultHMACMaxSkew,
+	})
+	if err != nil {
+		t.Fatalf("NewAPIAuthenticator() = error:%#v", err)
+	}
+
+	return auth
+}
+
+func TestAPIAuthenticator_Authenticate(t *testing.T) {
+	auth := newTestAuthenticator(t)
+
+	r := httptest.NewRequest("GET", "/cluster/meta", nil)
+	r.Header.Set("Authorization", "Bearer t-reader")
+	caller, err := auth.Authenticate(r)
+	if err != nil || caller.Role != RoleReader || caller.Identity != "token:alice" {
+		t.Errorf("Authenticate() = {caller:%+v, error:%v}", caller, err)
+	}
+
+	r.Header.Set("Authorization", "Bearer t-deployer")
+	caller, err = auth.Authenticate(r)
+	if err != nil || caller.Role != RoleOperator || !reflect.DeepEqual(caller.Namespaces, []string{"product-a", "product-b"}) {
+		t.Errorf("Authenticate() = {caller:%+v, error:%v}", caller, err)
+	}
+
+	r.Header.Set("Authorization", "Bearer t-unknown")
+	if _, err = auth.Authenticate(r); err == nil {
+		t.Errorf("unknown token should be rejected")
+	}
+
+	r.Header.Del("Authorization")
+	if _, err = auth.Authenticate(r); err == nil {
+		t.Errorf("request without credential should be rejected")
+	}
+
+	body := `{"name":"cache1"}`
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	r = httptest.NewRequest("POST", "/v1/instances?x=1", strings.NewReader(body))
+	r.Header.Set(HMACTimestampHeader, timestamp)
+	signature := HMACSignature([]byte("secret"), "POST", "/v1/instances?x=1", timestamp, []byte(body))
+	r.Header.Set("Authorization", HMACScheme+" proxy:"+signature)
+	caller, err = auth.Authenticate(r)
+	if err != nil || caller.Role != RoleOperator || caller.Identity != "hmac:proxy" {
+		t.Errorf("Authenticate() = {caller:%+v, error:%v}", caller, err)
+	}
+
+	// signed with another body
+	r = httptest.NewRequest("POST", "/v1/instances?x=1", strings.NewReader(`{"name":"cache2"}`))
+	r.Header.Set(HMACTimestampHeader, timestamp)
+	r.Header.Set("Authorization", HMACScheme+" proxy:"+signature)
+	if _, err = auth.Authenticate(r); err == nil {
+		t.Errorf("request with wrong signature should be rejected")
+	}
+
+	// expired signature
+	timestamp = strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	r = httptest.NewRequest("POST", "/v1/instances?x=1", strings.NewReader(body))
+	r.Header.Set(HMACTimestampHeader, timestamp)
+	signature = HMACSignature([]byte("secret"), "POST", "/v1/instances?x=1", timestamp, []byte(body))
+	r.Header.Set("Authorization", HMACScheme+" proxy:"+signature)
+	if _, err = auth.Authenticate(r); err == nil {
+		t.Errorf("expired signature should be rejected")
+	}
+}
+
+func TestAPIAuthenticator_verifyHMAC(t *testing.T) {
+	auth := newTestAuthenticator(t)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	newRequest := func(body, nonce string) *http.Request {
+		r := httptest.NewRequest("POST", "/v1/instances", strings.NewReader(body))
+		r.Header.Set(HMACTimestampHeader, timestamp)
+		signature := HMACSignature([]byte("secret"), "POST", "/v1/instances", timestamp, []byte(body))
+		if nonce != "" {
+			r.Header.Set(HMACNonceHeader, nonce)
+			signature = HMACNonceSignature([]byte("secret"), "POST", "/v1/instances", timestamp, nonce, []byte(body))
+		}
+		r.Header.Set("Authorization", HMACScheme+" proxy:"+signature)
+		return r
+	}
+
+	body := `{"name":"cache1"}`
+	if _, err := auth.Authenticate(newRequest(body, "")); err != nil {
+		t.Fatalf("Authenticate() = error:%v", err)
+	}
+	if _, err := auth.Authenticate(newRequest(body, "")); err == nil {
+		t.Errorf("replayed request should be rejected")
+	}
+
+	// the same request is sent again with another nonce
+	for _, nonce := range []string{"n1", "n2"} {
+		if _, err := auth.Authenticate(newRequest(body, nonce)); err != nil {
+			t.Errorf("Authenticate() with nonce %s = error:%v", nonce, err)
+		}
+	}
+	if _, err := auth.Authenticate(newRequest(`{"name":"cache2"}`, "n1")); err == nil {
+		t.Errorf("request with a used nonce should be rejected")
+	}
+	// the nonce is signed
+	r := newRequest(body, "n3")
+	r.Header.Set(HMACNonceHeader, "n4")
+	if _, err := auth.Authenticate(r); err == nil {
+		t.Errorf("request with a changed nonce should be rejected")
+	}
+
+	// the seen requests are kept when the authenticator is reloaded
+	apiAuth.set(auth)
+	defer apiAuth.set(nil)
+	apiAuth.set(newTestAuthenticator(t))
+	if _, err := apiAuth.get().Authenticate(newRequest(body, "")); err == nil {
+		t.Errorf("replayed request should be rejected after reload")
+	}
+
+	large := `{"name":"` + strings.Repeat("x", MaxHMACBodySize) + `"}`
+	if _, err := auth.Authenticate(newRequest(large, "")); err == nil {
+		t.Errorf("request with a body larger than %d bytes should be rejected", MaxHMACBodySize)
+	}
+}
+
+func Test_replayCache(t *testing.T) {
+	c := newReplayCache()
+	now := time.Now()
+	if !c.add("k1", now.Add(time.Minute), now) || c.add("k1", now.Add(time.Minute), now) {
+		t.Errorf("k1 should be added once")
+	}
+	if !c.add("k2", now.Add(time.Second), now) {
+		t.Errorf("k2 should be added")
+	}
+
+	// k2 expires and is pruned
+	now = now.Add(2 * time.Second)
+	if !c.add("k3", now.Add(time.Minute), now) || c.add("k1", now.Add(time.Minute), now) {
+		t.Errorf("k3 should be added and k1 should not")
+	}
+	if _, ok := c.seen["k2"]; ok || len(c.seen) != 2 {
+		t.Errorf("seen = %v, want k1 and k3", c.seen)
+	}
+	if !c.add("k2", now.Add(time.Minute), now) {
+		t.Errorf("expired k2 should be added again")
+	}
+}
+
+func Test_requiredRole(t *testing.T) {
+	cases := []struct {
+		method string
+		path   string
+		role   Role
+	}{
+		{"GET", "/readyz", RoleNone},
+		{"GET", "/healthz", RoleNone},
+		{"GET", "/metrics", RoleReader},
+		{"GET", "/cluster/meta", RoleReader},
+		{"POST", "/cluster/addInstance", RoleOperator},
+		{"GET", "/v1/instances/cache1", RoleReader},
+		{"DELETE", "/v1/instances/cache1", RoleOperator},
+		{"GET", "/clusters", RoleReader},
+		{"DELETE", "/clusters/dc2/v1/instances/cache1", RoleOperator},
+		{"GET", "/clusters/dc2/stack", RoleAdmin},
+		{"GET", "/stack", RoleAdmin},
+		{"GET", "/debug/pprof/", RoleAdmin},
+		{"GET", "/unknown", RoleAdmin},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.path, nil)
+		if role := requiredRole(r); role != c.role {
+			t.Errorf("requiredRole(%s %s) = %s, want %s", c.method, c.path, role, c.role)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	apiAuth.set(newTestAuthenticator(t))
+	defer apiAuth.set(nil)
+
+	var caller Caller
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller = getCaller(r)
+	}))
+	r := httptest.NewRequest("GET", "/stack", nil)
+	r.Header.Set("Authorization", "Bearer t-admin")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || caller.Identity != "token:ops" {
+		t.Errorf("response code:%d, caller:%+v", w.Code, caller)
+	}
+}
+
+func TestCaller_canAccessPath(t *testing.T) {
+	caller := Caller{Identity: "token:deployer", Role: RoleOperator, Namespaces: []string{"product-a"}}
+	cases := []struct {
+		path   string
+		access bool
+	}{
+		{"/v1/namespaces", true},
+		{"/v1/namespaces/product-a", true},
+		{"/v1/namespaces/product-a/instances/cache1/state", true},
+		{"/v1/namespaces/product-b/instances", false},
+		{"/v1/namespaces/product-ab", false},
+		{"/clusters/dc2/v1/namespaces/product-a/instances", true},
+		{"/clusters", false},
+		{"/v1/instances", false},
+		{"/cluster/meta", false},
+	}
+	for _, c := range cases {
+		if access := caller.canAccessPath(c.path); access != c.access {
+			t.Errorf("canAccessPath(%s) = %v", c.path, access)
+		}
+	}
+
+	if !(Caller{Role: RoleAdmin}).canAccessPath("/cluster/meta") {
+		t.Errorf("caller without namespaces can access all routes")
+	}
+}
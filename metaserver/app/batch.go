@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	BatchOpAdd    = "add"
+	BatchOpRemove = "remove"
+
+	BatchItemApplied    = "applied"
+	BatchItemFailed     = "failed"
+	BatchItemRolledBack = "rolled_back"
+	BatchItemSkipped    = "skipped"
+	BatchItemInvalid    = "invalid"
+)
+
+type (
+	// BatchRequest adds and removes instances in one transaction
+	BatchRequest struct {
+		Add    []InstanceSpec `json:"add"`
+		Remove []string       `json:"remove"`
+	}
+
+	// BatchItemResult is the result of one item of a BatchRequest
+	BatchItemResult struct {
+		Op     string `json:"op"`
+		Name   string `json:"name"`
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+	}
+
+	batchItem struct {
+		op   string
+		inst gxredis.RawInstance
+		// monitor params of a removed instance, used to roll back
+		removed *gxredis.RawInstance
+	}
+)
+
+var (
+	batchMutex sync.Mutex
+	// ErrIllegalBatch means no item of the batch has been applied for some illegal items
+	ErrIllegalBatch = fmt.Errorf("illegal batch request")
+)
+
+// validate checks all the items. It returns the items to apply and the results,
+// in which the illegal items are marked as invalid.
+func (req *BatchRequest) validate(exists func(string) bool) ([]batchItem, []BatchItemResult, bool) {
+	var (
+		ok      = true
+		items   []batchItem
+		results []BatchItemResult
+		names   = make(map[string]bool, len(req.Add)+len(req.Remove))
+	)
+
+	check := func(op string, name string, err error) {
+		result := BatchItemResult{Op: op, Name: name, Status: BatchItemSkipped}
+		switch {
+		case err != nil:
+		case name == "":
+			err = fmt.Errorf("instance name is empty")
+		case names[name]:
+			err = fmt.Errorf("instance %s appears more than once", name)
+		case op == BatchOpAdd && exists(name):
+			err = fmt.Errorf("instance %s already exists", name)
+		case op == BatchOpRemove && !exists(name):
+			err = fmt.Errorf("instance %s not found", name)
+		}
+		if err != nil {
+			ok = false
+			result.Status = BatchItemInvalid
+			result.Error = err.Error()
+		}
+		names[name] = true
+		results = append(results, result)
+	}
+
+	for _, spec := range req.Add {
+		inst, err := spec.RawInstance()
+		if err == nil && len(spec.Replicas) != 0 {
+			err = fmt.Errorf("replicas are not supported in batch")
+		}
+		if err == nil {
+			if err = inst.Validate(); err == nil {
+				err = inst.Addr.Validate()
+			}
+		}
+		check(BatchOpAdd, spec.Name, err)
+		items = append(items, batchItem{op: BatchOpAdd, inst: inst})
+	}
+	for _, name := range req.Remove {
+		check(BatchOpRemove, name, nil)
+		items = append(items, batchItem{op: BatchOpRemove, inst: gxredis.RawInstance{Name: name}})
+	}
+	if len(items) == 0 {
+		ok = false
+	}
+
+	return items, results, ok
+}
+
+// getMonitorParams returns the monitor params of instance @name from the first
+// reachable sentinel. The address of the master is the one known by the sentinels.
+func (w *SentinelWorker) getMonitorParams(name string) (*gxredis.RawInstance, error) {
+	var err error
+
+	for _, addr := range w.getSentinels() {
+		var (
+			conn   redis.Conn
+			params map[string]string
+		)
+		if conn, err = dialSentinel(addr); err != nil {
+			continue
+		}
+		params, err = redis.StringMap(conn.Do("sentinel", "master", name))
+		conn.Close()
+		if err != nil {
+			continue
+		}
+
+		atoi := func(key string) int32 {
+			i, _ := strconv.Atoi(params[key])
+			return int32(i)
+		}
+		return &gxredis.RawInstance{
+			Name:            name,
+			Addr:            &gxredis.IPAddr{IP: params["ip"], Port: atoi("port")},
+			Epoch:           atoi("quorum"),
+			Sdowntime:       atoi("down-after-milliseconds") / 1000,
+			FailoverTimeout: atoi("failover-timeout") / 1000,
+		}, nil
+	}
+
+	if err == nil {
+		err = fmt.Errorf("sentinel list is empty")
+	}
+	return nil, errors.Wrapf(err, "failed to get monitor params of %s", name)
+}
+
+// applyBatch applies the items of @req in turn. If any item fails, the applied
+// items are rolled back in reverse order. A removed instance is monitored again
+// with its former params, but its other sentinel config such as auth-pass is lost.
+func (w *SentinelWorker) applyBatch(req BatchRequest) ([]BatchItemResult, error) {
+	batchMutex.Lock()
+	defer batchMutex.Unlock()
+
+	items, results, ok := req.validate(func(name string) bool {
+		_, ok := w.getInstance(name)
+		return ok
+	})
+	if !ok {
+		return results, ErrIllegalBatch
+	}
+
+	for i := range items {
+		item := &items[i]
+		var err error
+		switch item.op {
+		case BatchOpAdd:
+			err = w.addInstance(item.inst)
+		case BatchOpRemove:
+			if item.removed, err = w.getMonitorParams(item.inst.Name); err == nil {
+				err = w.removeInstance(item.inst.Name)
+			}
+		}
+		Log.Info("batch %s instance %s, error:%#v", item.op, item.inst.Name, err)
+		if err == nil {
+			results[i].Status = BatchItemApplied
+			continue
+		}
+
+		results[i].Status = BatchItemFailed
+		results[i].Error = err.Error()
+		for j := i - 1; 0 <= j; j-- {
+			var undoErr error
+			switch items[j].op {
+			case BatchOpAdd:
+				undoErr = w.removeInstance(items[j].inst.Name)
+			case BatchOpRemove:
+				undoErr = w.addInstance(*items[j].removed)
+			}
+			if undoErr != nil {
+				Log.Error("failed to roll back batch %s instance %s, error:%#v", items[j].op, items[j].inst.Name, undoErr)
+				results[j].Error = "roll back: " + undoErr.Error()
+				continue
+			}
+			results[j].Status = BatchItemRolledBack
+		}
+		return results, errors.Wrapf(err, "batch %s instance %s", item.op, item.inst.Name)
+	}
+
+	return results, nil
+}
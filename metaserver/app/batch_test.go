@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBatchRequest_validate(t *testing.T) {
+	exists := func(name string) bool { return name == "cache1" || name == "cache2" }
+
+	req := BatchRequest{
+		Add:    []InstanceSpec{{Name: "cache3", Addr: "192.168.11.100:4003", Epoch: 2, Sdowntime: 15, FailoverTimeout: 450}},
+		Remove: []string{"cache1"},
+	}
+	items, results, ok := req.validate(exists)
+	if !ok || len(items) != 2 || len(results) != 2 {
+		t.Fatalf("validate() = {items:%+v, results:%+v, ok:%v}", items, results, ok)
+	}
+	for _, result := range results {
+		if result.Status != BatchItemSkipped {
+			t.Errorf("result:%+v", result)
+		}
+	}
+
+	req = BatchRequest{
+		Add: []InstanceSpec{
+			{Name: "cache1", Addr: "192.168.11.100:4001"},
+			{Name: "cache3", Addr: "192.168.11.100"},
+			{Name: "cache4", Addr: "192.168.11.100:4004"},
+		},
+		Remove: []string{"cache4", "cache5", "cache2"},
+	}
+	_, results, ok = req.validate(exists)
+	if ok {
+		t.Fatalf("validate() should fail")
+	}
+	expected := []string{
+		BatchItemInvalid, // exists
+		BatchItemInvalid, // illegal addr
+		BatchItemSkipped,
+		BatchItemInvalid, // duplicate
+		BatchItemInvalid, // not found
+		BatchItemSkipped,
+	}
+	for i, result := range results {
+		if result.Status != expected[i] {
+			t.Errorf("results[%d] = %+v, want status %s", i, result, expected[i])
+		}
+	}
+
+	if _, _, ok = (&BatchRequest{}).validate(exists); ok {
+		t.Errorf("empty batch should be illegal")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCluster is the cluster of the sentinels of the redis section. It is
+	// also served by the routes without the cluster prefix.
+	DefaultCluster = "default"
+	ClusterPrefix  = "/clusters"
+)
+
+type (
+	// ClusterInfo is the summary of a cluster
+	ClusterInfo struct {
+		Name       string   `json:"name"`
+		Sentinels  []string `json:"sentinels"`
+		MetaDBName string   `json:"meta_db_name,omitempty"`
+		Ready      bool     `json:"ready"`
+		Version    int32    `json:"version"`
+		// url of a peer metaserver
+		Peer  string `json:"peer,omitempty"`
+		Stale bool   `json:"stale,omitempty"`
+	}
+
+	workerKey struct{}
+)
+
+var (
+	// workers of all clusters, including worker of DefaultCluster.
+	// It is not changed after startClusterWorkers.
+	clusterWorkers map[string]*SentinelWorker
+)
+
+// startClusterWorkers creates the workers of all clusters, which start in the
+// background, so neither an unreachable cluster delays the others nor the api
+// waits for the clusters.
+func startClusterWorkers() {
+	var (
+		names   = getConf().Redis.ClusterNames()
+		workers = make(map[string]*SentinelWorker, len(names))
+	)
+
+	for _, name := range names {
+		workers[name] = NewSentinelWorker(name)
+	}
+
+	worker = workers[DefaultCluster]
+	clusterWorkers = workers
+}
+
+func closeClusterWorkers() {
+	var wg sync.WaitGroup
+	for _, sw := range clusterWorkers {
+		wg.Add(1)
+		go func(sw *SentinelWorker) {
+			defer wg.Done()
+			sw.Close()
+		}(sw)
+	}
+	wg.Wait()
+}
+
+// workerOf returns the worker of the cluster of @r, which is set by clusterHandler
+func workerOf(r *http.Request) *SentinelWorker {
+	if sw, ok := r.Context().Value(workerKey{}).(*SentinelWorker); ok {
+		return sw
+	}
+	return worker
+}
+
+// splitClusterPath splits "/clusters/{cluster}/{path}" into the cluster and "/{path}".
+// It returns false if @path is not under a cluster.
+func splitClusterPath(path string) (string, string, bool) {
+	if !strings.HasPrefix(path, ClusterPrefix+"/") {
+		return "", path, false
+	}
+	fields := strings.SplitN(strings.TrimPrefix(path, ClusterPrefix+"/"), "/", 2)
+	if fields[0] == "" {
+		return "", path, false
+	}
+	if len(fields) == 1 {
+		return fields[0], "/", true
+	}
+
+	return fields[0], "/" + fields[1], true
+}
+
+// trimClusterPrefix returns @path without "/clusters/{cluster}"
+func trimClusterPrefix(path string) string {
+	_, path, _ = splitClusterPath(path)
+	return path
+}
+
+// clusterInfos returns the summary of all local clusters and peers
+func clusterInfos() []ClusterInfo {
+	infos := make([]ClusterInfo, 0, len(clusterWorkers)+len(peerWatchers))
+	for _, name := range getConf().Redis.ClusterNames() {
+		sw, ok := clusterWorkers[name]
+		if !ok {
+			continue
+		}
+		conf := sw.conf()
+		sw.RLock()
+		infos = append(infos, ClusterInfo{
+			Name:       name,
+			Sentinels:  append([]string{}, sw.sentinels...),
+			MetaDBName: conf.MetaDBName,
+			Ready:      sw.ready,
+			Version:    sw.meta.Version,
+		})
+		sw.RUnlock()
+	}
+	now := time.Now()
+	for _, name := range peerNames() {
+		_, status := peerWatchers[name].snapshot(now)
+		infos = append(infos, ClusterInfo{
+			Name:      name,
+			Sentinels: []string{},
+			Ready:     status.Connected && !status.Stale,
+			Version:   status.Version,
+			Peer:      status.URL,
+			Stale:     status.Stale,
+		})
+	}
+
+	return infos
+}
+
+// clusterHandler serves GET /clusters, and serves /clusters/{cluster}/{path} by
+// @handler as /{path} with the worker of the cluster. The meta of a peer is served
+// read-only by servePeer.
+func clusterHandler(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(workerKey{}).(*SentinelWorker); ok {
+			// nested cluster prefix
+			writeResponse(w, EC_NOT_FOUND, r.URL.Path)
+			return
+		}
+
+		cluster, path, ok := splitClusterPath(r.URL.Path)
+		if !ok {
+			if strings.Trim(r.URL.Path, "/") != strings.Trim(ClusterPrefix, "/") {
+				writeResponse(w, EC_NOT_FOUND, r.URL.Path)
+				return
+			}
+			if r.Method != "GET" {
+				w.Header().Set("Allow", "GET")
+				writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+				return
+			}
+			writeJSON(w, http.StatusOK, clusterInfos())
+			return
+		}
+		sw, ok := clusterWorkers[cluster]
+		if !ok {
+			if p, ok := peerWatchers[cluster]; ok {
+				servePeer(w, r, p, path)
+				return
+			}
+			writeResponse(w, EC_NOT_FOUND, fmt.Sprintf("cluster %s not found", cluster))
+			return
+		}
+
+		u := *r.URL
+		u.Path, u.RawPath = path, ""
+		r = r.WithContext(context.WithValue(r.Context(), workerKey{}, sw))
+		r.URL = &u
+		handler.ServeHTTP(w, r)
+	}
+}
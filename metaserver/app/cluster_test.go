@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_splitClusterPath(t *testing.T) {
+	cases := []struct {
+		path    string
+		cluster string
+		rest    string
+		ok      bool
+	}{
+		{"/clusters/dc2/v1/instances/cache1", "dc2", "/v1/instances/cache1", true},
+		{"/clusters/dc2", "dc2", "/", true},
+		{"/clusters", "", "/clusters", false},
+		{"/clusters/", "", "/clusters/", false},
+		{"/v1/instances", "", "/v1/instances", false},
+	}
+	for _, c := range cases {
+		cluster, rest, ok := splitClusterPath(c.path)
+		if cluster != c.cluster || rest != c.rest || ok != c.ok {
+			t.Errorf("splitClusterPath(%s) = {%s, %s, %v}", c.path, cluster, rest, ok)
+		}
+	}
+}
+
+func TestSectionRedis_Cluster(t *testing.T) {
+	conf := SectionRedis{
+		Sentinels:        []string{"127.0.0.1:26380"},
+		MetaDBName:       "meta",
+		MetaHashtable:    "exocet_meta",
+		MetaVersion:      "version",
+		MetaInstNameList: "instances",
+		MetaCacheFile:    "cache.json",
+		UpdateInterval:   5,
+		Clusters: []SectionCluster{
+			{Name: "dc2", Sentinels: []string{"10.0.0.1:26380"}, MetaDBName: "meta2", MetaHashtable: "dc2_meta"},
+		},
+	}
+
+	if c := conf.Cluster(DefaultCluster); !reflect.DeepEqual(c, conf) {
+		t.Errorf("Cluster(%s) = %+v", DefaultCluster, c)
+	}
+	c := conf.Cluster("dc2")
+	if !reflect.DeepEqual(c.Sentinels, []string{"10.0.0.1:26380"}) || c.MetaDBName != "meta2" ||
+		c.MetaHashtable != "dc2_meta" || c.MetaVersion != "version" || c.MetaCacheFile != "" ||
+		c.UpdateInterval != 5 || c.Clusters != nil {
+		t.Errorf("Cluster(dc2) = %+v", c)
+	}
+	if names := conf.ClusterNames(); !reflect.DeepEqual(names, []string{DefaultCluster, "dc2"}) {
+		t.Errorf("ClusterNames() = %v", names)
+	}
+}
+
+func Test_clusterHandler(t *testing.T) {
+	defaultWorker, dc2Worker := &SentinelWorker{cluster: DefaultCluster}, &SentinelWorker{cluster: "dc2"}
+	oldWorker, oldWorkers := worker, clusterWorkers
+	worker = defaultWorker
+	clusterWorkers = map[string]*SentinelWorker{DefaultCluster: defaultWorker, "dc2": dc2Worker}
+	defer func() {
+		worker, clusterWorkers = oldWorker, oldWorkers
+	}()
+
+	var (
+		sw   *SentinelWorker
+		path string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/instances", func(w http.ResponseWriter, r *http.Request) {
+		sw, path = workerOf(r), r.URL.Path
+	})
+	mux.HandleFunc(ClusterPrefix+"/", clusterHandler(mux))
+
+	cases := []struct {
+		path   string
+		code   int
+		worker *SentinelWorker
+	}{
+		{"/v1/instances", http.StatusOK, defaultWorker},
+		{"/clusters/dc2/v1/instances", http.StatusOK, dc2Worker},
+		{"/clusters/default/v1/instances", http.StatusOK, defaultWorker},
+		{"/clusters/dc3/v1/instances", http.StatusNotFound, nil},
+		{"/clusters/dc2/clusters/default/v1/instances", http.StatusNotFound, nil},
+	}
+	for _, c := range cases {
+		sw, path = nil, ""
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.code || sw != c.worker || (sw != nil && path != "/v1/instances") {
+			t.Errorf("GET %s = {code:%d, worker:%p, path:%s}", c.path, w.Code, sw, path)
+		}
+	}
+}
@@ -1,7 +1,17 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 import (
@@ -10,8 +20,10 @@ import (
 
 // ConfYaml is config structure.
 type ConfYaml struct {
-	Core  SectionCore  `yaml:"core"`
-	Redis SectionRedis `yaml:"redis"`
+	Core       SectionCore       `yaml:"core"`
+	Redis      SectionRedis      `yaml:"redis"`
+	Federation SectionFederation `yaml:"federation"`
+	Webhook    SectionWebhook    `yaml:"webhook"`
 }
 
 // SectionPID is sub section of config.
@@ -23,10 +35,82 @@ type SectionPID struct {
 
 // SectionCore is sub section of config.
 type SectionCore struct {
-	Mode            string     `yaml:"mode"`
-	FailFastTimeout int        `yaml:"fail_fast_timeout"`
-	BindAddr        string     `yaml:"bind_addr"`
-	PID             SectionPID `yaml:"pid"`
+	Mode            string           `yaml:"mode"`
+	FailFastTimeout int              `yaml:"fail_fast_timeout"`
+	BindAddr        string           `yaml:"bind_addr"`
+	PID             SectionPID       `yaml:"pid"`
+	APIAuth         SectionAPIAuth   `yaml:"api_auth"`
+	TLS             SectionServerTLS `yaml:"tls"`
+	// listener of pprof, /stack, /debug/vars and /debug/config. empty means disabled.
+	DebugBindAddr string `yaml:"debug_bind_addr"`
+	// deprecated and ignored, the log is configured by the log configure file
+	LogSize int `yaml:"log_size,omitempty"`
+}
+
+// SectionServerTLS is the TLS config of the HTTP listener. The files are reloaded
+// without restart after they change.
+type SectionServerTLS struct {
+	Enabled      bool   `yaml:"enabled"`
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// none, verify_if_given or require. default is none.
+	ClientAuth string `yaml:"client_auth"`
+	// 1.0, 1.1, 1.2 or 1.3. default is 1.2.
+	MinVersion string `yaml:"min_version"`
+	// if it is not empty, the routes that need more than reader role are only
+	// served on this mTLS listener instead of bind_addr.
+	AdminBindAddr string `yaml:"admin_bind_addr"`
+}
+
+// SectionAPIAuth is the authentication and authorization config of the HTTP API.
+// A caller is identified by its client certificate, bearer token or HMAC signature,
+// and gets one of the roles reader, operator and admin.
+type SectionAPIAuth struct {
+	Enabled bool `yaml:"enabled"`
+	// every line is "<token> <role> <identity>"
+	TokenFile string `yaml:"token_file"`
+	// every line is "<key id> <secret> <role>"
+	HMACKeyFile string `yaml:"hmac_key_file"`
+	// max difference in seconds between the signing time and now. default is 300.
+	HMACMaxSkew int `yaml:"hmac_max_skew"`
+	// role of the common name of a verified client certificate
+	ClientCertRoles map[string]string `yaml:"client_cert_roles"`
+	// role of the callers without credential. empty means rejecting them.
+	AnonymousRole string `yaml:"anonymous_role"`
+}
+
+// SectionAuth is the credentials of redis or sentinel nodes. The password is
+// taken from Password, PasswordFile or the environment variable PasswordEnv in turn.
+// Username is used as the ACL user of redis 6.0+.
+type SectionAuth struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	PasswordEnv  string `yaml:"password_env"`
+}
+
+// SectionTLS is the TLS config to connect to redis or sentinel nodes.
+type SectionTLS struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// SectionAddrRule translates the ip in CIDR into To. To is an ip, or a CIDR of
+// the same prefix length into which the host part of the ip is kept.
+type SectionAddrRule struct {
+	CIDR string `yaml:"cidr"`
+	To   string `yaml:"to"`
+}
+
+// SectionZoneRule maps the ip in CIDR to Zone.
+type SectionZoneRule struct {
+	CIDR string `yaml:"cidr"`
+	Zone string `yaml:"zone"`
 }
 
 // SectionRedis is sub section of config.
@@ -37,9 +121,139 @@ type SectionRedis struct {
 	MetaHashtable    string   `yaml:"meta_hashtable"`
 	MetaVersion      string   `yaml:"meta_version"`
 	MetaInstNameList string   `yaml:"meta_instance_name_list"`
+	// slaves whose replication offset falls behind its master's more than SlaveMaxLag bytes
+	// are excluded from meta. 0 means no limit.
+	SlaveMaxLag int64 `yaml:"slave_max_lag"`
+	// local file that caches the last stored meta. It is served when the sentinels
+	// or the meta db are unreachable at startup. Empty means no cache.
+	MetaCacheFile string `yaml:"meta_cache_file"`
+	// default auth of all sentinels, and auth of a sentinel("ip:port"). It is used by all
+	// connections to the sentinels: the queries, the watchers and the admin commands.
+	SentinelAuth  SectionAuth            `yaml:"sentinel_auth"`
+	SentinelAuths map[string]SectionAuth `yaml:"sentinel_auths"`
+	// default auth of all redis instances, and auth of an instance(instance name)
+	InstanceAuth  SectionAuth            `yaml:"instance_auth"`
+	InstanceAuths map[string]SectionAuth `yaml:"instance_auths"`
+	SentinelTLS   SectionTLS             `yaml:"sentinel_tls"`
+	InstanceTLS   SectionTLS             `yaml:"instance_tls"`
+	// sentinels on these hosts are ignored when discovering sentinels. default is 127.0.0.1.
+	DiscoverExcludeHosts []string `yaml:"discover_exclude_hosts"`
+	// the internal address("ip:port") of masters and slaves is translated into the address
+	// advertised to clients by AddrMap first and then the first matched rule of AddrRules.
+	AddrMap   map[string]string `yaml:"addr_map"`
+	AddrRules []SectionAddrRule `yaml:"addr_rules"`
+	// the zone of a master or slave is the one of the first rule matching its advertised
+	// ip, or the "zone" label of its instance if no rule matches.
+	ZoneRules []SectionZoneRule `yaml:"zone_rules"`
+	// namespaces besides "default". The meta of a namespace is stored in the
+	// hashtable "<meta_hashtable>:<namespace>" with its own version.
+	Namespaces []string `yaml:"namespaces"`
+	// independent sentinel pools besides the "default" one of this section. They are
+	// served under /clusters/{cluster}/.
+	Clusters []SectionCluster `yaml:"clusters"`
+}
+
+// SectionCluster is a sentinel pool with its own meta db. The meta keys not set
+// are the same as the ones of the redis section. Other items such as auth and
+// update_interval are shared with the redis section.
+type SectionCluster struct {
+	Name             string   `yaml:"name"`
+	Sentinels        []string `yaml:"sentinels"`
+	MetaDBName       string   `yaml:"meta_db_name"`
+	MetaHashtable    string   `yaml:"meta_hashtable"`
+	MetaVersion      string   `yaml:"meta_version"`
+	MetaInstNameList string   `yaml:"meta_instance_name_list"`
+	// empty means no cache
+	MetaCacheFile string `yaml:"meta_cache_file"`
+}
+
+// SectionFederation is sub section of config.
+type SectionFederation struct {
+	// peer metaservers whose meta is served read-only under /clusters/{peer}/
+	Peers []SectionPeer `yaml:"peers"`
+	// a peer is stale if nothing is received from it in StaleTimeout seconds
+	StaleTimeout int `yaml:"stale_timeout"`
+	// seconds to wait before resubscribing to a lost peer
+	RetryInterval int `yaml:"retry_interval"`
+}
+
+// SectionPeer is a peer metaserver. URL is its base url, such as "https://10.0.1.1:10080"
+// or "https://10.0.1.1:10080/clusters/dc3". The password of Auth is sent as the bearer token.
+type SectionPeer struct {
+	Name string      `yaml:"name"`
+	URL  string      `yaml:"url"`
+	Auth SectionAuth `yaml:"auth"`
+	TLS  SectionTLS  `yaml:"tls"`
+}
+
+// SectionWebhook is sub section of config.
+type SectionWebhook struct {
+	Endpoints []SectionWebhookEndpoint `yaml:"endpoints"`
+	// seconds to wait for a delivery
+	Timeout int `yaml:"timeout"`
+	// a failed delivery is retried at most MaxRetries times. The backoff starts from
+	// RetryBackoff seconds and doubles up to MaxRetryBackoff seconds.
+	MaxRetries      int `yaml:"max_retries"`
+	RetryBackoff    int `yaml:"retry_backoff"`
+	MaxRetryBackoff int `yaml:"max_retry_backoff"`
+	// events pending for an endpoint. The events beyond it are dead letters.
+	QueueSize int `yaml:"queue_size"`
+	// file to which the undelivered events are appended in JSON lines. Empty means dropping them.
+	DeadLetterFile string `yaml:"dead_letter_file"`
+}
+
+// SectionWebhookEndpoint is a url to which the events are posted. The password of Secret
+// is the HMAC key of the signature. Empty Events means all events.
+type SectionWebhookEndpoint struct {
+	Name   string      `yaml:"name"`
+	URL    string      `yaml:"url"`
+	Secret SectionAuth `yaml:"secret"`
+	Events []string    `yaml:"events"`
+	TLS    SectionTLS  `yaml:"tls"`
 }
 
-// LoadConfYaml provide load yml config.
+// withCluster returns @c whose sentinels, meta db and meta keys are replaced by @cluster
+func (c SectionRedis) withCluster(cluster SectionCluster) SectionRedis {
+	conf := c
+	conf.Clusters = nil
+	conf.Sentinels = cluster.Sentinels
+	conf.MetaDBName = cluster.MetaDBName
+	if cluster.MetaHashtable != "" {
+		conf.MetaHashtable = cluster.MetaHashtable
+	}
+	if cluster.MetaVersion != "" {
+		conf.MetaVersion = cluster.MetaVersion
+	}
+	if cluster.MetaInstNameList != "" {
+		conf.MetaInstNameList = cluster.MetaInstNameList
+	}
+	conf.MetaCacheFile = cluster.MetaCacheFile
+
+	return conf
+}
+
+// Cluster returns the config of cluster @name, which is DefaultCluster or one of
+// c.Clusters.
+func (c SectionRedis) Cluster(name string) SectionRedis {
+	for _, cluster := range c.Clusters {
+		if name != DefaultCluster && cluster.Name == name {
+			return c.withCluster(cluster)
+		}
+	}
+
+	return c
+}
+
+// ClusterNames returns DefaultCluster and the names of c.Clusters
+func (c SectionRedis) ClusterNames() []string {
+	names := []string{DefaultCluster}
+	for _, cluster := range c.Clusters {
+		names = append(names, cluster.Name)
+	}
+	return names
+}
+
+// LoadConfYaml provide load yml config. Unknown config items are treated as errors.
 func LoadConfYaml(confPath string) (ConfYaml, error) {
 	var config ConfYaml
 
@@ -48,10 +262,418 @@ func LoadConfYaml(confPath string) (ConfYaml, error) {
 		return config, err
 	}
 
-	err = yaml.Unmarshal(configFile, &config)
+	err = yaml.UnmarshalStrict(configFile, &config)
 	if err != nil {
 		return config, err
 	}
 
 	return config, nil
 }
+
+// ConfError contains all the illegal config items
+type ConfError []string
+
+func (e ConfError) Error() string {
+	return strings.Join(e, "; ")
+}
+
+func checkAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || 65535 < p {
+		return fmt.Errorf("illegal port %q", port)
+	}
+	if host != "" && net.ParseIP(host) == nil {
+		return fmt.Errorf("illegal ip %q", host)
+	}
+
+	return nil
+}
+
+// DeprecatedItems returns the deprecated config items that are set. They are accepted
+// for the old config files, and ignored.
+func (c *ConfYaml) DeprecatedItems() []string {
+	var items []string
+	if c.Core.LogSize != 0 {
+		items = append(items, "core.log_size")
+	}
+
+	return items
+}
+
+// Validate checks every config item and returns all the illegal ones in a ConfError.
+func (c *ConfYaml) Validate() error {
+	var errs ConfError
+
+	add := func(field string, format string, args ...interface{}) {
+		errs = append(errs, field+": "+fmt.Sprintf(format, args...))
+	}
+
+	// core
+	if c.Core.BindAddr == "" {
+		add("core.bind_addr", "empty")
+	} else if err := checkAddr(c.Core.BindAddr); err != nil {
+		add("core.bind_addr", "%v", err)
+	}
+	if c.Core.FailFastTimeout < 0 {
+		add("core.fail_fast_timeout", "%d is negative", c.Core.FailFastTimeout)
+	}
+	if c.Core.PID.Enabled && c.Core.PID.Path == "" {
+		add("core.pid.path", "empty while core.pid.enabled is true")
+	}
+	if c.Core.DebugBindAddr != "" {
+		if err := checkAddr(c.Core.DebugBindAddr); err != nil {
+			add("core.debug_bind_addr", "%v", err)
+		} else if c.Core.DebugBindAddr == c.Core.BindAddr {
+			add("core.debug_bind_addr", "same as core.bind_addr")
+		}
+	}
+	c.Core.APIAuth.validate("core.api_auth", add)
+	c.Core.TLS.validate("core.tls", add)
+
+	// redis
+	validateCluster("redis", c.Redis, add)
+	if c.Redis.UpdateInterval <= 0 {
+		add("redis.update_interval", "%d is not positive", c.Redis.UpdateInterval)
+	}
+	clusters := map[string]struct{}{DefaultCluster: {}}
+	cacheFiles := map[string]string{}
+	if c.Redis.MetaCacheFile != "" {
+		cacheFiles[c.Redis.MetaCacheFile] = DefaultCluster
+	}
+	for i, cluster := range c.Redis.Clusters {
+		field := fmt.Sprintf("redis.clusters[%d]", i)
+		if err := checkNamespaceName(cluster.Name); err != nil {
+			add(field+".name", "illegal cluster name %q", cluster.Name)
+		} else if _, ok := clusters[cluster.Name]; ok {
+			add(field+".name", "duplicate cluster %s", cluster.Name)
+		}
+		clusters[cluster.Name] = struct{}{}
+		if other, ok := cacheFiles[cluster.MetaCacheFile]; ok {
+			add(field+".meta_cache_file", "same as the one of cluster %s", other)
+		} else if cluster.MetaCacheFile != "" {
+			cacheFiles[cluster.MetaCacheFile] = cluster.Name
+		}
+		validateCluster(field, c.Redis.withCluster(cluster), add)
+	}
+	if c.Redis.SlaveMaxLag < 0 {
+		add("redis.slave_max_lag", "%d is negative", c.Redis.SlaveMaxLag)
+	}
+
+	c.Redis.SentinelAuth.validate("redis.sentinel_auth", add)
+	for addr, auth := range c.Redis.SentinelAuths {
+		if err := checkAddr(addr); err != nil {
+			add("redis.sentinel_auths", "illegal sentinel address %q: %v", addr, err)
+		}
+		auth.validate(fmt.Sprintf("redis.sentinel_auths[%s]", addr), add)
+	}
+	c.Redis.InstanceAuth.validate("redis.instance_auth", add)
+	for name, auth := range c.Redis.InstanceAuths {
+		auth.validate(fmt.Sprintf("redis.instance_auths[%s]", name), add)
+	}
+	for i, host := range c.Redis.DiscoverExcludeHosts {
+		if net.ParseIP(host) == nil {
+			add(fmt.Sprintf("redis.discover_exclude_hosts[%d]", i), "illegal ip %q", host)
+		}
+	}
+	if _, err := NewAddrTranslator(c.Redis.AddrMap, c.Redis.AddrRules); err != nil {
+		add("redis.addr_map/addr_rules", "%v", err)
+	}
+	if _, err := NewZoneResolver(c.Redis.ZoneRules); err != nil {
+		add("redis.zone_rules", "%v", err)
+	}
+	c.Redis.SentinelTLS.validate("redis.sentinel_tls", add)
+	c.Redis.InstanceTLS.validate("redis.instance_tls", add)
+	namespaces := make(map[string]struct{}, len(c.Redis.Namespaces))
+	for i, ns := range c.Redis.Namespaces {
+		field := fmt.Sprintf("redis.namespaces[%d]", i)
+		if err := checkNamespaceName(ns); err != nil {
+			add(field, "%v", err)
+		} else if _, ok := namespaces[ns]; ok || ns == DefaultNamespace {
+			add(field, "duplicate namespace %s", ns)
+		}
+		namespaces[ns] = struct{}{}
+	}
+
+	// federation
+	c.Federation.validate("federation", clusters, add)
+
+	// webhook
+	c.Webhook.validate("webhook", add)
+
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		return errs
+	}
+
+	return nil
+}
+
+// validateCluster checks the sentinels, the meta db and the meta keys of a cluster
+func validateCluster(field string, c SectionRedis, add func(field string, format string, args ...interface{})) {
+	if len(c.Sentinels) == 0 {
+		add(field+".sentinels", "empty")
+	}
+	sentinels := make(map[string]struct{}, len(c.Sentinels))
+	for i, sentinel := range c.Sentinels {
+		field := fmt.Sprintf("%s.sentinels[%d]", field, i)
+		if err := checkAddr(sentinel); err != nil {
+			add(field, "%v", err)
+		} else if _, ok := sentinels[sentinel]; ok {
+			add(field, "duplicate sentinel %s", sentinel)
+		} else if strings.HasPrefix(sentinel, ":") {
+			add(field, "ip of %s is empty", sentinel)
+		}
+		sentinels[sentinel] = struct{}{}
+	}
+	if c.MetaDBName == "" {
+		add(field+".meta_db_name", "empty")
+	}
+	keys := make(map[string]string, 3)
+	for _, item := range []struct{ field, key string }{
+		{field + ".meta_hashtable", c.MetaHashtable},
+		{field + ".meta_version", c.MetaVersion},
+		{field + ".meta_instance_name_list", c.MetaInstNameList},
+	} {
+		field, key := item.field, item.key
+		if key == "" {
+			add(field, "empty")
+		} else if other, ok := keys[key]; ok {
+			add(field, "same as %s", other)
+		}
+		keys[key] = field
+	}
+}
+
+// validate checks the peers, whose names should differ from the local clusters in @clusters
+func (f *SectionFederation) validate(field string, clusters map[string]struct{}, add func(field string, format string, args ...interface{})) {
+	if len(f.Peers) == 0 {
+		return
+	}
+	if f.StaleTimeout <= int(StreamHeartbeatInterval/1e9) {
+		add(field+".stale_timeout", "%d is not greater than the heartbeat interval %ds",
+			f.StaleTimeout, int(StreamHeartbeatInterval/1e9))
+	}
+	if f.RetryInterval <= 0 {
+		add(field+".retry_interval", "%d is not positive", f.RetryInterval)
+	}
+	peers := make(map[string]struct{}, len(f.Peers))
+	for i, peer := range f.Peers {
+		field := fmt.Sprintf("%s.peers[%d]", field, i)
+		if err := checkNamespaceName(peer.Name); err != nil {
+			add(field+".name", "illegal peer name %q", peer.Name)
+		} else if _, ok := clusters[peer.Name]; ok {
+			add(field+".name", "same as local cluster %s", peer.Name)
+		} else if _, ok := peers[peer.Name]; ok {
+			add(field+".name", "duplicate peer %s", peer.Name)
+		}
+		peers[peer.Name] = struct{}{}
+		if u, err := url.Parse(peer.URL); err != nil {
+			add(field+".url", "%v", err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(field+".url", "illegal url %q", peer.URL)
+		}
+		peer.Auth.validate(field+".auth", add)
+		peer.TLS.validate(field+".tls", add)
+	}
+}
+
+func (h *SectionWebhook) validate(field string, add func(field string, format string, args ...interface{})) {
+	if len(h.Endpoints) == 0 {
+		return
+	}
+	for name, value := range map[string]int{
+		"timeout":           h.Timeout,
+		"retry_backoff":     h.RetryBackoff,
+		"max_retry_backoff": h.MaxRetryBackoff,
+		"queue_size":        h.QueueSize,
+	} {
+		if value <= 0 {
+			add(field+"."+name, "%d is not positive", value)
+		}
+	}
+	if h.MaxRetries < 0 {
+		add(field+".max_retries", "%d is negative", h.MaxRetries)
+	}
+	if h.MaxRetryBackoff < h.RetryBackoff {
+		add(field+".max_retry_backoff", "%d is less than retry_backoff %d", h.MaxRetryBackoff, h.RetryBackoff)
+	}
+	if h.DeadLetterFile != "" {
+		if _, err := os.Stat(filepath.Dir(h.DeadLetterFile)); err != nil {
+			add(field+".dead_letter_file", "%v", err)
+		}
+	}
+
+	endpoints := make(map[string]struct{}, len(h.Endpoints))
+	for i, endpoint := range h.Endpoints {
+		field := fmt.Sprintf("%s.endpoints[%d]", field, i)
+		if endpoint.Name == "" {
+			add(field+".name", "empty")
+		} else if _, ok := endpoints[endpoint.Name]; ok {
+			add(field+".name", "duplicate endpoint %s", endpoint.Name)
+		}
+		endpoints[endpoint.Name] = struct{}{}
+		if u, err := url.Parse(endpoint.URL); err != nil {
+			add(field+".url", "%v", err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(field+".url", "illegal url %q", endpoint.URL)
+		}
+		for j, event := range endpoint.Events {
+			if _, ok := webhookEvents[event]; !ok {
+				add(fmt.Sprintf("%s.events[%d]", field, j), "unknown event %q", event)
+			}
+		}
+		endpoint.Secret.validate(field+".secret", add)
+		endpoint.TLS.validate(field+".tls", add)
+	}
+}
+
+func (a *SectionAuth) validate(field string, add func(field string, format string, args ...interface{})) {
+	var sources int
+	for _, source := range []string{a.Password, a.PasswordFile, a.PasswordEnv} {
+		if source != "" {
+			sources++
+		}
+	}
+	if 1 < sources {
+		add(field, "only one of password, password_file and password_env can be set")
+	}
+	if a.Username != "" && sources == 0 {
+		add(field, "password of user %s is empty", a.Username)
+	}
+	if a.PasswordFile != "" {
+		if _, err := os.Stat(a.PasswordFile); err != nil {
+			add(field+".password_file", "%v", err)
+		}
+	}
+}
+
+func (t *SectionTLS) validate(field string, add func(field string, format string, args ...interface{})) {
+	if !t.Enabled {
+		return
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		add(field, "cert_file and key_file should be set together")
+	}
+	for name, file := range map[string]string{"ca_file": t.CAFile, "cert_file": t.CertFile, "key_file": t.KeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			add(field+"."+name, "%v", err)
+		}
+	}
+}
+
+func (a *SectionAPIAuth) validate(field string, add func(field string, format string, args ...interface{})) {
+	if !a.Enabled {
+		return
+	}
+	if a.HMACMaxSkew < 0 {
+		add(field+".hmac_max_skew", "%d is negative", a.HMACMaxSkew)
+	}
+	if a.AnonymousRole != "" {
+		if _, err := ParseRole(a.AnonymousRole); err != nil {
+			add(field+".anonymous_role", "%v", err)
+		}
+	}
+	for cn, role := range a.ClientCertRoles {
+		if _, err := ParseRole(role); err != nil {
+			add(fmt.Sprintf("%s.client_cert_roles[%s]", field, cn), "%v", err)
+		}
+	}
+	if a.TokenFile == "" && a.HMACKeyFile == "" && len(a.ClientCertRoles) == 0 && a.AnonymousRole == "" {
+		add(field, "no credential is configured")
+	}
+	if _, err := NewAPIAuthenticator(*a); err != nil {
+		add(field, "%v", err)
+	}
+}
+
+func (t *SectionServerTLS) validate(field string, add func(field string, format string, args ...interface{})) {
+	if !t.Enabled {
+		if t.AdminBindAddr != "" {
+			add(field+".admin_bind_addr", "needs core.tls.enabled")
+		}
+		return
+	}
+	if t.CertFile == "" || t.KeyFile == "" {
+		add(field, "cert_file or key_file is empty")
+	}
+	for name, file := range map[string]string{"cert_file": t.CertFile, "key_file": t.KeyFile, "client_ca_file": t.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			add(field+"."+name, "%v", err)
+		}
+	}
+	if _, ok := clientAuthTypes[t.ClientAuth]; !ok {
+		add(field+".client_auth", "illegal client auth type %q", t.ClientAuth)
+	} else if t.ClientAuth != "" && t.ClientAuth != "none" && t.ClientCAFile == "" {
+		add(field+".client_ca_file", "empty while client_auth is %s", t.ClientAuth)
+	}
+	if t.AdminBindAddr != "" {
+		if t.ClientCAFile == "" {
+			add(field+".client_ca_file", "empty while admin_bind_addr is set")
+		}
+		if err := checkAddr(t.AdminBindAddr); err != nil {
+			add(field+".admin_bind_addr", "%v", err)
+		}
+	}
+	if _, ok := tlsVersions[t.MinVersion]; !ok {
+		add(field+".min_version", "illegal tls version %q", t.MinVersion)
+	}
+}
+
+// GetPassword returns the password. The password file is reread every time
+// so that a rotated password takes effect without reload.
+func (a *SectionAuth) GetPassword() (string, error) {
+	switch {
+	case a.Password != "":
+		return a.Password, nil
+	case a.PasswordFile != "":
+		password, err := ioutil.ReadFile(a.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(password)), nil
+	case a.PasswordEnv != "":
+		return os.Getenv(a.PasswordEnv), nil
+	}
+
+	return "", nil
+}
+
+// GetTLSConfig builds the tls.Config. It returns nil if TLS is disabled.
+func (t *SectionTLS) GetTLSConfig() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		ca, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate in ca file %s", t.CAFile)
+		}
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testConfYaml = `
+core:
+  mode: "dev"
+  bind_addr: :10080
+  fail_fast_timeout: 3
+  pid:
+    enabled: false
+    path: "exocet-metaserver.pid"
+    override: true
+
+redis:
+  sentinels:
+    - 192.168.11.100:26380
+    - 192.168.11.100:26381
+  meta_db_name: meta
+  update_interval: 90
+  meta_hashtable: meta_hashtable
+  meta_version: version
+  meta_instance_name_list: instance_name_list
+`
+
+func writeTestConf(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "metaserver")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() = error:%#v", err)
+	}
+	file := filepath.Join(dir, "config.yml")
+	if err = ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile() = error:%#v", err)
+	}
+
+	return file
+}
+
+func Test_loadConf(t *testing.T) {
+	file := writeTestConf(t, testConfYaml)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	conf, err := loadConf(file)
+	if err != nil {
+		t.Fatalf("loadConf() = error:%#v", err)
+	}
+	if len(conf.Redis.Sentinels) != 2 || conf.Redis.UpdateInterval != 90 {
+		t.Fatalf("conf:%+v", conf)
+	}
+}
+
+func Test_loadConfDeprecatedKey(t *testing.T) {
+	file := writeTestConf(t, strings.Replace(testConfYaml, "  fail_fast_timeout: 3", "  fail_fast_timeout: 3\n  log_size: 4096", 1))
+	defer os.RemoveAll(filepath.Dir(file))
+
+	conf, err := loadConf(file)
+	if err != nil {
+		t.Fatalf("loadConf() of deprecated log_size = error:%#v", err)
+	}
+	if items := conf.DeprecatedItems(); len(items) != 1 || items[0] != "core.log_size" {
+		t.Errorf("DeprecatedItems() = %v, want [core.log_size]", items)
+	}
+}
+
+func Test_loadConfUnknownKey(t *testing.T) {
+	file := writeTestConf(t, strings.Replace(testConfYaml, "  meta_db_name:", "  meta_db: meta\n  meta_db_name:", 1))
+	defer os.RemoveAll(filepath.Dir(file))
+
+	if _, err := loadConf(file); err == nil || !strings.Contains(err.Error(), "meta_db") {
+		t.Fatalf("loadConf() = error:%v, want unknown key error", err)
+	}
+}
+
+func TestConfYaml_Validate(t *testing.T) {
+	file := writeTestConf(t, testConfYaml)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	conf, err := LoadConfYaml(file)
+	if err != nil {
+		t.Fatalf("LoadConfYaml() = error:%#v", err)
+	}
+	conf.Redis.Sentinels = append(conf.Redis.Sentinels, "192.168.11.100:263800", "192.168.11.100:26380")
+	conf.Redis.UpdateInterval = 0
+	conf.Redis.MetaVersion = conf.Redis.MetaInstNameList
+	conf.Redis.Clusters = []SectionCluster{{Name: DefaultCluster, MetaDBName: "meta"}}
+	conf.Federation = SectionFederation{
+		StaleTimeout:  5,
+		RetryInterval: 5,
+		Peers:         []SectionPeer{{Name: DefaultCluster, URL: "ftp://192.168.13.100"}},
+	}
+	conf.Webhook = SectionWebhook{
+		Timeout:         5,
+		RetryBackoff:    10,
+		MaxRetryBackoff: 5,
+		QueueSize:       16,
+		Endpoints:       []SectionWebhookEndpoint{{Name: "ops", URL: "http://192.168.13.200/hook", Events: []string{"odown"}}},
+	}
+
+	err = conf.Validate()
+	confErr, ok := err.(ConfError)
+	if !ok {
+		t.Fatalf("Validate() = error:%#v, want ConfError", err)
+	}
+	for _, field := range []string{
+		"federation.peers[0].name",
+		"federation.peers[0].url",
+		"federation.stale_timeout",
+		"redis.clusters[0].name",
+		"redis.clusters[0].sentinels",
+		"redis.meta_instance_name_list",
+		"redis.sentinels[2]",
+		"redis.sentinels[3]",
+		"redis.update_interval",
+		"webhook.endpoints[0].events[0]",
+		"webhook.max_retry_backoff",
+	} {
+		found := false
+		for _, item := range confErr {
+			if strings.HasPrefix(item, field+":") {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("can not find %s in %s", field, confErr)
+		}
+	}
+}
+
+func Test_testConf(t *testing.T) {
+	metaDB := newRedisStandIn(t, "", map[string]interface{}{"role": []interface{}{"master", 0, []interface{}{}}})
+	defer metaDB.Close()
+	metaAddr := standInIPAddr(metaDB)
+	sentinel := newRedisStandIn(t, "", map[string]interface{}{
+		"sentinel get-master-addr-by-name": []string{metaAddr.IP, strconv.Itoa(int(metaAddr.Port))},
+	})
+	defer sentinel.Close()
+	oldConf := *getConf()
+	defer runningConf.set(oldConf)
+
+	base := strings.Replace(testConfYaml, "    - 192.168.11.100:26380\n    - 192.168.11.100:26381\n",
+		"    - "+sentinel.Addr()+"\n", 1)
+	cluster := "  clusters:\n    - name: dc2\n      sentinels:\n        - %s\n      meta_db_name: meta\n"
+	cases := []struct {
+		name string
+		yaml string
+		code int
+	}{
+		{"default cluster", base, 0},
+		{"all clusters", base + fmt.Sprintf(cluster, sentinel.Addr()), 0},
+		// the sentinel of dc2 is unreachable
+		{"unreachable cluster", base + fmt.Sprintf(cluster, "127.0.0.1:1"), 1},
+	}
+	for _, c := range cases {
+		file := writeTestConf(t, c.yaml)
+		if code := testConf(file); code != c.code {
+			t.Errorf("%s: testConf() = %d, want %d", c.name, code, c.code)
+		}
+		os.RemoveAll(filepath.Dir(file))
+	}
+}
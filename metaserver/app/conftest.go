@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+import (
+	"github.com/AlexStocks/goext/math/rand"
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+func printTestResult(err error, format string, args ...interface{}) {
+	item := fmt.Sprintf(format, args...)
+	if err != nil {
+		fmt.Printf("[FAIL] %s: %v\n", item, err)
+		return
+	}
+	fmt.Printf("[ OK ] %s\n", item)
+}
+
+// getMetaDBAddr asks @sentinel for the master address of the meta db
+func getMetaDBAddr(sentinel string, metaDBName string) (string, error) {
+	conn, err := dialSentinel(sentinel)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err = conn.Do("ping"); err != nil {
+		return "", errors.Wrapf(err, "ping")
+	}
+	addr, err := redis.Strings(conn.Do("sentinel", "get-master-addr-by-name", metaDBName))
+	if err == redis.ErrNil {
+		return "", fmt.Errorf("meta db %s is not monitored", metaDBName)
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "sentinel get-master-addr-by-name %s", metaDBName)
+	}
+	if len(addr) != 2 {
+		return "", fmt.Errorf("illegal master address %q of %s", addr, metaDBName)
+	}
+
+	return net.JoinHostPort(addr[0], addr[1]), nil
+}
+
+// checkMetaDBWritable writes a temporary key into the meta db @addr
+func checkMetaDBWritable(metaDBName string, addr string, keyPrefix string) error {
+	conn, err := getMasterConn(metaDBName, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	key := keyPrefix + "-test-" + gxrand.RandString(8)
+	if _, err = conn.Do("set", key, os.Getpid(), "EX", 60); err != nil {
+		return errors.Wrapf(err, "set(%s)", key)
+	}
+	if _, err = conn.Do("del", key); err != nil {
+		return errors.Wrapf(err, "del(%s)", key)
+	}
+
+	return nil
+}
+
+// testCluster checks the sentinels of cluster @name and its meta db. It prints a
+// report and returns false if any check fails.
+func testCluster(name string, conf SectionRedis) bool {
+	var (
+		failed   bool
+		metaAddr string
+	)
+	for _, sentinel := range conf.Sentinels {
+		addr, err := getMetaDBAddr(sentinel, conf.MetaDBName)
+		printTestResult(err, "cluster %s: sentinel %s monitors meta db %s(%s)", name, sentinel, conf.MetaDBName, addr)
+		if err != nil {
+			failed = true
+			continue
+		}
+		if metaAddr != "" && metaAddr != addr {
+			failed = true
+			printTestResult(fmt.Errorf("sentinels disagree: %s != %s", metaAddr, addr), "cluster %s: meta db master", name)
+			continue
+		}
+		metaAddr = addr
+	}
+
+	if metaAddr != "" {
+		err := checkMetaDBWritable(conf.MetaDBName, metaAddr, conf.MetaHashtable)
+		printTestResult(err, "cluster %s: meta db %s is writable", name, metaAddr)
+		failed = failed || err != nil
+	}
+
+	return !failed && metaAddr != ""
+}
+
+// testConf validates the configure file @file and checks the sentinels and the
+// meta db of every cluster that it refers to. It prints a report and returns the
+// exit code.
+func testConf(file string) int {
+	fmt.Printf("testing configure file %s\n", file)
+
+	conf, err := loadConf(file)
+	printTestResult(err, "load config")
+	if err != nil {
+		if confErr, ok := err.(ConfError); ok {
+			for _, item := range confErr {
+				fmt.Printf("       %s\n", item)
+			}
+		}
+		return 1
+	}
+	for _, item := range conf.DeprecatedItems() {
+		fmt.Printf("[WARN] config item %s is deprecated and ignored\n", item)
+	}
+	// the dial functions take auth and tls config from the running config
+	runningConf.set(conf)
+
+	failed := false
+	for _, name := range conf.Redis.ClusterNames() {
+		if !testCluster(name, conf.Redis.Cluster(name)) {
+			failed = true
+		}
+	}
+
+	if failed {
+		fmt.Printf("configure file %s test failed\n", file)
+		return 1
+	}
+	fmt.Printf("configure file %s test is successful\n", file)
+
+	return 0
+}
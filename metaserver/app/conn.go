@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	RedisConnTimeout = 3e9 // 3s
+)
+
+// sentinelAuth returns the auth of sentinel @addr in @c
+func (c *SectionRedis) sentinelAuth(addr string) SectionAuth {
+	if auth, ok := c.SentinelAuths[addr]; ok {
+		return auth
+	}
+
+	return c.SentinelAuth
+}
+
+// instanceAuth returns the auth of the nodes of redis instance @name in @c
+func (c *SectionRedis) instanceAuth(name string) SectionAuth {
+	if auth, ok := c.InstanceAuths[name]; ok {
+		return auth
+	}
+
+	return c.InstanceAuth
+}
+
+// getSentinelAuth returns the auth of sentinel @addr
+func getSentinelAuth(addr string) SectionAuth {
+	return getConf().Redis.sentinelAuth(addr)
+}
+
+// getInstanceAuth returns the auth of the nodes of redis instance @name
+func getInstanceAuth(name string) SectionAuth {
+	return getConf().Redis.instanceAuth(name)
+}
+
+// setInstanceAuth sets the auth of the nodes of redis instance @name in the running config
+func setInstanceAuth(name string, auth SectionAuth) {
+	runningConf.update(func(conf *ConfYaml) {
+		auths := make(map[string]SectionAuth, len(conf.Redis.InstanceAuths)+1)
+		for k, v := range conf.Redis.InstanceAuths {
+			auths[k] = v
+		}
+		auths[name] = auth
+		conf.Redis.InstanceAuths = auths
+	})
+}
+
+// dialRedis connects to the redis(or sentinel) node @addr directly, and authenticates
+// itself with @auth. @readTimeout 0 means no read timeout.
+func dialRedis(addr string, auth SectionAuth, tlsConf SectionTLS, readTimeout time.Duration) (redis.Conn, error) {
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(time.Duration(RedisConnTimeout)),
+		redis.DialWriteTimeout(time.Duration(RedisConnTimeout)),
+	}
+	if readTimeout != 0 {
+		options = append(options, redis.DialReadTimeout(readTimeout))
+	}
+	tlsConfig, err := tlsConf.GetTLSConfig()
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetTLSConfig(%#v)", tlsConf)
+	}
+	if tlsConfig != nil {
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(tlsConfig))
+	}
+
+	conn, err := redis.Dial("tcp", addr, options...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "redis.Dial(%s)", addr)
+	}
+
+	password, err := auth.GetPassword()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "GetPassword()")
+	}
+	if password != "" {
+		if auth.Username != "" {
+			_, err = conn.Do("auth", auth.Username, password)
+		} else {
+			_, err = conn.Do("auth", password)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, errors.Wrapf(err, "auth(%s)", addr)
+		}
+	}
+
+	return conn, nil
+}
+
+// dialSentinel connects to sentinel @addr. The commands on the connection are measured.
+func dialSentinel(addr string) (redis.Conn, error) {
+	start := time.Now()
+	conf := &getConf().Redis
+	conn, err := dialRedis(addr, conf.sentinelAuth(addr), conf.SentinelTLS, time.Duration(RedisConnTimeout))
+	observeSentinelCall("dial", start, &err)
+	if err != nil {
+		return nil, err
+	}
+
+	return measuredConn{Conn: conn}, nil
+}
+
+// dialInstance connects to node @addr of redis instance @name
+func dialInstance(name string, addr string) (redis.Conn, error) {
+	conf := &getConf().Redis
+	return dialRedis(addr, conf.instanceAuth(name), conf.InstanceTLS, time.Duration(RedisConnTimeout))
+}
+
+func getConnRole(conn redis.Conn) (string, error) {
+	values, err := redis.Values(conn.Do("role"))
+	if err != nil {
+		return "", errors.Wrapf(err, "role")
+	}
+	if len(values) == 0 {
+		return "", fmt.Errorf("illegal role reply")
+	}
+
+	return redis.String(values[0], nil)
+}
+
+// getRole returns the role("master", "slave" or "sentinel") of node @addr of redis instance @name
+func getRole(name string, addr string) (string, error) {
+	conn, err := dialInstance(name, addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	return getConnRole(conn)
+}
+
+// getMasterConn connects to the master @addr of redis instance @name,
+// and makes sure that it is still a master.
+func getMasterConn(name string, addr string) (redis.Conn, error) {
+	conn, err := dialInstance(name, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := getConnRole(conn)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "getConnRole(%s)", addr)
+	}
+	if role != "master" {
+		conn.Close()
+		return nil, fmt.Errorf("role of %s is %s, not master", addr, role)
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+import (
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	pprofPath      = "/debug/pprof/"
+	RedactedSecret = "******"
+)
+
+// dumpStackHandler dump the stack of goroutines into response
+func dumpStackHandler(w http.ResponseWriter, r *http.Request) {
+	buf := make([]byte, 1<<16)
+	runtime.Stack(buf, true)
+	fmt.Fprintf(w, "%s", buf)
+}
+
+func redactAuth(auth SectionAuth) SectionAuth {
+	if auth.Password != "" {
+		auth.Password = RedactedSecret
+	}
+	return auth
+}
+
+// redactedConf returns a copy of @conf whose passwords are replaced with RedactedSecret
+func redactedConf(conf ConfYaml) ConfYaml {
+	conf.Redis.SentinelAuth = redactAuth(conf.Redis.SentinelAuth)
+	conf.Redis.InstanceAuth = redactAuth(conf.Redis.InstanceAuth)
+	sentinelAuths := make(map[string]SectionAuth, len(conf.Redis.SentinelAuths))
+	for addr, auth := range conf.Redis.SentinelAuths {
+		sentinelAuths[addr] = redactAuth(auth)
+	}
+	conf.Redis.SentinelAuths = sentinelAuths
+	instanceAuths := make(map[string]SectionAuth, len(conf.Redis.InstanceAuths))
+	for name, auth := range conf.Redis.InstanceAuths {
+		instanceAuths[name] = redactAuth(auth)
+	}
+	conf.Redis.InstanceAuths = instanceAuths
+	peers := conf.Federation.Peers
+	conf.Federation.Peers = nil
+	for _, peer := range peers {
+		peer.Auth = redactAuth(peer.Auth)
+		conf.Federation.Peers = append(conf.Federation.Peers, peer)
+	}
+	endpoints := conf.Webhook.Endpoints
+	conf.Webhook.Endpoints = nil
+	for _, endpoint := range endpoints {
+		endpoint.Secret = redactAuth(endpoint.Secret)
+		conf.Webhook.Endpoints = append(conf.Webhook.Endpoints, endpoint)
+	}
+
+	return conf
+}
+
+// dumpConfHandler dumps the running config in yaml without passwords
+func dumpConfHandler(w http.ResponseWriter, r *http.Request) {
+	conf, err := yaml.Marshal(redactedConf(*getConf()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+	w.Write(conf)
+}
+
+// newDebugMux returns the mux of debug routes
+func newDebugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stack", dumpStackHandler)
+	mux.HandleFunc(pprofPath, pprof.Index)
+	mux.HandleFunc(pprofPath+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(pprofPath+"profile", pprof.Profile)
+	mux.HandleFunc(pprofPath+"symbol", pprof.Symbol)
+	mux.HandleFunc(pprofPath+"trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/config", dumpConfHandler)
+
+	return mux
+}
+
+// startDebugHTTP starts the debug listener, which should be bound to localhost.
+func startDebugHTTP(addr string) {
+	Log.Info("start debug listener on %s", addr)
+	Log.Critical(http.ListenAndServe(addr, AuthMiddleware(LogMiddleware(newDebugMux()))))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+import (
+	"gopkg.in/yaml.v2"
+)
+
+func Test_redactedConf(t *testing.T) {
+	var conf ConfYaml
+	conf.Redis.SentinelAuth = SectionAuth{Password: "sentinel-secret"}
+	conf.Redis.InstanceAuths = map[string]SectionAuth{
+		"meta":   {Username: "metaserver", Password: "meta-secret"},
+		"cache1": {PasswordFile: "conf/cache1.pass"},
+	}
+	conf.Federation.Peers = []SectionPeer{{Name: "dc3", Auth: SectionAuth{Password: "peer-secret"}}}
+	conf.Webhook.Endpoints = []SectionWebhookEndpoint{{Name: "ops", Secret: SectionAuth{Password: "hook-secret"}}}
+
+	dump, err := yaml.Marshal(redactedConf(conf))
+	if err != nil {
+		t.Fatalf("yaml.Marshal() = error:%#v", err)
+	}
+	if strings.Contains(string(dump), "-secret") {
+		t.Errorf("password is not redacted:\n%s", dump)
+	}
+	// the config printed at startup and reload
+	if printed := fmt.Sprintf("%+v", redactedConf(conf)); strings.Contains(printed, "-secret") {
+		t.Errorf("password is not redacted:\n%s", printed)
+	}
+	if !strings.Contains(string(dump), "conf/cache1.pass") {
+		t.Errorf("password file should be kept:\n%s", dump)
+	}
+	if conf.Redis.InstanceAuths["meta"].Password != "meta-secret" {
+		t.Errorf("original config has been changed")
+	}
+}
+
+func Test_newAPIMux(t *testing.T) {
+	mux := newAPIMux()
+	for _, path := range []string{"/stack", "/debug/pprof/", "/debug/vars", "/debug/config"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("debug route %s is served by api mux, code:%d", path, w.Code)
+		}
+	}
+}
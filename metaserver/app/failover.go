@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	FailoverWaitTimeout    = 60e9  // 60s
+	MaxFailoverWaitTimeout = 600e9 // 600s
+)
+
+type (
+	// SwitchEvent is a +switch-master message and the meta version after it is applied
+	SwitchEvent struct {
+		Info    gxredis.MasterSwitchInfo
+		Version int32
+	}
+
+	// switchNotifier delivers switch events to the goroutines waiting for them
+	switchNotifier struct {
+		sync.Mutex
+		waiters map[string][]chan SwitchEvent
+	}
+
+	// FailoverResult is the result of a manual failover
+	FailoverResult struct {
+		Name      string `json:"name"`
+		OldMaster string `json:"old_master"`
+		NewMaster string `json:"new_master"`
+		Version   int32  `json:"version"` // meta version after the switch
+	}
+)
+
+func newSwitchNotifier() *switchNotifier {
+	return &switchNotifier{waiters: make(map[string][]chan SwitchEvent)}
+}
+
+// wait returns a channel that receives the next switch event of instance @name.
+// @cancel should be called if the caller stops waiting.
+func (n *switchNotifier) wait(name string) (<-chan SwitchEvent, func()) {
+	ch := make(chan SwitchEvent, 1)
+
+	n.Lock()
+	n.waiters[name] = append(n.waiters[name], ch)
+	n.Unlock()
+
+	cancel := func() {
+		n.Lock()
+		defer n.Unlock()
+		waiters := n.waiters[name]
+		for i := range waiters {
+			if waiters[i] == ch {
+				n.waiters[name] = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(n.waiters[name]) == 0 {
+			delete(n.waiters, name)
+		}
+	}
+
+	return ch, cancel
+}
+
+func (n *switchNotifier) notify(event SwitchEvent) {
+	n.Lock()
+	waiters := n.waiters[event.Info.Name]
+	delete(n.waiters, event.Info.Name)
+	n.Unlock()
+
+	for _, ch := range waiters {
+		ch <- event
+	}
+}
+
+// failover forces the sentinels to fail over instance @name, and waits for its
+// +switch-master message at most @timeout.
+func (w *SentinelWorker) failover(name string, timeout time.Duration) (FailoverResult, error) {
+	result := FailoverResult{Name: name}
+
+	if !w.Ready() {
+		return result, fmt.Errorf("switch watcher is not running in degraded mode")
+	}
+	if _, ok := w.getInstance(name); !ok {
+		return result, fmt.Errorf("instance %s not found", name)
+	}
+
+	// wait before failover, or else the switch may be missed
+	ch, cancel := w.switchNotifier.wait(name)
+	defer cancel()
+
+	sentinels := w.getSentinels()
+	if len(sentinels) == 0 {
+		return result, fmt.Errorf("sentinel list is empty")
+	}
+	var err error
+	for _, addr := range sentinels {
+		conn, dialErr := dialSentinel(addr)
+		if dialErr != nil {
+			err = dialErr
+			continue
+		}
+		_, err = conn.Do("sentinel", "failover", name)
+		conn.Close()
+		if err != nil {
+			// the sentinel refuses it, such as -NOGOODSLAVE or -INPROG
+			return result, errors.Wrapf(err, "sentinel %s: sentinel failover %s", addr, name)
+		}
+		Log.Info("sentinel %s starts to fail over instance %s", addr, name)
+		break
+	}
+	if err != nil {
+		return result, errors.Wrapf(err, "failed to connect to sentinels")
+	}
+
+	select {
+	case event := <-ch:
+		translator := w.getAddrTranslator()
+		result.OldMaster = translator.TranslateIPAddr(&event.Info.OldMaster).TcpAddr().String()
+		result.NewMaster = translator.TranslateIPAddr(&event.Info.NewMaster).TcpAddr().String()
+		result.Version = event.Version
+		return result, nil
+	case <-time.After(timeout):
+		return result, fmt.Errorf("no %s of %s in %s", SwitchMasterChannel, name, timeout)
+	}
+}
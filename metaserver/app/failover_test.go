@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+func Test_switchNotifier(t *testing.T) {
+	n := newSwitchNotifier()
+	ch1, cancel1 := n.wait("cache1")
+	defer cancel1()
+	ch2, cancel2 := n.wait("cache2")
+	cancel2()
+
+	n.notify(SwitchEvent{Info: gxredis.MasterSwitchInfo{Name: "cache2"}, Version: 3})
+	n.notify(SwitchEvent{Info: gxredis.MasterSwitchInfo{Name: "cache1"}, Version: 4})
+	select {
+	case event := <-ch1:
+		if event.Info.Name != "cache1" || event.Version != 4 {
+			t.Errorf("event:%+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no switch event of cache1")
+	}
+	select {
+	case event := <-ch2:
+		t.Errorf("canceled waiter got event:%+v", event)
+	default:
+	}
+	if len(n.waiters) != 0 {
+		t.Errorf("waiters:%v", n.waiters)
+	}
+}
+
+func TestSentinelWorker_failover(t *testing.T) {
+	sentinel := newRedisStandIn(t, "", map[string]interface{}{"sentinel slaves": []interface{}{}})
+	defer sentinel.Close()
+	defer setSwitchTestConf()()
+
+	sw := newSwitchTestWorker(sentinel)
+	type failoverReturn struct {
+		result FailoverResult
+		err    error
+	}
+	c := make(chan failoverReturn, 1)
+	go func() {
+		result, err := sw.failover("cache1", 5*time.Second)
+		c <- failoverReturn{result, err}
+	}()
+
+	// the switch message comes after the sentinel starts the failover
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(strings.Join(sentinel.Commands(), "\n"), "sentinel failover cache1") {
+		if deadline.Before(time.Now()) {
+			t.Fatalf("commands of sentinel = %q, want sentinel failover", sentinel.Commands())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	handleSwitchInTime(t, sw, gxredis.MasterSwitchInfo{
+		Name:      "cache1",
+		OldMaster: gxredis.IPAddr{IP: "192.168.11.100", Port: 4001},
+		NewMaster: gxredis.IPAddr{IP: "192.168.11.101", Port: 4001},
+	})
+
+	ret := <-c
+	if ret.err != nil || ret.result != (FailoverResult{
+		Name: "cache1", OldMaster: "192.168.11.100:4001", NewMaster: "192.168.11.101:4001", Version: 2,
+	}) {
+		t.Errorf("failover() = {%+v, error:%v}", ret.result, ret.err)
+	}
+
+	// no sentinel to ask
+	sw.sentinels = nil
+	start := time.Now()
+	if _, err := sw.failover("cache1", 5*time.Second); err == nil || time.Second < time.Since(start) {
+		t.Errorf("failover() without sentinels = error:%v in %s, want an error at once", err, time.Since(start))
+	}
+}
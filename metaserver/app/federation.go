@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StreamPollInterval       = 1e9  // 1s
+	StreamHeartbeatInterval  = 10e9 // 10s
+	MaxStreamEventSize       = 64 * 1024 * 1024
+	DefaultPeerStaleTimeout  = 30   // 30s
+	DefaultPeerRetryInterval = 5    // 5s
+	PeerResponseTimeout      = 10e9 // 10s
+
+	// MetaStaleHeader is "true" if the meta of a peer is stale
+	MetaStaleHeader = "X-Meta-Stale"
+	// MetaUpdatedHeader is the time when the meta of a peer was received
+	MetaUpdatedHeader = "X-Meta-Updated-At"
+
+	streamMetaEvent = "meta"
+)
+
+type (
+	// PeerStatus is the state of a peer metaserver
+	PeerStatus struct {
+		Name      string    `json:"name"`
+		URL       string    `json:"url"`
+		Connected bool      `json:"connected"`
+		Stale     bool      `json:"stale"`
+		Version   int32     `json:"version"`
+		UpdatedAt time.Time `json:"updated_at"` // when the last meta was received
+		SeenAt    time.Time `json:"seen_at"`    // when the last event or heartbeat was received
+		Error     string    `json:"error,omitempty"`
+	}
+
+	// PeerWatcher subscribes to the meta stream of a peer metaserver and keeps its
+	// last meta, which is still served as stale after the peer is lost.
+	PeerWatcher struct {
+		conf         SectionPeer
+		client       *http.Client
+		staleTimeout time.Duration
+		retry        time.Duration
+		sync.RWMutex
+		meta      MetaView
+		loaded    bool
+		connected bool
+		updatedAt time.Time
+		seenAt    time.Time
+		err       error
+		ctx       context.Context
+		cancel    context.CancelFunc
+		wg        sync.WaitGroup
+	}
+
+	// FederatedInstance is an instance of a local cluster or a peer
+	FederatedInstance struct {
+		MetaInstance
+		Cluster string `json:"Cluster"`
+		Stale   bool   `json:"Stale,omitempty"`
+	}
+
+	// FederatedView is the merged meta of the local clusters and the peers. An
+	// instance of a local cluster hides the peer ones of the same name.
+	FederatedView struct {
+		Versions  map[string]int32             `json:"Versions"`
+		Stale     []string                     `json:"Stale"`
+		Instances map[string]FederatedInstance `json:"Instances"`
+	}
+)
+
+var (
+	// watchers of all peers. It is not changed after startPeerWatchers.
+	peerWatchers map[string]*PeerWatcher
+)
+
+// NewPeerWatcher creates a watcher of @conf. It does not subscribe until started.
+func NewPeerWatcher(conf SectionPeer, staleTimeout time.Duration, retry time.Duration) (*PeerWatcher, error) {
+	tlsConfig, err := conf.TLS.GetTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PeerWatcher{
+		conf: conf,
+		// no client timeout, the stream never ends. A silent peer is detected by staleTimeout.
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			TLSClientConfig:       tlsConfig,
+			ResponseHeaderTimeout: time.Duration(PeerResponseTimeout),
+		}},
+		staleTimeout: staleTimeout,
+		retry:        retry,
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+
+	return p, nil
+}
+
+func (p *PeerWatcher) start() {
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Close stops the subscription. The last meta is kept.
+func (p *PeerWatcher) Close() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// run subscribes to the peer, and subscribes again every retry interval after it is lost
+func (p *PeerWatcher) run() {
+	defer p.wg.Done()
+
+	for {
+		err := p.subscribe()
+		if p.ctx.Err() != nil {
+			return
+		}
+		p.lost(err)
+		Log.Warn("lost peer %s(%s), error:%v, its last meta is served as stale", p.conf.Name, p.conf.URL, err)
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(p.retry):
+		}
+	}
+}
+
+// subscribe reads the meta stream of the peer until it fails or nothing is
+// received in staleTimeout.
+func (p *PeerWatcher) subscribe() error {
+	ctx, cancel := context.WithCancel(p.ctx)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", strings.TrimRight(p.conf.URL, "/")+APIV1Prefix+"/stream", nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	token, err := p.conf.Auth.GetPassword()
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", req.URL, rsp.Status)
+	}
+
+	Log.Info("subscribed to peer %s(%s)", p.conf.Name, p.conf.URL)
+	p.Lock()
+	p.connected, p.err = true, nil
+	p.Unlock()
+
+	watchdog := time.AfterFunc(p.staleTimeout, cancel)
+	defer watchdog.Stop()
+	err = p.readStream(rsp.Body, func() {
+		watchdog.Reset(p.staleTimeout)
+	})
+	if ctx.Err() != nil && p.ctx.Err() == nil {
+		return fmt.Errorf("nothing received in %s", p.staleTimeout)
+	}
+
+	return err
+}
+
+// readStream applies the "meta" events of the server-sent event stream @body. @alive
+// is called on every line, including the heartbeats.
+func (p *PeerWatcher) readStream(body io.Reader, alive func()) error {
+	var (
+		event string
+		data  []string
+	)
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), MaxStreamEventSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		alive()
+		p.Lock()
+		p.seenAt = time.Now()
+		p.Unlock()
+
+		switch {
+		case line == "":
+			if event == streamMetaEvent && len(data) != 0 {
+				if err := p.setMeta([]byte(strings.Join(data, "\n"))); err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// heartbeat
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return io.ErrUnexpectedEOF
+}
+
+func (p *PeerWatcher) setMeta(data []byte) error {
+	var view MetaView
+	if err := json.Unmarshal(data, &view); err != nil {
+		return fmt.Errorf("illegal meta event: %v", err)
+	}
+	if view.Instances == nil {
+		view.Instances = make(map[string]MetaInstance)
+	}
+
+	p.Lock()
+	p.meta, p.loaded, p.updatedAt = view, true, time.Now()
+	p.Unlock()
+
+	return nil
+}
+
+// lost marks the peer disconnected by @err
+func (p *PeerWatcher) lost(err error) {
+	p.Lock()
+	p.connected, p.err = false, err
+	p.Unlock()
+}
+
+// snapshot returns the last meta of the peer and its status at @now. The meta
+// should not be changed.
+func (p *PeerWatcher) snapshot(now time.Time) (MetaView, PeerStatus) {
+	p.RLock()
+	defer p.RUnlock()
+
+	status := PeerStatus{
+		Name:      p.conf.Name,
+		URL:       p.conf.URL,
+		Connected: p.connected,
+		Stale:     !p.loaded || !p.connected || p.staleTimeout < now.Sub(p.seenAt),
+		Version:   p.meta.Version,
+		UpdatedAt: p.updatedAt,
+		SeenAt:    p.seenAt,
+	}
+	if p.err != nil {
+		status.Error = p.err.Error()
+	}
+	view := p.meta
+	if view.Instances == nil {
+		view.Instances = make(map[string]MetaInstance)
+	}
+
+	return view, status
+}
+
+// startPeerWatchers subscribes to all the peers in the config
+func startPeerWatchers() {
+	var (
+		conf         = getConf().Federation
+		staleTimeout = time.Duration(conf.StaleTimeout) * time.Second
+		retry        = time.Duration(conf.RetryInterval) * time.Second
+		watchers     = make(map[string]*PeerWatcher, len(conf.Peers))
+	)
+
+	for _, peer := range conf.Peers {
+		p, err := NewPeerWatcher(peer, staleTimeout, retry)
+		if err != nil {
+			Log.Error("NewPeerWatcher(%s) = error:%#v", peer.Name, err)
+			continue
+		}
+		p.start()
+		watchers[peer.Name] = p
+	}
+	peerWatchers = watchers
+}
+
+func closePeerWatchers() {
+	for _, p := range peerWatchers {
+		p.Close()
+	}
+}
+
+// peerNames returns the names of the peers in order
+func peerNames() []string {
+	names := make([]string, 0, len(peerWatchers))
+	for name := range peerWatchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// v1StreamHandler serves GET /v1/stream[?selector=...&zone=...]. It pushes the meta as
+// the server-sent event "meta" on connecting and whenever the version changes, and a
+// heartbeat comment every StreamHeartbeatInterval.
+func v1StreamHandler(w http.ResponseWriter, r *http.Request) {
+	sw := workerOf(r)
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeResponse(w, EC_SYS_ERROR, "streaming is not supported")
+		return
+	}
+	query, err := parseMetaQuery(r)
+	if err != nil {
+		writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var (
+		sent      bool
+		version   int32
+		poll      = time.NewTicker(time.Duration(StreamPollInterval))
+		heartbeat = time.NewTicker(time.Duration(StreamHeartbeatInterval))
+	)
+	defer poll.Stop()
+	defer heartbeat.Stop()
+	for {
+		var view MetaView
+		sw.RLock()
+		changed := !sent || sw.meta.Version != version
+		if changed {
+			view = query.Apply(sw.metaView())
+		}
+		sw.RUnlock()
+		if changed {
+			data, err := json.Marshal(view)
+			if err != nil {
+				return
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", streamMetaEvent, data); err != nil {
+				return
+			}
+			flusher.Flush()
+			sent, version = true, view.Version
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-poll.C:
+		}
+	}
+}
+
+// servePeer serves the meta routes of peer @p read-only, and its status on "/". @path
+// is the route without the cluster prefix.
+func servePeer(w http.ResponseWriter, r *http.Request, p *PeerWatcher, path string) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeResponse(w, EC_ILLEGAL_HTTP_METHOD, "meta of peer "+p.conf.Name+" is read-only")
+		return
+	}
+	query, err := parseMetaQuery(r)
+	if err != nil {
+		writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+		return
+	}
+
+	view, status := p.snapshot(time.Now())
+	w.Header().Set(MetaStaleHeader, fmt.Sprint(status.Stale))
+	if !status.UpdatedAt.IsZero() {
+		w.Header().Set(MetaUpdatedHeader, status.UpdatedAt.UTC().Format(time.RFC3339))
+	}
+	switch {
+	case path == "/cluster/meta" || path == APIV1Prefix+"/instances":
+		writeJSON(w, http.StatusOK, query.Apply(view))
+	case strings.HasPrefix(path, APIV1Prefix+"/instances/"):
+		name := strings.TrimPrefix(path, APIV1Prefix+"/instances/")
+		inst, ok := view.Instances[name]
+		if !ok {
+			writeResponse(w, EC_NOT_FOUND, fmt.Sprintf("instance %s not found", name))
+			return
+		}
+		writeJSON(w, http.StatusOK, inst)
+	case path == "/":
+		writeJSON(w, http.StatusOK, status)
+	default:
+		writeResponse(w, EC_NOT_FOUND, path)
+	}
+}
+
+// federatedView merges the meta of the local clusters and the peers selected by @query.
+// The local instances come first, so a peer instance of the same name is hidden.
+func federatedView(query MetaQuery, now time.Time) FederatedView {
+	view := FederatedView{
+		Versions:  make(map[string]int32),
+		Stale:     []string{},
+		Instances: make(map[string]FederatedInstance),
+	}
+	add := func(cluster string, meta MetaView, stale bool) {
+		view.Versions[cluster] = meta.Version
+		if stale {
+			view.Stale = append(view.Stale, cluster)
+		}
+		for name, inst := range query.Apply(meta).Instances {
+			if _, ok := view.Instances[name]; !ok {
+				view.Instances[name] = FederatedInstance{MetaInstance: inst, Cluster: cluster, Stale: stale}
+			}
+		}
+	}
+
+	for _, cluster := range getConf().Redis.ClusterNames() {
+		sw, ok := clusterWorkers[cluster]
+		if !ok {
+			continue
+		}
+		sw.RLock()
+		meta := sw.metaView()
+		sw.RUnlock()
+		add(cluster, meta, false)
+	}
+	for _, name := range peerNames() {
+		meta, status := peerWatchers[name].snapshot(now)
+		add(name, meta, status.Stale)
+	}
+
+	return view
+}
+
+// v1FederationHandler serves GET /v1/federation[?selector=...&zone=...], the merged
+// meta of the local clusters and the peers.
+func v1FederationHandler(w http.ResponseWriter, r *http.Request) {
+	Log.Debug("get request from %#v", r.RemoteAddr)
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeResponse(w, EC_ILLEGAL_HTTP_METHOD, r.Method)
+		return
+	}
+	query, err := parseMetaQuery(r)
+	if err != nil {
+		writeResponse(w, EC_ILLEGAL_PARAM, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, federatedView(query, time.Now()))
+}
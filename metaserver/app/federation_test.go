@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+func newTestPeerWatcher(t *testing.T, name string) *PeerWatcher {
+	p, err := NewPeerWatcher(SectionPeer{Name: name, URL: "http://127.0.0.1:10080"}, 30*time.Second, time.Second)
+	if err != nil {
+		t.Fatalf("NewPeerWatcher() = error:%#v", err)
+	}
+	return p
+}
+
+func TestPeerWatcher_readStream(t *testing.T) {
+	p := newTestPeerWatcher(t, "dc2")
+	if _, status := p.snapshot(time.Now()); !status.Stale {
+		t.Errorf("status of a peer never connected = %+v", status)
+	}
+
+	stream := ": heartbeat\n\n" +
+		"event: meta\ndata: {\"Version\":3,\"Instances\":{\"cache1\":{\"Name\":\"cache1\",\"Labels\":{\"tier\":\"hot\"}}}}\n\n" +
+		"event: other\ndata: ignored\n\n"
+	var lines int
+	p.connected = true
+	err := p.readStream(strings.NewReader(stream), func() { lines++ })
+	if err == nil {
+		t.Errorf("readStream() should fail at the end of the stream")
+	}
+	if lines != 8 {
+		t.Errorf("alive() is called %d times", lines)
+	}
+
+	view, status := p.snapshot(time.Now())
+	if status.Stale || status.Version != 3 || status.UpdatedAt.IsZero() {
+		t.Errorf("snapshot() status = %+v", status)
+	}
+	if inst := view.Instances["cache1"]; inst.Instance == nil || inst.Name != "cache1" || inst.Labels["tier"] != "hot" {
+		t.Errorf("snapshot() view = %+v", view)
+	}
+	if _, status = p.snapshot(time.Now().Add(time.Minute)); !status.Stale {
+		t.Errorf("status without heartbeat = %+v", status)
+	}
+
+	p.lost(errors.New("connection reset"))
+	view, status = p.snapshot(time.Now())
+	if !status.Stale || status.Connected || status.Error != "connection reset" {
+		t.Errorf("status of a lost peer = %+v", status)
+	}
+	if _, ok := view.Instances["cache1"]; !ok || view.Version != 3 {
+		t.Errorf("meta of a lost peer = %+v", view)
+	}
+
+	if err = p.readStream(strings.NewReader("event: meta\ndata: {\n\n"), func() {}); err == nil {
+		t.Errorf("readStream() of illegal meta should fail")
+	}
+}
+
+func Test_v1StreamHandler(t *testing.T) {
+	sw := &SentinelWorker{
+		cluster: DefaultCluster,
+		meta: ClusterMeta{
+			Version:   5,
+			Instances: map[string]*gxredis.Instance{"cache1": {Name: "cache1"}},
+		},
+		attrs: map[string]InstanceAttrs{},
+	}
+	oldWorker := worker
+	worker = sw
+	defer func() {
+		worker = oldWorker
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(v1StreamHandler))
+	defer server.Close()
+
+	rsp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("http.Get() = error:%#v", err)
+	}
+	defer rsp.Body.Close()
+	if ct := rsp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %s", ct)
+	}
+
+	p := newTestPeerWatcher(t, "dc2")
+	go p.readStream(rsp.Body, func() {})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		view, status := p.snapshot(time.Now())
+		if status.Version == 5 {
+			if _, ok := view.Instances["cache1"]; !ok {
+				t.Errorf("streamed meta = %+v", view)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no meta is streamed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sw.Lock()
+	sw.meta.Version = 6
+	sw.meta.Instances["cache2"] = &gxredis.Instance{Name: "cache2"}
+	sw.Unlock()
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		view, status := p.snapshot(time.Now())
+		if status.Version == 6 {
+			if len(view.Instances) != 2 {
+				t.Errorf("streamed meta = %+v", view)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the new version is not streamed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_servePeer(t *testing.T) {
+	p := newTestPeerWatcher(t, "dc2")
+	p.connected = true
+	p.readStream(strings.NewReader("event: meta\ndata: {\"Version\":2,\"Instances\":{\"cache1\":{\"Name\":\"cache1\"}}}\n\n"), func() {})
+	oldWorkers, oldPeers := clusterWorkers, peerWatchers
+	clusterWorkers = map[string]*SentinelWorker{DefaultCluster: {cluster: DefaultCluster}}
+	peerWatchers = map[string]*PeerWatcher{"dc2": p}
+	defer func() {
+		clusterWorkers, peerWatchers = oldWorkers, oldPeers
+	}()
+
+	handler := clusterHandler(http.NewServeMux())
+	cases := []struct {
+		method string
+		path   string
+		status int
+		stale  string
+	}{
+		{"GET", "/clusters/dc2/v1/instances", http.StatusOK, "false"},
+		{"GET", "/clusters/dc2/cluster/meta", http.StatusOK, "false"},
+		{"GET", "/clusters/dc2/v1/instances/cache1", http.StatusOK, "false"},
+		{"GET", "/clusters/dc2/v1/instances/cache2", http.StatusNotFound, "false"},
+		{"GET", "/clusters/dc2/v1/batch", http.StatusNotFound, "false"},
+		{"POST", "/clusters/dc2/v1/instances", http.StatusMethodNotAllowed, ""},
+		{"GET", "/clusters/dc3/v1/instances", http.StatusNotFound, ""},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(c.method, c.path, nil))
+		if w.Code != c.status || w.Header().Get(MetaStaleHeader) != c.stale {
+			t.Errorf("%s %s = %d, stale:%q", c.method, c.path, w.Code, w.Header().Get(MetaStaleHeader))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/clusters/dc2/v1/instances", nil))
+	var view MetaView
+	if err := json.Unmarshal(w.Body.Bytes(), &view); err != nil || view.Version != 2 || len(view.Instances) != 1 {
+		t.Errorf("meta of peer = %s", w.Body.String())
+	}
+
+	p.lost(errors.New("EOF"))
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/clusters/dc2/v1/instances", nil))
+	if w.Code != http.StatusOK || w.Header().Get(MetaStaleHeader) != "true" {
+		t.Errorf("meta of lost peer = %d, stale:%q", w.Code, w.Header().Get(MetaStaleHeader))
+	}
+}
+
+func Test_federatedView(t *testing.T) {
+	local := &SentinelWorker{
+		cluster: DefaultCluster,
+		meta: ClusterMeta{
+			Version:   7,
+			Instances: map[string]*gxredis.Instance{"cache1": {Name: "cache1"}},
+		},
+		attrs: map[string]InstanceAttrs{},
+	}
+	p := newTestPeerWatcher(t, "dc2")
+	p.readStream(strings.NewReader("event: meta\ndata: {\"Version\":2,\"Instances\":{\"cache1\":{\"Name\":\"cache1\"},\"cache2\":{\"Name\":\"cache2\"}}}\n\n"), func() {})
+	oldWorkers, oldPeers := clusterWorkers, peerWatchers
+	clusterWorkers = map[string]*SentinelWorker{DefaultCluster: local}
+	peerWatchers = map[string]*PeerWatcher{"dc2": p}
+	defer func() {
+		clusterWorkers, peerWatchers = oldWorkers, oldPeers
+	}()
+
+	view := federatedView(MetaQuery{}, time.Now())
+	if view.Versions[DefaultCluster] != 7 || view.Versions["dc2"] != 2 {
+		t.Errorf("Versions = %v", view.Versions)
+	}
+	if len(view.Stale) != 1 || view.Stale[0] != "dc2" {
+		t.Errorf("Stale = %v", view.Stale)
+	}
+	if inst := view.Instances["cache1"]; inst.Cluster != DefaultCluster || inst.Stale {
+		t.Errorf("local instance cache1 = %+v", inst)
+	}
+	if inst := view.Instances["cache2"]; inst.Cluster != "dc2" || !inst.Stale {
+		t.Errorf("peer instance cache2 = %+v", inst)
+	}
+}
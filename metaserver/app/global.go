@@ -1,9 +1,5 @@
 package main
 
-import (
-	"github.com/AlexStocks/goext/log"
-)
-
 type (
 	empty interface{}
 )
@@ -14,10 +10,12 @@ var (
 	LocalHost string
 	// progress id
 	ProcessID string
-	// Conf is main config
-	Conf ConfYaml
-	// Log records server request log
-	Log gxlog.Logger
+	// configure file path
+	confFile    string
+	logConfFile string
+	// Log records server request log. It is set once by initLog, and reloadLog
+	// replaces the logger behind it.
+	Log *reloadableLogger
 	// worker
 	worker *SentinelWorker
 )
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// the update loop is wedged, or the meta is out of date, if it has not
+	// run, or succeeded, in MaxUpdateDelay update intervals
+	MaxUpdateDelay = 3
+)
+
+type (
+	// workerHealth is what the update loop and the meta store of a worker observed last
+	workerHealth struct {
+		loopTime   time.Time // start of the last iteration of the update loop
+		updateTime time.Time // last successful update of the meta from the sentinels
+		updateErr  error
+		storeErr   error     // result of the last store of the meta
+		storeTime  time.Time // time of storeErr
+		// results of the checks of the sentinels and the meta db by the update loop
+		deps     []HealthCheck
+		depsTime time.Time
+	}
+
+	// HealthCheck is the result of one check of /healthz or /readyz
+	HealthCheck struct {
+		Name    string `json:"name"`
+		OK      bool   `json:"ok"`
+		Message string `json:"message,omitempty"`
+	}
+
+	// HealthReport is the response of /healthz and /readyz. Code and Message are
+	// the same as the ones of Response for the old clients.
+	HealthReport struct {
+		Code    ErrorCode     `json:"Code,omitempty"`
+		Message string        `json:"Message,omitempty"`
+		Cluster string        `json:"cluster,omitempty"`
+		Checks  []HealthCheck `json:"checks"`
+	}
+)
+
+// maxUpdateDelay returns the delay after which the update loop is taken as wedged
+func maxUpdateDelay() time.Duration {
+	return time.Duration(MaxUpdateDelay*getConf().Redis.UpdateInterval) * time.Second
+}
+
+func (w *SentinelWorker) touchLoop() {
+	w.healthLock.Lock()
+	w.health.loopTime = time.Now()
+	w.healthLock.Unlock()
+}
+
+func (w *SentinelWorker) setUpdateResult(err error) {
+	w.healthLock.Lock()
+	defer w.healthLock.Unlock()
+
+	w.health.updateErr = err
+	if err == nil {
+		w.health.updateTime = time.Now()
+	}
+}
+
+func (w *SentinelWorker) setStoreResult(err error) {
+	w.healthLock.Lock()
+	w.health.storeErr = err
+	w.health.storeTime = time.Now()
+	w.healthLock.Unlock()
+}
+
+// clearStoreErr clears the store failure before @t, as the meta db accepted a write since then
+func (w *SentinelWorker) clearStoreErr(t time.Time) {
+	w.healthLock.Lock()
+	if w.health.storeTime.Before(t) {
+		w.health.storeErr = nil
+	}
+	w.healthLock.Unlock()
+}
+
+func (w *SentinelWorker) getHealth() workerHealth {
+	w.healthLock.Lock()
+	defer w.healthLock.Unlock()
+	return w.health
+}
+
+// checkLoop checks that the update loop of the worker is not wedged
+func (w *SentinelWorker) checkLoop(now time.Time) HealthCheck {
+	check := HealthCheck{Name: "update_loop:" + w.cluster, OK: true}
+	if delay := now.Sub(w.getHealth().loopTime); maxUpdateDelay() < delay {
+		check.OK = false
+		check.Message = fmt.Sprintf("update loop has not run for %s", delay.Truncate(time.Second))
+	}
+
+	return check
+}
+
+// checkMeta checks that the worker is not in degraded mode and has updated the meta recently
+func (w *SentinelWorker) checkMeta(now time.Time) HealthCheck {
+	check := HealthCheck{Name: "meta"}
+	health := w.getHealth()
+	w.RLock()
+	ready, version, instances := w.ready, w.meta.Version, len(w.meta.Instances)
+	w.RUnlock()
+
+	switch {
+	case !ready:
+		check.Message = "degraded mode, serving the meta cache"
+	case instances == 0:
+		check.Message = "meta is empty"
+	case health.updateTime.IsZero():
+		check.Message = fmt.Sprintf("meta has never been updated from the sentinels: %v", health.updateErr)
+	case maxUpdateDelay() < now.Sub(health.updateTime):
+		check.Message = fmt.Sprintf("meta was last updated %s ago: %v",
+			now.Sub(health.updateTime).Truncate(time.Second), health.updateErr)
+	default:
+		check.OK = true
+		check.Message = fmt.Sprintf("version %d, %d instances", version, instances)
+	}
+
+	return check
+}
+
+// checkSentinel checks that one of the sentinels answers PING
+func (w *SentinelWorker) checkSentinel() HealthCheck {
+	check := HealthCheck{Name: "sentinel"}
+	sentinels := w.getSentinels()
+	if len(sentinels) == 0 {
+		check.Message = "sentinel list is empty"
+		return check
+	}
+
+	for _, addr := range sentinels {
+		conn, err := dialSentinel(addr)
+		if err == nil {
+			_, err = conn.Do("ping")
+			conn.Close()
+		}
+		if err == nil {
+			check.OK = true
+			check.Message = addr
+			return check
+		}
+		check.Message = fmt.Sprintf("%s: %v", addr, err)
+	}
+
+	return check
+}
+
+// checkWatchers checks that both watchers subscribe to a sentinel
+func (w *SentinelWorker) checkWatchers() []HealthCheck {
+	switchWatcher, sdownWatcher := w.getWatchers()
+	checks := []HealthCheck{
+		{Name: "watcher:" + SwitchMasterChannel, OK: switchWatcher.Connected()},
+		{Name: "watcher:" + SdownChannel, OK: sdownWatcher.Connected()},
+	}
+	for i := range checks {
+		if !checks[i].OK {
+			checks[i].Message = "not subscribed"
+		}
+	}
+
+	return checks
+}
+
+// metaDBProbeKey returns the key written by the write probe of the meta db. Every
+// metaserver has its own key.
+func metaDBProbeKey(conf SectionRedis) string {
+	return conf.MetaHashtable + ":probe:" + ProcessID
+}
+
+// checkMetaDB checks that the meta db is a master which accepts a write. The store
+// failures before the write are cleared.
+func (w *SentinelWorker) checkMetaDB() HealthCheck {
+	check := HealthCheck{Name: "meta_db"}
+	start := time.Now()
+	conf := w.conf()
+	w.RLock()
+	metaDB, ok := w.meta.Instances[conf.MetaDBName]
+	var addr string
+	if ok && metaDB.Master != nil {
+		addr = metaDB.Master.TcpAddr().String()
+	}
+	w.RUnlock()
+	if addr == "" {
+		check.Message = fmt.Sprintf("master of meta db %s is unknown", conf.MetaDBName)
+		return check
+	}
+
+	conn, err := getMasterConn(conf.MetaDBName, addr)
+	if err != nil {
+		check.Message = err.Error()
+		return check
+	}
+	defer conn.Close()
+	// a master refusing writes, such as one with min-replicas-to-write unmet, fails here
+	_, err = conn.Do("set", metaDBProbeKey(conf), start.Unix(), "px", int64(maxUpdateDelay()/time.Millisecond))
+	if err != nil {
+		check.Message = fmt.Sprintf("write probe of %s failed: %v", addr, err)
+		return check
+	}
+	w.clearStoreErr(start)
+	check.OK = true
+	check.Message = addr
+
+	return check
+}
+
+// refreshDependencies checks the sentinels and the meta db. It is run by the update
+// loop, and /readyz serves its results, so the requests never reach them.
+func (w *SentinelWorker) refreshDependencies() {
+	checks := []HealthCheck{w.checkSentinel(), w.checkMetaDB()}
+	w.healthLock.Lock()
+	w.health.deps = checks
+	w.health.depsTime = time.Now()
+	w.healthLock.Unlock()
+}
+
+// checkDependencies returns the results of the last refreshDependencies, which fail
+// if they are out of date. The meta db check fails if a store failed after it.
+func (w *SentinelWorker) checkDependencies(now time.Time) []HealthCheck {
+	health := w.getHealth()
+	if health.deps == nil {
+		return []HealthCheck{
+			{Name: "sentinel", Message: "not checked yet"},
+			{Name: "meta_db", Message: "not checked yet"},
+		}
+	}
+
+	checks := append([]HealthCheck{}, health.deps...)
+	if delay := now.Sub(health.depsTime); maxUpdateDelay() < delay {
+		for i := range checks {
+			checks[i].OK = false
+			checks[i].Message = fmt.Sprintf("last checked %s ago", delay.Truncate(time.Second))
+		}
+		return checks
+	}
+	if health.storeErr != nil && checks[1].OK {
+		checks[1].OK = false
+		checks[1].Message = fmt.Sprintf("last store failed: %v", health.storeErr)
+	}
+
+	return checks
+}
+
+// writeHealthReport writes @checks with 200 if all of them pass, or 503 otherwise. The
+// messages of the checks tell addresses and errors, so they are only written to the
+// authenticated callers of request @r.
+func writeHealthReport(w http.ResponseWriter, r *http.Request, cluster string, checks []HealthCheck,
+	okMsg string, failedMsg string) {
+	if getCaller(r).Role < RoleReader {
+		for i := range checks {
+			checks[i].Message = ""
+		}
+	}
+	report := HealthReport{Code: EC_OK, Message: okMsg, Cluster: cluster, Checks: checks}
+	status := http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			report.Code, report.Message = EC_SYS_ERROR, failedMsg
+			status = http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, status, &report)
+}
+
+// healthHandler serves /healthz. The process is alive if the update loops of all
+// clusters are not wedged.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	checks := make([]HealthCheck, 0, len(clusterWorkers))
+	for _, cluster := range getConf().Redis.ClusterNames() {
+		if sw, ok := clusterWorkers[cluster]; ok {
+			checks = append(checks, sw.checkLoop(now))
+		}
+	}
+
+	writeHealthReport(w, r, "", checks, "alive", "wedged")
+}
+
+// readyHandler serves /readyz of a cluster. The cluster is ready if the meta is up
+// to date, one of the sentinels is reachable, both watchers are subscribed and the
+// meta db is writable. The sentinels and the meta db are checked by the update loop.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	sw := workerOf(r)
+	now := time.Now()
+	deps := sw.checkDependencies(now)
+	checks := []HealthCheck{sw.checkMeta(now), deps[0]}
+	checks = append(checks, sw.checkWatchers()...)
+	checks = append(checks, deps[1])
+
+	writeHealthReport(w, r, sw.cluster, checks, "ready", "not ready")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+func TestSentinelWorker_checkMeta(t *testing.T) {
+	oldConf := *getConf()
+	conf := oldConf
+	conf.Redis.UpdateInterval = 5
+	runningConf.set(conf)
+	defer runningConf.set(oldConf)
+
+	now := time.Now()
+	sw := &SentinelWorker{
+		cluster: DefaultCluster,
+		meta:    ClusterMeta{Version: 3, Instances: map[string]*gxredis.Instance{"cache1": {Name: "cache1"}}},
+	}
+	if check := sw.checkMeta(now); check.OK {
+		t.Errorf("checkMeta() in degraded mode = %+v", check)
+	}
+
+	sw.ready = true
+	sw.setUpdateResult(errors.New("st.GetInstances"))
+	if check := sw.checkMeta(now); check.OK {
+		t.Errorf("checkMeta() never updated = %+v", check)
+	}
+	sw.setUpdateResult(nil)
+	if check := sw.checkMeta(now); !check.OK {
+		t.Errorf("checkMeta() = %+v", check)
+	}
+	if check := sw.checkMeta(now.Add(time.Minute)); check.OK {
+		t.Errorf("checkMeta() of out-of-date meta = %+v", check)
+	}
+}
+
+func Test_healthHandler(t *testing.T) {
+	oldConf, oldWorkers := *getConf(), clusterWorkers
+	conf := oldConf
+	conf.Redis.UpdateInterval = 5
+	runningConf.set(conf)
+	sw := &SentinelWorker{cluster: DefaultCluster, health: workerHealth{loopTime: time.Now()}}
+	clusterWorkers = map[string]*SentinelWorker{DefaultCluster: sw}
+	defer func() {
+		runningConf.set(oldConf)
+		clusterWorkers = oldWorkers
+	}()
+
+	w := httptest.NewRecorder()
+	healthHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /healthz = %d, %s", w.Code, w.Body.String())
+	}
+
+	sw.health.loopTime = time.Now().Add(-time.Minute)
+	w = httptest.NewRecorder()
+	healthHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	var report HealthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("json.Unmarshal(%s) = error:%#v", w.Body.String(), err)
+	}
+	if w.Code != http.StatusServiceUnavailable || report.Code != EC_SYS_ERROR ||
+		len(report.Checks) != 1 || report.Checks[0].OK {
+		t.Errorf("GET /healthz of wedged loop = %d, %+v", w.Code, report)
+	}
+}
+
+func Test_readyHandler(t *testing.T) {
+	oldConf, oldWorker := *getConf(), worker
+	conf := oldConf
+	conf.Redis.UpdateInterval = 5
+	conf.Redis.MetaDBName = "meta"
+	runningConf.set(conf)
+	worker = &SentinelWorker{cluster: DefaultCluster, meta: ClusterMeta{Instances: map[string]*gxredis.Instance{}}}
+	defer func() {
+		runningConf.set(oldConf)
+		worker = oldWorker
+	}()
+
+	names := []string{"meta", "sentinel", "watcher:" + SwitchMasterChannel, "watcher:" + SdownChannel, "meta_db"}
+	reader := Caller{Identity: "token:ops", Role: RoleReader}
+	for _, caller := range []*Caller{nil, &reader} {
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		if caller != nil {
+			r = r.WithContext(context.WithValue(r.Context(), callerKey{}, *caller))
+		}
+		w := httptest.NewRecorder()
+		readyHandler(w, r)
+		var report HealthReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = error:%#v", w.Body.String(), err)
+		}
+		if w.Code != http.StatusServiceUnavailable || report.Message != "not ready" || report.Cluster != DefaultCluster {
+			t.Errorf("GET /readyz = %d, %+v", w.Code, report)
+		}
+
+		if len(report.Checks) != len(names) {
+			t.Fatalf("checks = %+v", report.Checks)
+		}
+		for i, check := range report.Checks {
+			// the details are only told to the authenticated callers
+			if check.Name != names[i] || check.OK || (check.Message != "") != (caller != nil) {
+				t.Errorf("check[%d] of caller %v = %+v, want failed %s", i, caller, check, names[i])
+			}
+		}
+	}
+}
+
+func TestSentinelWorker_checkDependencies(t *testing.T) {
+	sentinel := newRedisStandIn(t, "", nil)
+	defer sentinel.Close()
+	master := newRedisStandIn(t, "", map[string]interface{}{"role": []interface{}{"master", 0, []interface{}{}}})
+	defer master.Close()
+	readOnly := newRedisStandIn(t, "", map[string]interface{}{
+		"role": []interface{}{"master", 0, []interface{}{}},
+		"set":  standInError("NOREPLICAS Not enough good replicas to write."),
+	})
+	defer readOnly.Close()
+
+	oldConf := *getConf()
+	conf := oldConf
+	conf.Redis.UpdateInterval = 5
+	conf.Redis.MetaDBName = "meta"
+	conf.Redis.MetaHashtable = "meta_hashtable"
+	runningConf.set(conf)
+	defer runningConf.set(oldConf)
+	metaAt := func(node *redisStandIn) ClusterMeta {
+		addr := node.listener.Addr().(*net.TCPAddr)
+		return ClusterMeta{Instances: map[string]*gxredis.Instance{
+			"meta": {Name: "meta", Master: &gxredis.IPAddr{IP: addr.IP.String(), Port: int32(addr.Port)}},
+		}}
+	}
+
+	now := time.Now()
+	sw := &SentinelWorker{cluster: DefaultCluster, sentinels: []string{sentinel.Addr()}, meta: metaAt(master)}
+	if checks := sw.checkDependencies(now); checks[0].OK || checks[1].OK {
+		t.Errorf("checkDependencies() before checked = %+v", checks)
+	}
+
+	sw.setStoreResult(errors.New("hmset: i/o timeout"))
+	sw.refreshDependencies()
+	if checks := sw.checkDependencies(now); !checks[0].OK || !checks[1].OK {
+		t.Errorf("checkDependencies() after a write probe = %+v", checks)
+	}
+	commands := master.Commands()
+	if len(commands) != 2 || !strings.HasPrefix(commands[1], "set meta_hashtable:probe:") {
+		t.Errorf("commands of meta db = %q, want role and a write probe", commands)
+	}
+	// the results are cached
+	sentinelCommands := len(sentinel.Commands())
+	sw.checkDependencies(now)
+	if len(master.Commands()) != len(commands) || len(sentinel.Commands()) != sentinelCommands {
+		t.Errorf("checkDependencies() reaches the dependencies")
+	}
+
+	sw.setStoreResult(errors.New("hmset: i/o timeout"))
+	if checks := sw.checkDependencies(now); checks[1].OK || !strings.Contains(checks[1].Message, "last store failed") {
+		t.Errorf("checkDependencies() after a store failure = %+v", checks)
+	}
+	if checks := sw.checkDependencies(now.Add(time.Minute)); checks[0].OK || checks[1].OK {
+		t.Errorf("checkDependencies() of out-of-date results = %+v", checks)
+	}
+
+	sw.meta = metaAt(readOnly)
+	sw.refreshDependencies()
+	if checks := sw.checkDependencies(now); checks[1].OK || !strings.Contains(checks[1].Message, "NOREPLICAS") {
+		t.Errorf("checkDependencies() of a master refusing writes = %+v", checks)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+// operator-controlled states of an instance. Clients should stop routing to a
+// draining instance, and a maintenance instance may be unavailable at any time.
+const (
+	InstanceActive      = "active"
+	InstanceDraining    = "draining"
+	InstanceMaintenance = "maintenance"
+)
+
+type (
+	// InstanceAttrs is the operator-controlled attributes of an instance, which are
+	// kept while the instance is updated by sentinel data.
+	InstanceAttrs struct {
+		State       string            `json:"State,omitempty"`     // empty means active
+		Namespace   string            `json:"Namespace,omitempty"` // empty means DefaultNamespace
+		Labels      map[string]string `json:"Labels,omitempty"`
+		Annotations map[string]string `json:"Annotations,omitempty"`
+	}
+
+	// MetaInstance is an instance in the stored meta with its attributes. Its JSON
+	// is the JSON of gxredis.Instance plus the attributes, so the old clients can
+	// still parse it.
+	MetaInstance struct {
+		*gxredis.Instance
+		InstanceAttrs
+		// zones of the master and slaves keyed by "ip:port". They are resolved
+		// when the meta is served or stored.
+		Zones map[string]string `json:"Zones,omitempty"`
+	}
+
+	// MetaView is the meta served to clients
+	MetaView struct {
+		Version   int32                   `json:"Version,omitempty"`
+		Instances map[string]MetaInstance `json:"Instances,omitempty"`
+	}
+)
+
+func checkInstanceState(state string) error {
+	switch state {
+	case InstanceActive, InstanceDraining, InstanceMaintenance:
+		return nil
+	}
+
+	return fmt.Errorf("illegal instance state %q", state)
+}
+
+// empty returns true if @a has no attribute
+func (a InstanceAttrs) empty() bool {
+	return (a.State == "" || a.State == InstanceActive) && (a.Namespace == "" || a.Namespace == DefaultNamespace) &&
+		len(a.Labels) == 0 && len(a.Annotations) == 0
+}
+
+// metaInstance returns instance @name with its attributes. The caller should hold the read lock.
+func (w *SentinelWorker) metaInstance(name string, inst *gxredis.Instance) MetaInstance {
+	attrs := w.attrs[name]
+	return MetaInstance{Instance: inst, InstanceAttrs: attrs, Zones: w.zones.instanceZones(inst, attrs.Labels[ZoneLabel])}
+}
+
+// metaView returns the meta with the attributes of the instances. The caller should hold the read lock.
+func (w *SentinelWorker) metaView() MetaView {
+	view := MetaView{
+		Version:   w.meta.Version,
+		Instances: make(map[string]MetaInstance, len(w.meta.Instances)),
+	}
+	for name, inst := range w.meta.Instances {
+		view.Instances[name] = w.metaInstance(name, inst)
+	}
+
+	return view
+}
+
+// splitMetaView splits @view into the meta and the instance attributes
+func splitMetaView(view MetaView) (ClusterMeta, map[string]InstanceAttrs) {
+	meta := ClusterMeta{
+		Version:   view.Version,
+		Instances: make(map[string]*gxredis.Instance, len(view.Instances)),
+	}
+	attrs := make(map[string]InstanceAttrs)
+	for name, inst := range view.Instances {
+		if inst.Instance == nil {
+			continue
+		}
+		meta.Instances[name] = inst.Instance
+		if !inst.InstanceAttrs.empty() {
+			attrs[name] = inst.InstanceAttrs
+		}
+	}
+
+	return meta, attrs
+}
+
+// getInstanceAttrs returns the attributes of instance @name
+func (w *SentinelWorker) getInstanceAttrs(name string) InstanceAttrs {
+	w.RLock()
+	defer w.RUnlock()
+
+	attrs := w.attrs[name]
+	if attrs.State == "" {
+		attrs.State = InstanceActive
+	}
+	attrs.Namespace = attrs.namespace()
+	return attrs
+}
+
+// updateInstanceAttrs changes the attributes of instance @name by @update, and stores
+// the meta with a new version if they change.
+func (w *SentinelWorker) updateInstanceAttrs(name string, update func(*InstanceAttrs)) (bool, error) {
+	w.Lock()
+	if _, ok := w.meta.Instances[name]; !ok {
+		w.Unlock()
+		return false, fmt.Errorf("instance %s not found", name)
+	}
+	old := w.attrs[name]
+	attrs := normalizeAttrs(old)
+	update(&attrs)
+	if attrs.State == InstanceActive {
+		attrs.State = ""
+	}
+	if attrs.Namespace == DefaultNamespace {
+		attrs.Namespace = ""
+	}
+	if reflect.DeepEqual(normalizeAttrs(old), normalizeAttrs(attrs)) {
+		w.Unlock()
+		return false, nil
+	}
+	if attrs.empty() {
+		delete(w.attrs, name)
+	} else {
+		w.attrs[name] = attrs
+	}
+	w.meta.Version++
+	w.Unlock()
+
+	return true, w.storeClusterMetaData()
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// normalizeAttrs returns a deep copy of @a whose empty maps are nil
+func normalizeAttrs(a InstanceAttrs) InstanceAttrs {
+	return InstanceAttrs{
+		State:       a.State,
+		Namespace:   a.Namespace,
+		Labels:      copyStringMap(a.Labels),
+		Annotations: copyStringMap(a.Annotations),
+	}
+}
+
+// setInstanceState changes the state of instance @name, and stores the meta with
+// a new version if the state changes.
+func (w *SentinelWorker) setInstanceState(name string, state string) error {
+	if err := checkInstanceState(state); err != nil {
+		return err
+	}
+
+	old := w.getInstanceAttrs(name).State
+	changed, err := w.updateInstanceAttrs(name, func(attrs *InstanceAttrs) {
+		attrs.State = state
+	})
+	if changed {
+		Log.Info("state of instance %s changes from %s to %s", name, old, state)
+	}
+
+	return err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+func TestMetaView_JSON(t *testing.T) {
+	w := &SentinelWorker{
+		meta: ClusterMeta{
+			Version: 3,
+			Instances: map[string]*gxredis.Instance{
+				"cache1": {Name: "cache1", Master: &gxredis.IPAddr{IP: "192.168.11.100", Port: 4001}},
+				"cache2": {Name: "cache2", Master: &gxredis.IPAddr{IP: "192.168.11.100", Port: 4002}},
+			},
+		},
+		attrs: map[string]InstanceAttrs{"cache2": {State: InstanceDraining}},
+	}
+
+	data, err := json.Marshal(w.metaView())
+	if err != nil {
+		t.Fatalf("json.Marshal() = error:%#v", err)
+	}
+	if !strings.Contains(string(data), `"State":"draining"`) {
+		t.Errorf("state is not in meta:%s", data)
+	}
+
+	// the instance json keeps the format of gxredis.Instance
+	var inst gxredis.Instance
+	instData, _ := json.Marshal(w.metaInstance("cache2", w.meta.Instances["cache2"]))
+	if err = json.Unmarshal(instData, &inst); err != nil || inst.Name != "cache2" {
+		t.Errorf("json.Unmarshal(%s) = {inst:%#v, error:%v}", instData, inst, err)
+	}
+
+	var view MetaView
+	if err = json.Unmarshal(data, &view); err != nil {
+		t.Fatalf("json.Unmarshal() = error:%#v", err)
+	}
+	meta, attrs := splitMetaView(view)
+	if meta.Version != 3 || len(meta.Instances) != 2 || meta.Instances["cache1"].Master.Port != 4001 {
+		t.Errorf("meta:%#v", meta)
+	}
+	if len(attrs) != 1 || attrs["cache2"].State != InstanceDraining {
+		t.Errorf("attrs:%v", attrs)
+	}
+}
+
+func Test_checkInstanceState(t *testing.T) {
+	for _, state := range []string{InstanceActive, InstanceDraining, InstanceMaintenance} {
+		if err := checkInstanceState(state); err != nil {
+			t.Errorf("checkInstanceState(%s) = error:%#v", state, err)
+		}
+	}
+	if err := checkInstanceState("down"); err == nil {
+		t.Errorf("checkInstanceState(down) should fail")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	MaxLabelLength = 63
+)
+
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_./]*[A-Za-z0-9])?$`)
+
+type (
+	// LabelsPatch changes the labels and annotations of an instance. A null value
+	// deletes the key.
+	LabelsPatch struct {
+		Labels      map[string]*string `json:"labels"`
+		Annotations map[string]*string `json:"annotations"`
+	}
+
+	labelRequirement struct {
+		key    string
+		op     string // "=", "!=", "exists" or "!exists"
+		values string
+	}
+
+	// LabelSelector selects instances by labels. It is a comma separated list of
+	// requirements, such as "tier=hot,zone!=b,owner,!deprecated", all of which
+	// should be satisfied.
+	LabelSelector []labelRequirement
+)
+
+func checkLabelKey(key string) error {
+	if len(key) == 0 || MaxLabelLength < len(key) || !labelPattern.MatchString(key) {
+		return fmt.Errorf("illegal label key %q", key)
+	}
+	return nil
+}
+
+func checkLabelValue(value string) error {
+	if value == "" {
+		return nil
+	}
+	if MaxLabelLength < len(value) || !labelPattern.MatchString(value) {
+		return fmt.Errorf("illegal label value %q", value)
+	}
+	return nil
+}
+
+// Validate checks the keys and values. An annotation value can be any string.
+func (p *LabelsPatch) Validate() error {
+	if len(p.Labels) == 0 && len(p.Annotations) == 0 {
+		return fmt.Errorf("nothing to update")
+	}
+	for key, value := range p.Labels {
+		if err := checkLabelKey(key); err != nil {
+			return err
+		}
+		if value != nil {
+			if err := checkLabelValue(*value); err != nil {
+				return err
+			}
+		}
+	}
+	for key := range p.Annotations {
+		if err := checkLabelKey(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func patchStringMap(m map[string]string, patch map[string]*string) map[string]string {
+	if m == nil {
+		m = make(map[string]string, len(patch))
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(m, key)
+		} else {
+			m[key] = *value
+		}
+	}
+
+	return m
+}
+
+// ParseLabelSelector parses @selector. An empty selector selects everything.
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	var s LabelSelector
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var req labelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			kv := strings.SplitN(term, "!=", 2)
+			req = labelRequirement{key: kv[0], op: "!=", values: kv[1]}
+		case strings.Contains(term, "=="):
+			kv := strings.SplitN(term, "==", 2)
+			req = labelRequirement{key: kv[0], op: "=", values: kv[1]}
+		case strings.Contains(term, "="):
+			kv := strings.SplitN(term, "=", 2)
+			req = labelRequirement{key: kv[0], op: "=", values: kv[1]}
+		case strings.HasPrefix(term, "!"):
+			req = labelRequirement{key: term[1:], op: "!exists"}
+		default:
+			req = labelRequirement{key: term, op: "exists"}
+		}
+		req.key, req.values = strings.TrimSpace(req.key), strings.TrimSpace(req.values)
+		if err := checkLabelKey(req.key); err != nil {
+			return nil, fmt.Errorf("illegal selector %q: %v", term, err)
+		}
+		if err := checkLabelValue(req.values); err != nil {
+			return nil, fmt.Errorf("illegal selector %q: %v", term, err)
+		}
+		s = append(s, req)
+	}
+
+	return s, nil
+}
+
+// Matches returns true if @labels satisfy all requirements of @s
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, ok := labels[req.key]
+		switch req.op {
+		case "=":
+			if !ok || value != req.values {
+				return false
+			}
+		case "!=":
+			// an instance without the label satisfies "!="
+			if ok && value == req.values {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Select returns the instances of @view that match @s
+func (s LabelSelector) Select(view MetaView) MetaView {
+	if len(s) == 0 {
+		return view
+	}
+
+	selected := MetaView{Version: view.Version, Instances: make(map[string]MetaInstance, len(view.Instances))}
+	for name, inst := range view.Instances {
+		if s.Matches(inst.Labels) {
+			selected.Instances[name] = inst
+		}
+	}
+
+	return selected
+}
+
+// patchLabels changes the labels and annotations of instance @name by @patch
+func (w *SentinelWorker) patchLabels(name string, patch LabelsPatch) error {
+	changed, err := w.updateInstanceAttrs(name, func(attrs *InstanceAttrs) {
+		attrs.Labels = patchStringMap(attrs.Labels, patch.Labels)
+		attrs.Annotations = patchStringMap(attrs.Annotations, patch.Annotations)
+	})
+	if changed {
+		Log.Info("labels of instance %s have been changed, labels:%v", name, w.getInstanceAttrs(name).Labels)
+	}
+
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+func TestLabelSelector_Matches(t *testing.T) {
+	labels := map[string]string{"tier": "hot", "zone": "a", "owner": "team-a"}
+	cases := []struct {
+		selector string
+		match    bool
+	}{
+		{"", true},
+		{"tier=hot", true},
+		{"tier==hot,zone!=b", true},
+		{"tier=cold", false},
+		{"zone!=a", false},
+		{"product!=x", true},
+		{"owner", true},
+		{"product", false},
+		{"!product", true},
+		{"!owner", false},
+		{"tier=hot, zone=a, !deprecated", true},
+	}
+	for _, c := range cases {
+		s, err := ParseLabelSelector(c.selector)
+		if err != nil {
+			t.Errorf("ParseLabelSelector(%q) = error:%#v", c.selector, err)
+			continue
+		}
+		if match := s.Matches(labels); match != c.match {
+			t.Errorf("%q.Matches(%v) = %v", c.selector, labels, match)
+		}
+	}
+
+	for _, selector := range []string{"=hot", "tier=h ot", "!", "tier!=-a"} {
+		if _, err := ParseLabelSelector(selector); err == nil {
+			t.Errorf("ParseLabelSelector(%q) should fail", selector)
+		}
+	}
+}
+
+func TestLabelSelector_Select(t *testing.T) {
+	view := MetaView{
+		Version: 5,
+		Instances: map[string]MetaInstance{
+			"cache1": {Instance: &gxredis.Instance{Name: "cache1"}, InstanceAttrs: InstanceAttrs{Labels: map[string]string{"tier": "hot"}}},
+			"cache2": {Instance: &gxredis.Instance{Name: "cache2"}, InstanceAttrs: InstanceAttrs{Labels: map[string]string{"tier": "cold"}}},
+			"cache3": {Instance: &gxredis.Instance{Name: "cache3"}},
+		},
+	}
+	s, _ := ParseLabelSelector("tier=hot")
+	selected := s.Select(view)
+	if selected.Version != 5 || len(selected.Instances) != 1 || selected.Instances["cache1"].Name != "cache1" {
+		t.Errorf("Select() = %+v", selected)
+	}
+}
+
+func TestLabelsPatch_Validate(t *testing.T) {
+	hot, illegal := "hot", "h ot"
+	if err := (&LabelsPatch{Labels: map[string]*string{"tier": &hot, "zone": nil}}).Validate(); err != nil {
+		t.Errorf("Validate() = error:%#v", err)
+	}
+	if err := (&LabelsPatch{Annotations: map[string]*string{"owner": &illegal}}).Validate(); err != nil {
+		t.Errorf("annotation value can be any string, error:%#v", err)
+	}
+	for _, patch := range []LabelsPatch{
+		{},
+		{Labels: map[string]*string{"tier": &illegal}},
+		{Labels: map[string]*string{"ti er": &hot}},
+	} {
+		if err := patch.Validate(); err == nil {
+			t.Errorf("%+v.Validate() should fail", patch)
+		}
+	}
+
+	m := patchStringMap(map[string]string{"tier": "cold", "zone": "a"}, map[string]*string{"tier": &hot, "zone": nil})
+	if len(m) != 1 || m["tier"] != "hot" {
+		t.Errorf("patchStringMap() = %v", m)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+)
+
+import (
+	"github.com/AlexStocks/goext/log"
+)
+
+type (
+	// reloadableLogger is the logger of the server. Every call holds the read lock,
+	// so the logger replaced by set is not in use any more once set returns, and
+	// can be closed safely.
+	reloadableLogger struct {
+		sync.RWMutex
+		logger gxlog.Logger
+	}
+)
+
+// newLogger creates a logger with the log configure file
+var newLogger = gxlog.NewLoggerWithConfFile
+
+func newReloadableLogger(logger gxlog.Logger) *reloadableLogger {
+	return &reloadableLogger{logger: logger}
+}
+
+// set replaces the logger with @logger, and returns the old one
+func (l *reloadableLogger) set(logger gxlog.Logger) gxlog.Logger {
+	l.Lock()
+	defer l.Unlock()
+	old := l.logger
+	l.logger = logger
+	return old
+}
+
+func (l *reloadableLogger) Debug(arg0 interface{}, args ...interface{}) {
+	l.RLock()
+	defer l.RUnlock()
+	l.logger.Debug(arg0, args...)
+}
+
+func (l *reloadableLogger) Info(arg0 interface{}, args ...interface{}) {
+	l.RLock()
+	defer l.RUnlock()
+	l.logger.Info(arg0, args...)
+}
+
+func (l *reloadableLogger) Warn(arg0 interface{}, args ...interface{}) error {
+	l.RLock()
+	defer l.RUnlock()
+	return l.logger.Warn(arg0, args...)
+}
+
+func (l *reloadableLogger) Error(arg0 interface{}, args ...interface{}) error {
+	l.RLock()
+	defer l.RUnlock()
+	return l.logger.Error(arg0, args...)
+}
+
+func (l *reloadableLogger) Critic(arg0 interface{}, args ...interface{}) error {
+	l.RLock()
+	defer l.RUnlock()
+	return l.logger.Critic(arg0, args...)
+}
+
+func (l *reloadableLogger) Critical(arg0 interface{}, args ...interface{}) error {
+	l.RLock()
+	defer l.RUnlock()
+	return l.logger.Critical(arg0, args...)
+}
+
+func (l *reloadableLogger) SetAsDefaultLogger() {
+	l.RLock()
+	defer l.RUnlock()
+	l.logger.SetAsDefaultLogger()
+}
+
+func (l *reloadableLogger) Close() {
+	l.RLock()
+	defer l.RUnlock()
+	l.logger.Close()
+}
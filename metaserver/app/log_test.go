@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLogger records the messages, and blocks Info until release is closed
+type fakeLogger struct {
+	sync.Mutex
+	messages []interface{}
+	closed   bool
+	release  chan struct{}
+}
+
+func (l *fakeLogger) record(arg0 interface{}) error {
+	l.Lock()
+	defer l.Unlock()
+	if l.closed {
+		panic("log after Close")
+	}
+	l.messages = append(l.messages, arg0)
+	return nil
+}
+
+func (l *fakeLogger) Debug(arg0 interface{}, args ...interface{}) { l.record(arg0) }
+func (l *fakeLogger) Info(arg0 interface{}, args ...interface{}) {
+	if l.release != nil {
+		<-l.release
+	}
+	l.record(arg0)
+}
+func (l *fakeLogger) Warn(arg0 interface{}, args ...interface{}) error     { return l.record(arg0) }
+func (l *fakeLogger) Error(arg0 interface{}, args ...interface{}) error    { return l.record(arg0) }
+func (l *fakeLogger) Critic(arg0 interface{}, args ...interface{}) error   { return l.record(arg0) }
+func (l *fakeLogger) Critical(arg0 interface{}, args ...interface{}) error { return l.record(arg0) }
+func (l *fakeLogger) SetAsDefaultLogger()                                  {}
+func (l *fakeLogger) Close() {
+	l.Lock()
+	defer l.Unlock()
+	l.closed = true
+}
+
+func TestReloadableLogger_set(t *testing.T) {
+	oldLogger := &fakeLogger{release: make(chan struct{})}
+	newLogger := &fakeLogger{}
+	logger := newReloadableLogger(oldLogger)
+
+	// a call in progress on the old logger
+	done := make(chan struct{})
+	go func() {
+		logger.Info("in progress")
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	replaced := make(chan struct{})
+	go func() {
+		logger.set(newLogger).Close()
+		close(replaced)
+	}()
+	select {
+	case <-replaced:
+		t.Fatalf("set() returns while the old logger is in use")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(oldLogger.release)
+	<-done
+	<-replaced
+
+	logger.Warn("after reload")
+	if len(oldLogger.messages) != 1 || !oldLogger.closed {
+		t.Errorf("old logger = {messages:%v, closed:%v}", oldLogger.messages, oldLogger.closed)
+	}
+	if len(newLogger.messages) != 1 || newLogger.messages[0] != "after reload" || newLogger.closed {
+		t.Errorf("new logger = {messages:%v, closed:%v}", newLogger.messages, newLogger.closed)
+	}
+}
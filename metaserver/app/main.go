@@ -4,18 +4,15 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"path"
 	"path/filepath"
 	"strconv"
 	"syscall"
-	"time"
 )
 
 import (
-	"github.com/AlexStocks/goext/log"
 	"github.com/AlexStocks/goext/net"
 	"github.com/AlexStocks/goext/time"
 )
@@ -30,13 +27,12 @@ const (
 )
 
 var (
-	pprofPath = "/debug/pprof/"
-
 	usageStr = `
 Usage: log-kafka [options]
 Server Options:
     -c, --config <file>              Configuration file path
     -l, --log <file>                 Log configuration file
+    -t, --test                       Test configuration and exit
 Common Options:
     -h, --help                       Show this message
     -v, --version                    Show version
@@ -68,13 +64,14 @@ func getHostInfo() {
 }
 
 func createPIDFile() error {
-	if !Conf.Core.PID.Enabled {
+	pid := getConf().Core.PID
+	if !pid.Enabled {
 		return nil
 	}
 
-	pidPath := Conf.Core.PID.Path
+	pidPath := pid.Path
 	_, err := os.Stat(pidPath)
-	if os.IsNotExist(err) || Conf.Core.PID.Override {
+	if os.IsNotExist(err) || pid.Override {
 		currentPid := os.Getpid()
 		if err := os.MkdirAll(filepath.Dir(pidPath), os.ModePerm); err != nil {
 			return fmt.Errorf("Can't create PID folder on %v", err)
@@ -94,9 +91,52 @@ func createPIDFile() error {
 	return nil
 }
 
+// loadConf loads and validates the configure file @file
+func loadConf(file string) (ConfYaml, error) {
+	conf, err := LoadConfYaml(file)
+	if err != nil {
+		return conf, err
+	}
+	if conf.Core.FailFastTimeout == 0 {
+		conf.Core.FailFastTimeout = FailfastTimeout
+	}
+	if conf.Core.TLS.MinVersion == "" {
+		conf.Core.TLS.MinVersion = "1.2"
+	}
+	if conf.Core.APIAuth.HMACMaxSkew == 0 {
+		conf.Core.APIAuth.HMACMaxSkew = DefaultHMACMaxSkew
+	}
+	if conf.Federation.StaleTimeout == 0 {
+		conf.Federation.StaleTimeout = DefaultPeerStaleTimeout
+	}
+	if conf.Federation.RetryInterval == 0 {
+		conf.Federation.RetryInterval = DefaultPeerRetryInterval
+	}
+	if conf.Webhook.Timeout == 0 {
+		conf.Webhook.Timeout = DefaultWebhookTimeout
+	}
+	if conf.Webhook.RetryBackoff == 0 {
+		conf.Webhook.RetryBackoff = DefaultWebhookRetryBackoff
+	}
+	if conf.Webhook.MaxRetryBackoff == 0 {
+		conf.Webhook.MaxRetryBackoff = DefaultWebhookMaxRetryBackoff
+	}
+	if conf.Webhook.QueueSize == 0 {
+		conf.Webhook.QueueSize = DefaultWebhookQueueSize
+	}
+	if len(conf.Redis.DiscoverExcludeHosts) == 0 {
+		conf.Redis.DiscoverExcludeHosts = []string{"127.0.0.1"}
+	}
+	if err = conf.Validate(); err != nil {
+		return conf, err
+	}
+
+	return conf, nil
+}
+
 // initLog use for initial log module
 func initLog(logConf string) {
-	Log = gxlog.NewLoggerWithConfFile(logConf)
+	Log = newReloadableLogger(newLogger(logConf))
 	Log.SetAsDefaultLogger()
 }
 
@@ -104,7 +144,6 @@ func initSignal() {
 	var (
 		// signal.Notify的ch信道是阻塞的(signal.Notify不会阻塞发送信号), 需要设置缓冲
 		signals = make(chan os.Signal, 1)
-		ticker  = time.NewTicker(gxtime.TimeSecondDuration(float64(Conf.Redis.UpdateInterval)))
 	)
 	// It is not possible to block SIGKILL or syscall.SIGSTOP
 	signal.Notify(signals, os.Interrupt, os.Kill, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
@@ -114,23 +153,25 @@ func initSignal() {
 			Log.Info("get signal %s", sig.String())
 			switch sig {
 			case syscall.SIGHUP:
-			// reload()
+				// every worker takes the new update_interval in its update loop
+				if err := reload(); err != nil {
+					Log.Error("reload() = error:%#v", err)
+				}
 			default:
-				go gxtime.Future(Conf.Core.FailFastTimeout, func() {
+				go gxtime.Future(getConf().Core.FailFastTimeout, func() {
 					Log.Warn("app exit now by force...")
 					Log.Close()
 					os.Exit(1)
 				})
 
 				// 要么survialTimeout时间内执行完毕下面的逻辑然后程序退出，要么执行上面的超时函数程序强行退出
-				worker.Close()
+				closePeerWatchers()
+				closeClusterWorkers()
+				closeWebhooks()
 				Log.Warn("app exit now...")
 				Log.Close()
 				return
 			}
-
-		case <-ticker.C:
-			worker.updateClusterMeta()
 		}
 	}
 }
@@ -139,6 +180,7 @@ func main() {
 	var (
 		err         error
 		showVersion bool
+		testMode    bool
 		configFile  string
 		logConf     string
 	)
@@ -155,6 +197,8 @@ func main() {
 	flag.StringVar(&configFile, "config", "", "Configuration file path.")
 	flag.StringVar(&logConf, "l", "", "Logger configuration file.")
 	flag.StringVar(&logConf, "log", "", "Logger configuration file.")
+	flag.BoolVar(&testMode, "t", false, "Test configuration and exit.")
+	flag.BoolVar(&testMode, "test", false, "Test configuration and exit.")
 
 	flag.Usage = usage
 	flag.Parse()
@@ -174,12 +218,21 @@ func main() {
 	if path.Ext(configFile) != ".yml" {
 		panic(fmt.Sprintf("application configure file name{%v} suffix must be .yml", configFile))
 	}
-	Conf, err = LoadConfYaml(configFile)
+	if testMode {
+		os.Exit(testConf(configFile))
+	}
+	conf, err := loadConf(configFile)
 	if err != nil {
 		log.Printf("Load yaml config file error: '%v'", err)
 		return
 	}
-	fmt.Printf("config: %+v\n", Conf)
+	runningConf.set(conf)
+	fmt.Printf("config: %+v\n", redactedConf(conf))
+	confFile = configFile
+	confState.init(configFile)
+	// conf has been validated
+	auth, _ := NewAPIAuthenticator(conf.Core.APIAuth)
+	apiAuth.set(auth)
 
 	if logConf == "" {
 		logConf = os.Getenv(APP_LOG_CONF_FILE)
@@ -188,29 +241,29 @@ func main() {
 		}
 	}
 
+	logConfFile = logConf
+
 	/////////////////////////////////////////////////
 	// worker
 	/////////////////////////////////////////////////
-	if Conf.Core.FailFastTimeout == 0 {
-		Conf.Core.FailFastTimeout = FailfastTimeout
-	}
-
 	getHostInfo()
 
 	initLog(logConf)
+	for _, item := range conf.DeprecatedItems() {
+		Log.Warn("config item %s is deprecated and ignored", item)
+	}
 
 	if err = createPIDFile(); err != nil {
 		Log.Critic(err)
 	}
-	worker = NewSentinelWorker()
-	if err = worker.WatchInstanceSwitch(); err != nil {
-		panic(fmt.Sprintf("failed to start watch instance switch goroutine, error:%#v", err))
-	}
-	if err = worker.WatchSdown(); err != nil {
-		panic(fmt.Sprintf("failed to start watch +sdown goroutine, error:%#v", err))
-	}
+	startWebhooks()
+	startClusterWorkers()
+	startPeerWatchers()
 
-	go startHTTP(Conf.Core.BindAddr)
+	go startHTTP(conf.Core.BindAddr)
+	if conf.Core.DebugBindAddr != "" {
+		go startDebugHTTP(conf.Core.DebugBindAddr)
+	}
 
 	initSignal()
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+import (
+	"github.com/pkg/errors"
+)
+
+// metaCache is the content of the meta cache file
+type metaCache struct {
+	MetaView
+	NamespaceVersions map[string]int32 `json:"NamespaceVersions,omitempty"`
+}
+
+// saveMetaCache dumps the meta into the meta cache file of the cluster.
+// The caller should hold the read lock of the worker.
+func (w *SentinelWorker) saveMetaCache() error {
+	file := w.conf().MetaCacheFile
+	if file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(metaCache{MetaView: w.metaView(), NamespaceVersions: w.namespaceVersions()})
+	if err != nil {
+		return errors.Wrapf(err, "json.Marshal(%#v)", w.meta)
+	}
+	if err = os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "os.MkdirAll(%s)", filepath.Dir(file))
+	}
+	// write a temporary file and rename it to avoid a half-written cache file
+	tmpFile := file + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		return errors.Wrapf(err, "ioutil.WriteFile(%s)", tmpFile)
+	}
+	if err = os.Rename(tmpFile, file); err != nil {
+		return errors.Wrapf(err, "os.Rename(%s, %s)", tmpFile, file)
+	}
+
+	return nil
+}
+
+// loadMetaCache loads the meta saved by saveMetaCache.
+func (w *SentinelWorker) loadMetaCache() error {
+	file := w.conf().MetaCacheFile
+	if file == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrapf(err, "ioutil.ReadFile(%s)", file)
+	}
+	var cache metaCache
+	if err = json.Unmarshal(data, &cache); err != nil {
+		return errors.Wrapf(err, "json.Unmarshal(%s)", string(data))
+	}
+	meta, attrs := splitMetaView(cache.MetaView)
+	w.setNamespaceVersions(cache.NamespaceVersions)
+
+	w.Lock()
+	w.meta = meta
+	w.attrs = attrs
+	w.Unlock()
+	Log.Info("load meta from cache file %s, version:%d", file, meta.Version)
+
+	return nil
+}
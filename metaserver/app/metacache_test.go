@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+func newTestMetaCacheWorker() *SentinelWorker {
+	zones, _ := NewZoneResolver(nil)
+	return &SentinelWorker{
+		cluster:  DefaultCluster,
+		meta:     ClusterMeta{Instances: map[string]*gxredis.Instance{}},
+		attrs:    map[string]InstanceAttrs{},
+		nsStates: map[string]namespaceState{},
+		zones:    zones,
+	}
+}
+
+func TestSentinelWorker_metaCache(t *testing.T) {
+	oldConf := *getConf()
+	conf := oldConf
+	conf.Redis.MetaCacheFile = filepath.Join(t.TempDir(), "cache", "meta.json")
+	runningConf.set(conf)
+	oldLog := Log
+	Log = newReloadableLogger(&fakeLogger{})
+	defer func() {
+		runningConf.set(oldConf)
+		Log = oldLog
+	}()
+
+	sw := newTestMetaCacheWorker()
+	sw.meta = ClusterMeta{Version: 7, Instances: map[string]*gxredis.Instance{
+		"cache1": {
+			Name:   "cache1",
+			Master: &gxredis.IPAddr{IP: "192.168.11.100", Port: 4001},
+			Slaves: []*gxredis.Slave{{Addr: &gxredis.IPAddr{IP: "192.168.11.101", Port: 4001}}},
+		},
+		"cache2": {Name: "cache2", Master: &gxredis.IPAddr{IP: "192.168.11.100", Port: 4002}},
+	}}
+	sw.attrs = map[string]InstanceAttrs{
+		"cache2": {State: InstanceDraining, Namespace: "ns1", Labels: map[string]string{"team": "ops"}},
+	}
+	sw.nsStates = map[string]namespaceState{DefaultNamespace: {version: 3}, "ns1": {version: 5}}
+	if err := sw.saveMetaCache(); err != nil {
+		t.Fatalf("saveMetaCache() = error:%#v", err)
+	}
+
+	loaded := newTestMetaCacheWorker()
+	if err := loaded.loadMetaCache(); err != nil {
+		t.Fatalf("loadMetaCache() = error:%#v", err)
+	}
+	if !reflect.DeepEqual(loaded.metaView(), sw.metaView()) {
+		t.Errorf("loaded meta = %+v, want %+v", loaded.metaView(), sw.metaView())
+	}
+	if !reflect.DeepEqual(loaded.namespaceVersions(), sw.namespaceVersions()) {
+		t.Errorf("loaded namespace versions = %v, want %v", loaded.namespaceVersions(), sw.namespaceVersions())
+	}
+
+	// no cache file is configured
+	conf.Redis.MetaCacheFile = ""
+	runningConf.set(conf)
+	if err := newTestMetaCacheWorker().loadMetaCache(); err != nil {
+		t.Errorf("loadMetaCache() without cache file = error:%#v", err)
+	}
+}
+
+func TestNewSentinelWorker_degraded(t *testing.T) {
+	// a sentinel which never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = error:%#v", err)
+	}
+	conns := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				close(conns)
+				return
+			}
+			conns <- conn
+		}
+	}()
+	closeSentinel := func() {
+		listener.Close()
+		for conn := range conns {
+			conn.Close()
+		}
+	}
+
+	oldConf := *getConf()
+	conf := oldConf
+	conf.Redis.Sentinels = []string{listener.Addr().String()}
+	conf.Redis.MetaDBName = "meta"
+	conf.Redis.UpdateInterval = 3600
+	conf.Redis.MetaCacheFile = filepath.Join(t.TempDir(), "meta.json")
+	runningConf.set(conf)
+	oldLog := Log
+	Log = newReloadableLogger(&fakeLogger{})
+	defer func() {
+		runningConf.set(oldConf)
+		Log = oldLog
+	}()
+
+	cached := newTestMetaCacheWorker()
+	cached.meta = ClusterMeta{Version: 4, Instances: map[string]*gxredis.Instance{
+		"cache1": {Name: "cache1", Master: &gxredis.IPAddr{IP: "192.168.11.100", Port: 4001}},
+	}}
+	if err := cached.saveMetaCache(); err != nil {
+		t.Fatalf("saveMetaCache() = error:%#v", err)
+	}
+
+	// the worker is created without waiting for the sentinel
+	start := time.Now()
+	sw := NewSentinelWorker(DefaultCluster)
+	defer func() {
+		// the start fails at once after the connection is closed
+		closeSentinel()
+		sw.Close()
+	}()
+	if elapsed := time.Since(start); time.Second < elapsed {
+		t.Errorf("NewSentinelWorker() takes %s", elapsed)
+	}
+	if sw.Ready() {
+		t.Fatalf("worker with a sentinel not answering is ready")
+	}
+	sw.RLock()
+	meta := sw.meta
+	sw.RUnlock()
+	if meta.Version != 4 || len(meta.Instances) != 1 || meta.Instances["cache1"] == nil {
+		t.Errorf("meta in degraded mode = %+v, want the cached one", meta)
+	}
+	if check := sw.checkMeta(time.Now()); check.OK {
+		t.Errorf("checkMeta() in degraded mode = %+v", check)
+	}
+}
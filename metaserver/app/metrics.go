@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	MetricsNamespace = "metaserver"
+	MetricsPath      = "/metrics"
+)
+
+type (
+	// metaCollector collects the meta of all clusters and the state of their watchers
+	// and peers when scraped
+	metaCollector struct {
+		version            *prometheus.Desc
+		instances          *prometheus.Desc
+		slaves             *prometheus.Desc
+		withoutMaster      *prometheus.Desc
+		ready              *prometheus.Desc
+		watcherConnected   *prometheus.Desc
+		peerStale          *prometheus.Desc
+		peerVersion        *prometheus.Desc
+		peerUpdatedSeconds *prometheus.Desc
+	}
+
+	// measuredConn is a sentinel connection whose commands are measured
+	measuredConn struct {
+		redis.Conn
+	}
+
+	// statusRecorder records the status code written by a handler
+	statusRecorder struct {
+		http.ResponseWriter
+		status int
+	}
+)
+
+var (
+	sentinelEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "sentinel_events_total",
+		Help:      "Events received from the sentinels by cluster and channel.",
+	}, []string{"cluster", "event"})
+	storeMetaDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "store_meta_duration_seconds",
+		Help:      "Duration of storing the meta into the meta db.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"cluster"})
+	storeMetaFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "store_meta_failures_total",
+		Help:      "Failures of storing the meta into the meta db.",
+	}, []string{"cluster"})
+	sentinelCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "sentinel_call_duration_seconds",
+		Help:      "Latency of the calls to the sentinels by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"op"})
+	sentinelCallErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "sentinel_call_errors_total",
+		Help:      "Failed calls to the sentinels by operation.",
+	}, []string{"op"})
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+	webhookDeliveriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook deliveries by endpoint and result (ok, failed or dead).",
+	}, []string{"endpoint", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		newMetaCollector(),
+		sentinelEventsTotal,
+		storeMetaDuration,
+		storeMetaFailuresTotal,
+		sentinelCallDuration,
+		sentinelCallErrorsTotal,
+		httpRequestsTotal,
+		httpRequestDuration,
+		webhookDeliveriesTotal,
+	)
+}
+
+func newMetaCollector() *metaCollector {
+	desc := func(name string, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "", name), help, labels, nil)
+	}
+
+	return &metaCollector{
+		version:            desc("meta_version", "Current meta version.", "cluster"),
+		instances:          desc("instances", "Instances in the meta.", "cluster"),
+		slaves:             desc("instance_slaves", "Slaves of an instance in the meta.", "cluster", "instance"),
+		withoutMaster:      desc("instances_without_master", "Instances without a master in the meta.", "cluster"),
+		ready:              desc("ready", "1 if the worker of the cluster is not in degraded mode.", "cluster"),
+		watcherConnected:   desc("watcher_connected", "1 if the watcher subscribes to a sentinel.", "cluster", "channel"),
+		peerStale:          desc("peer_stale", "1 if the meta of the peer metaserver is stale.", "peer"),
+		peerVersion:        desc("peer_meta_version", "Meta version of the peer metaserver.", "peer"),
+		peerUpdatedSeconds: desc("peer_meta_updated_timestamp_seconds", "Time when the meta of the peer was received.", "peer"),
+	}
+}
+
+func (c *metaCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		c.version, c.instances, c.slaves, c.withoutMaster, c.ready,
+		c.watcherConnected, c.peerStale, c.peerVersion, c.peerUpdatedSeconds,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *metaCollector) Collect(ch chan<- prometheus.Metric) {
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+
+	for name, sw := range clusterWorkers {
+		var withoutMaster int
+		sw.RLock()
+		gauge(c.version, float64(sw.meta.Version), name)
+		gauge(c.instances, float64(len(sw.meta.Instances)), name)
+		for instName, inst := range sw.meta.Instances {
+			gauge(c.slaves, float64(len(inst.Slaves)), name, instName)
+			if inst.Master == nil {
+				withoutMaster++
+			}
+		}
+		gauge(c.withoutMaster, float64(withoutMaster), name)
+		gauge(c.ready, boolValue(sw.ready), name)
+		sw.RUnlock()
+
+		switchWatcher, sdownWatcher := sw.getWatchers()
+		gauge(c.watcherConnected, boolValue(switchWatcher.Connected()), name, SwitchMasterChannel)
+		gauge(c.watcherConnected, boolValue(sdownWatcher.Connected()), name, SdownChannel)
+	}
+
+	now := time.Now()
+	for name, p := range peerWatchers {
+		_, status := p.snapshot(now)
+		gauge(c.peerStale, boolValue(status.Stale), name)
+		gauge(c.peerVersion, float64(status.Version), name)
+		if !status.UpdatedAt.IsZero() {
+			gauge(c.peerUpdatedSeconds, float64(status.UpdatedAt.UnixNano())/1e9, name)
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// observeStoreMeta records a store of the meta of @cluster started at @start, which fails if *@err is not nil
+func observeStoreMeta(cluster string, start time.Time, err *error) {
+	storeMetaDuration.WithLabelValues(cluster).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		storeMetaFailuresTotal.WithLabelValues(cluster).Inc()
+	}
+}
+
+// observeSentinelCall records a sentinel call @op started at @start, which fails if *@err is not nil
+func observeSentinelCall(op string, start time.Time, err *error) {
+	sentinelCallDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		sentinelCallErrorsTotal.WithLabelValues(op).Inc()
+	}
+}
+
+// sentinelOp returns the operation of command @cmd, such as "sentinel set"
+func sentinelOp(cmd string, args []interface{}) string {
+	op := strings.ToLower(cmd)
+	if op == "sentinel" && len(args) != 0 {
+		op += " " + strings.ToLower(fmt.Sprint(args[0]))
+	}
+	return op
+}
+
+func (c measuredConn) Do(cmd string, args ...interface{}) (reply interface{}, err error) {
+	defer observeSentinelCall(sentinelOp(cmd, args), time.Now(), &err)
+	return c.Conn.Do(cmd, args...)
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+// Flush keeps the meta stream working through the recorder
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// MetricsMiddleware counts the requests by the route of @mux that serves them, so
+// the requests rejected by the outer middlewares are counted too.
+func MetricsMiddleware(mux *http.ServeMux, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		httpRequestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
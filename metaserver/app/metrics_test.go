@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_sentinelOp(t *testing.T) {
+	cases := []struct {
+		cmd  string
+		args []interface{}
+		op   string
+	}{
+		{"SENTINEL", []interface{}{"SET", "cache1", "quorum", 2}, "sentinel set"},
+		{"sentinel", []interface{}{"failover", "cache1"}, "sentinel failover"},
+		{"sentinel", nil, "sentinel"},
+		{"ROLE", nil, "role"},
+	}
+	for _, c := range cases {
+		if op := sentinelOp(c.cmd, c.args); op != c.op {
+			t.Errorf("sentinelOp(%s, %v) = %s, want %s", c.cmd, c.args, op, c.op)
+		}
+	}
+}
+
+func Test_observeStoreMeta(t *testing.T) {
+	var err error
+	failures := testutil.ToFloat64(storeMetaFailuresTotal.WithLabelValues("metrics-test"))
+	observeStoreMeta("metrics-test", time.Now(), &err)
+	err = errors.New("can not find meta db")
+	observeStoreMeta("metrics-test", time.Now(), &err)
+
+	if n := testutil.ToFloat64(storeMetaFailuresTotal.WithLabelValues("metrics-test")); n != failures+1 {
+		t.Errorf("store_meta_failures_total = %v, want %v", n, failures+1)
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/instances/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Errorf("ResponseWriter is not a http.Flusher")
+		}
+		writeResponse(w, EC_NOT_FOUND, r.URL.Path)
+	})
+	handler := MetricsMiddleware(mux, mux)
+
+	counter := httpRequestsTotal.WithLabelValues("/v1/instances/", "GET", "404")
+	count := testutil.ToFloat64(counter)
+	for _, path := range []string{"/v1/instances/cache1", "/v1/instances/cache2"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if n := testutil.ToFloat64(counter); n != count+2 {
+		t.Errorf("http_requests_total = %v, want %v", n, count+2)
+	}
+
+	unmatched := httpRequestsTotal.WithLabelValues("unmatched", "GET", "404")
+	count = testutil.ToFloat64(unmatched)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", nil))
+	if n := testutil.ToFloat64(unmatched); n != count+1 {
+		t.Errorf("http_requests_total of unmatched route = %v, want %v", n, count+1)
+	}
+}
+
+func Test_metaCollector(t *testing.T) {
+	sw := &SentinelWorker{
+		cluster: DefaultCluster,
+		meta: ClusterMeta{
+			Version: 9,
+			Instances: map[string]*gxredis.Instance{
+				"cache1": {
+					Name:   "cache1",
+					Master: &gxredis.IPAddr{IP: "192.168.11.100", Port: 6379},
+					Slaves: []*gxredis.Slave{
+						{Addr: &gxredis.IPAddr{IP: "192.168.11.101", Port: 6379}},
+						{Addr: &gxredis.IPAddr{IP: "192.168.11.102", Port: 6379}},
+					},
+				},
+				"cache2": {Name: "cache2"},
+			},
+		},
+	}
+	oldWorkers, oldPeers := clusterWorkers, peerWatchers
+	clusterWorkers = map[string]*SentinelWorker{DefaultCluster: sw}
+	peerWatchers = nil
+	defer func() {
+		clusterWorkers, peerWatchers = oldWorkers, oldPeers
+	}()
+
+	expected := `
+# HELP metaserver_instance_slaves Slaves of an instance in the meta.
+# TYPE metaserver_instance_slaves gauge
+metaserver_instance_slaves{cluster="default",instance="cache1"} 2
+metaserver_instance_slaves{cluster="default",instance="cache2"} 0
+# HELP metaserver_instances Instances in the meta.
+# TYPE metaserver_instances gauge
+metaserver_instances{cluster="default"} 2
+# HELP metaserver_instances_without_master Instances without a master in the meta.
+# TYPE metaserver_instances_without_master gauge
+metaserver_instances_without_master{cluster="default"} 1
+# HELP metaserver_meta_version Current meta version.
+# TYPE metaserver_meta_version gauge
+metaserver_meta_version{cluster="default"} 9
+# HELP metaserver_watcher_connected 1 if the watcher subscribes to a sentinel.
+# TYPE metaserver_watcher_connected gauge
+metaserver_watcher_connected{channel="+sdown",cluster="default"} 0
+metaserver_watcher_connected{channel="+switch-master",cluster="default"} 0
+`
+	err := testutil.CollectAndCompare(newMetaCollector(), strings.NewReader(expected),
+		"metaserver_instance_slaves", "metaserver_instances", "metaserver_instances_without_master",
+		"metaserver_meta_version", "metaserver_watcher_connected")
+	if err != nil {
+		t.Errorf("CollectAndCompare() = error:%v", err)
+	}
+}
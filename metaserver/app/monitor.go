@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+import (
+	"github.com/garyburd/redigo/redis"
+)
+
+// the monitor params of an instance that can be changed by SENTINEL SET
+var monitorParamNames = []string{
+	"quorum",
+	"down-after-milliseconds",
+	"failover-timeout",
+	"parallel-syncs",
+}
+
+type (
+	// SentinelMonitorParams is the monitor params of an instance on one sentinel
+	SentinelMonitorParams struct {
+		Sentinel string           `json:"sentinel"`
+		Params   map[string]int64 `json:"params,omitempty"`
+		Error    string           `json:"error,omitempty"`
+	}
+
+	// MonitorParamsState is the monitor params of an instance on all sentinels
+	MonitorParamsState struct {
+		Name       string                  `json:"name"`
+		Consistent bool                    `json:"consistent"`
+		Sentinels  []SentinelMonitorParams `json:"sentinels"`
+	}
+)
+
+// checkMonitorParams checks the names and values of @params
+func checkMonitorParams(params map[string]int64) error {
+	if len(params) == 0 {
+		return fmt.Errorf("nothing to update")
+	}
+	for key, value := range params {
+		found := false
+		for _, name := range monitorParamNames {
+			if key == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown monitor param %q", key)
+		}
+		if value <= 0 {
+			return fmt.Errorf("%s %d is not positive", key, value)
+		}
+	}
+
+	return nil
+}
+
+// getSentinelMonitorParams returns the monitor params of instance @name on sentinel @addr
+func getSentinelMonitorParams(addr string, name string) (map[string]int64, error) {
+	conn, err := dialSentinel(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	master, err := redis.StringMap(conn.Do("sentinel", "master", name))
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[string]int64, len(monitorParamNames))
+	for _, key := range monitorParamNames {
+		value, ok := master[key]
+		if !ok {
+			continue
+		}
+		if params[key], err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("illegal %s %q", key, value)
+		}
+	}
+
+	return params, nil
+}
+
+// getMonitorParamsState returns the monitor params of instance @name on every sentinel.
+// They are consistent if all sentinels are reachable and have the same params.
+func (w *SentinelWorker) getMonitorParamsState(name string) MonitorParamsState {
+	state := MonitorParamsState{Name: name, Consistent: true}
+	for _, addr := range w.getSentinels() {
+		params, err := getSentinelMonitorParams(addr, name)
+		item := SentinelMonitorParams{Sentinel: addr, Params: params}
+		if err != nil {
+			item.Error = err.Error()
+			state.Consistent = false
+		} else if len(state.Sentinels) != 0 && !reflect.DeepEqual(state.Sentinels[0].Params, params) {
+			state.Consistent = false
+		}
+		state.Sentinels = append(state.Sentinels, item)
+	}
+
+	return state
+}
+
+// setMonitorParams applies @params of instance @name to all sentinels, and then
+// verifies that all sentinels have taken them.
+func (w *SentinelWorker) setMonitorParams(name string, params map[string]int64) (MonitorParamsState, error) {
+	if err := checkMonitorParams(params); err != nil {
+		return MonitorParamsState{Name: name}, err
+	}
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	args := make([]interface{}, 0, 2*len(keys))
+	for _, key := range keys {
+		args = append(args, key, params[key])
+	}
+	setErr := w.sentinelSet(name, args...)
+
+	state := w.getMonitorParamsState(name)
+	if setErr != nil {
+		return state, setErr
+	}
+	for _, item := range state.Sentinels {
+		for key, value := range params {
+			if item.Error == "" && item.Params[key] != value {
+				state.Consistent = false
+				return state, fmt.Errorf("%s of %s on sentinel %s is %d after set to %d",
+					key, name, item.Sentinel, item.Params[key], value)
+			}
+		}
+	}
+	if !state.Consistent {
+		return state, fmt.Errorf("monitor params of %s on sentinels are inconsistent", name)
+	}
+
+	return state, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_checkMonitorParams(t *testing.T) {
+	if err := checkMonitorParams(map[string]int64{"quorum": 2, "parallel-syncs": 1}); err != nil {
+		t.Errorf("checkMonitorParams() = error:%#v", err)
+	}
+	for _, params := range []map[string]int64{
+		nil,
+		{"quorum": 0},
+		{"down-after-milliseconds": -1},
+		{"auth-pass": 1},
+	} {
+		if err := checkMonitorParams(params); err == nil {
+			t.Errorf("checkMonitorParams(%v) should fail", params)
+		}
+	}
+}
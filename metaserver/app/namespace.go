@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+import (
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	// DefaultNamespace is the namespace of the instances without one
+	DefaultNamespace   = "default"
+	MaxNamespaceLength = 63
+)
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+type (
+	// namespaceState is the version of a namespace. The version is increased
+	// whenever the instances of the namespace change.
+	namespaceState struct {
+		version int32
+		digest  string // JSON of the instances of the version
+		stored  bool   // whether the version has been stored in the meta db
+	}
+
+	// NamespaceInfo is the summary of a namespace
+	NamespaceInfo struct {
+		Name      string `json:"name"`
+		Version   int32  `json:"version"`
+		Hashtable string `json:"hashtable"`
+		Instances int    `json:"instances"`
+	}
+)
+
+func checkNamespaceName(ns string) error {
+	if len(ns) == 0 || MaxNamespaceLength < len(ns) || !namespacePattern.MatchString(ns) {
+		return fmt.Errorf("illegal namespace %q", ns)
+	}
+	return nil
+}
+
+// allNamespaces returns the default namespace and the namespaces in the config
+func allNamespaces() []string {
+	return append([]string{DefaultNamespace}, getConf().Redis.Namespaces...)
+}
+
+// checkNamespace returns error if @ns is not in the config
+func checkNamespace(ns string) error {
+	for _, name := range allNamespaces() {
+		if name == ns {
+			return nil
+		}
+	}
+	return fmt.Errorf("namespace %s not found", ns)
+}
+
+// namespaceHashtable returns the key of the meta hashtable of namespace @ns in the meta db of @conf
+func namespaceHashtable(conf SectionRedis, ns string) string {
+	return conf.MetaHashtable + ":" + ns
+}
+
+// namespace returns the namespace of the instance
+func (a InstanceAttrs) namespace() string {
+	if a.Namespace == "" {
+		return DefaultNamespace
+	}
+	return a.Namespace
+}
+
+// Namespace returns the instances of @v in namespace @ns. The version is not changed.
+func (v MetaView) Namespace(ns string) MetaView {
+	view := MetaView{Version: v.Version, Instances: make(map[string]MetaInstance)}
+	for name, inst := range v.Instances {
+		if inst.namespace() == ns {
+			view.Instances[name] = inst
+		}
+	}
+
+	return view
+}
+
+// syncNamespace increases the version of namespace @ns if @instances differ from the
+// ones of its current version. The caller should hold nsLock.
+func (w *SentinelWorker) syncNamespace(ns string, instances map[string]MetaInstance) namespaceState {
+	state := w.nsStates[ns]
+	data, err := json.Marshal(instances)
+	if err != nil {
+		return state
+	}
+	if state.digest != string(data) {
+		state.version++
+		state.digest = string(data)
+		state.stored = false
+		w.nsStates[ns] = state
+	}
+
+	return state
+}
+
+// namespaceView returns the meta of namespace @ns with its own version.
+// The caller should hold the read lock.
+func (w *SentinelWorker) namespaceView(ns string) MetaView {
+	view := w.metaView().Namespace(ns)
+
+	w.nsLock.Lock()
+	view.Version = w.syncNamespace(ns, view.Instances).version
+	w.nsLock.Unlock()
+
+	return view
+}
+
+// namespaceInfos returns the summary of the namespaces accepted by @filter.
+// The caller should hold the read lock.
+func (w *SentinelWorker) namespaceInfos(filter func(string) bool) []NamespaceInfo {
+	view := w.metaView()
+	namespaces := allNamespaces()
+	infos := make([]NamespaceInfo, 0, len(namespaces))
+
+	w.nsLock.Lock()
+	defer w.nsLock.Unlock()
+	for _, ns := range namespaces {
+		if !filter(ns) {
+			continue
+		}
+		instances := view.Namespace(ns).Instances
+		infos = append(infos, NamespaceInfo{
+			Name:      ns,
+			Version:   w.syncNamespace(ns, instances).version,
+			Hashtable: namespaceHashtable(w.conf(), ns),
+			Instances: len(instances),
+		})
+	}
+
+	return infos
+}
+
+// namespaceVersions returns the versions of all namespaces
+func (w *SentinelWorker) namespaceVersions() map[string]int32 {
+	w.nsLock.Lock()
+	defer w.nsLock.Unlock()
+
+	versions := make(map[string]int32, len(w.nsStates))
+	for ns, state := range w.nsStates {
+		versions[ns] = state.version
+	}
+	return versions
+}
+
+// setNamespaceVersions sets the versions of namespaces loaded from the meta cache.
+// They will be stored again with the next version.
+func (w *SentinelWorker) setNamespaceVersions(versions map[string]int32) {
+	w.nsLock.Lock()
+	defer w.nsLock.Unlock()
+
+	for ns, version := range versions {
+		w.nsStates[ns] = namespaceState{version: version}
+	}
+}
+
+// loadNamespaces loads the version of every namespace from its meta hashtable
+func (w *SentinelWorker) loadNamespaces(conn redis.Conn) error {
+	conf := w.conf()
+	namespaces := allNamespaces()
+	states := make(map[string]namespaceState, len(namespaces))
+	for _, ns := range namespaces {
+		res, err := conn.Do("hgetall", namespaceHashtable(conf, ns))
+		if err != nil {
+			return err
+		}
+		view, err := parseMetaHashtable(conf, res)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(view.Instances)
+		if err != nil {
+			return err
+		}
+		states[ns] = namespaceState{version: view.Version, digest: string(data), stored: true}
+	}
+
+	w.nsLock.Lock()
+	w.nsStates = states
+	w.nsLock.Unlock()
+
+	return nil
+}
+
+// storeNamespaces stores the namespaces of @view whose versions have not been stored.
+// It goes on if some namespaces fail, and returns their errors.
+func (w *SentinelWorker) storeNamespaces(conn redis.Conn, view MetaView) error {
+	var (
+		errs []string
+		conf = w.conf()
+	)
+
+	w.nsLock.Lock()
+	defer w.nsLock.Unlock()
+	for _, ns := range allNamespaces() {
+		instances := view.Namespace(ns).Instances
+		state := w.syncNamespace(ns, instances)
+		if state.stored {
+			continue
+		}
+		if err := writeMetaHashtable(conf, conn, namespaceHashtable(conf, ns), state.version, instances); err != nil {
+			errs = append(errs, fmt.Sprintf("namespace %s: %v", ns, err))
+			continue
+		}
+		state.stored = true
+		w.nsStates[ns] = state
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// setNewInstanceNamespace puts instance @name, which has just been added to the
+// sentinels, into namespace @ns before it appears in the meta.
+func (w *SentinelWorker) setNewInstanceNamespace(name string, ns string) {
+	if ns == "" || ns == DefaultNamespace {
+		return
+	}
+
+	w.Lock()
+	attrs := w.attrs[name]
+	attrs.Namespace = ns
+	w.attrs[name] = attrs
+	w.Unlock()
+}
+
+// setInstanceNamespace moves instance @name into namespace @ns
+func (w *SentinelWorker) setInstanceNamespace(name string, ns string) error {
+	if err := checkNamespace(ns); err != nil {
+		return err
+	}
+
+	old := w.getInstanceAttrs(name).namespace()
+	changed, err := w.updateInstanceAttrs(name, func(attrs *InstanceAttrs) {
+		attrs.Namespace = ns
+	})
+	if changed {
+		Log.Info("instance %s moves from namespace %s to %s", name, old, ns)
+	}
+
+	return err
+}
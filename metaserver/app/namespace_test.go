@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+func TestSentinelWorker_namespaceView(t *testing.T) {
+	w := &SentinelWorker{
+		meta: ClusterMeta{
+			Version: 7,
+			Instances: map[string]*gxredis.Instance{
+				"cache1": {Name: "cache1"},
+				"cache2": {Name: "cache2"},
+				"cache3": {Name: "cache3"},
+			},
+		},
+		attrs: map[string]InstanceAttrs{
+			"cache2": {Namespace: "product-a"},
+			"cache3": {Namespace: "product-a", State: InstanceDraining},
+		},
+		nsStates: make(map[string]namespaceState),
+	}
+
+	view := w.namespaceView(DefaultNamespace)
+	if view.Version != 1 || len(view.Instances) != 1 || view.Instances["cache1"].Name != "cache1" {
+		t.Errorf("namespaceView(%s) = %+v", DefaultNamespace, view)
+	}
+	view = w.namespaceView("product-a")
+	if view.Version != 1 || len(view.Instances) != 2 {
+		t.Errorf("namespaceView(product-a) = %+v", view)
+	}
+
+	// the version of a namespace is increased only if its instances change
+	w.meta.Instances["cache3"] = &gxredis.Instance{Name: "cache3", Master: &gxredis.IPAddr{IP: "127.0.0.1", Port: 4000}}
+	w.meta.Version++
+	if view = w.namespaceView(DefaultNamespace); view.Version != 1 {
+		t.Errorf("version of namespace %s = %d", DefaultNamespace, view.Version)
+	}
+	if view = w.namespaceView("product-a"); view.Version != 2 {
+		t.Errorf("version of namespace product-a = %d", view.Version)
+	}
+	if view = w.namespaceView("product-a"); view.Version != 2 || w.nsStates["product-a"].stored {
+		t.Errorf("namespace product-a = {version:%d, state:%+v}", view.Version, w.nsStates["product-a"])
+	}
+}
+
+func Test_checkNamespaceName(t *testing.T) {
+	for _, ns := range []string{"default", "product-a", "a1"} {
+		if err := checkNamespaceName(ns); err != nil {
+			t.Errorf("checkNamespaceName(%s) = error:%#v", ns, err)
+		}
+	}
+	for _, ns := range []string{"", "Product", "-a", "a-", "a_b", "a/b", "a:b"} {
+		if err := checkNamespaceName(ns); err == nil {
+			t.Errorf("checkNamespaceName(%q) should fail", ns)
+		}
+	}
+}
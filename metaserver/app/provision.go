@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	ReplicaSyncTimeout    = 300e9  // 300s
+	MaxReplicaSyncTimeout = 3600e9 // 3600s
+	ReplicaCheckInterval  = 1e9    // 1s
+
+	masterLinkUp = "up"
+)
+
+type (
+	// AddInstanceRequest is a RawInstance and the replicas attached to it before
+	// the sentinels monitor it.
+	AddInstanceRequest struct {
+		Inst     gxredis.RawInstance
+		Replicas []string // "ip:port"
+		// max time to wait for the replicas to finish syncing
+		SyncTimeout time.Duration
+		// namespace of the instance. empty means DefaultNamespace.
+		Namespace string
+		// attach the replicas even if they are not empty masters without slaves
+		Force bool
+	}
+)
+
+var (
+	// ErrUnsafeReplica means a replica may hold data, which REPLICAOF would wipe
+	ErrUnsafeReplica = fmt.Errorf("unsafe replica")
+)
+
+// parseMasterLinkStatus returns master_link_status of "INFO replication" of a slave
+func parseMasterLinkStatus(info string) string {
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "master_link_status:") {
+			return strings.TrimPrefix(line, "master_link_status:")
+		}
+	}
+
+	return ""
+}
+
+// replicaOf runs "REPLICAOF @host @port", or "SLAVEOF" on redis before 5.0
+func replicaOf(conn redis.Conn, host string, port string) error {
+	_, err := conn.Do("replicaof", host, port)
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "unknown command") {
+		_, err = conn.Do("slaveof", host, port)
+	}
+
+	return err
+}
+
+// parseRoleReply returns the role and the number of connected slaves in the reply of "ROLE"
+func parseRoleReply(values []interface{}) (string, int, error) {
+	if len(values) == 0 {
+		return "", 0, fmt.Errorf("illegal role reply")
+	}
+	role, err := redis.String(values[0], nil)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "role")
+	}
+	if role != "master" {
+		return role, 0, nil
+	}
+	// master, offset, [[ip, port, offset]...]
+	if len(values) < 3 {
+		return "", 0, fmt.Errorf("illegal role reply of master")
+	}
+	slaves, err := redis.Values(values[2], nil)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "slaves of role")
+	}
+
+	return role, len(slaves), nil
+}
+
+// findNode returns the instance and cluster whose master or slave in the meta is @addr
+func findNode(addr string) (string, string, bool) {
+	for cluster, sw := range clusterWorkers {
+		addrs := []string{addr}
+		if translator := sw.getAddrTranslator(); translator != nil {
+			addrs = append(addrs, translator.Translate(addr))
+		}
+
+		sw.RLock()
+		for name, inst := range sw.meta.Instances {
+			nodes := make([]*gxredis.IPAddr, 0, len(inst.Slaves)+1)
+			nodes = append(nodes, inst.Master)
+			for _, slave := range inst.Slaves {
+				nodes = append(nodes, slave.Addr)
+			}
+			for _, node := range nodes {
+				if node == nil {
+					continue
+				}
+				for _, a := range addrs {
+					if node.TcpAddr().String() == a {
+						sw.RUnlock()
+						return name, cluster, true
+					}
+				}
+			}
+		}
+		sw.RUnlock()
+	}
+
+	return "", "", false
+}
+
+// checkReplicaTarget checks that @replica of instance @name is an empty master without slaves
+func checkReplicaTarget(name string, replica string) error {
+	conn, err := dialInstance(name, replica)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	values, err := redis.Values(conn.Do("role"))
+	if err != nil {
+		return errors.Wrapf(err, "role of %s", replica)
+	}
+	role, slaves, err := parseRoleReply(values)
+	if err != nil {
+		return errors.Wrapf(err, "role of %s", replica)
+	}
+	if role != "master" {
+		return errors.Wrapf(ErrUnsafeReplica, "role of replica %s is %s, not master", replica, role)
+	}
+	if slaves != 0 {
+		return errors.Wrapf(ErrUnsafeReplica, "replica %s has %d slaves", replica, slaves)
+	}
+	size, err := redis.Int64(conn.Do("dbsize"))
+	if err != nil {
+		return errors.Wrapf(err, "dbsize of %s", replica)
+	}
+	if size != 0 {
+		return errors.Wrapf(ErrUnsafeReplica, "replica %s has %d keys", replica, size)
+	}
+
+	return nil
+}
+
+// checkReplicas rejects the replicas of @req that are nodes of any instance in the meta
+// of any cluster. Unless forced, every replica must be an empty master without slaves,
+// because REPLICAOF wipes its dataset.
+func checkReplicas(req AddInstanceRequest) error {
+	for _, replica := range req.Replicas {
+		if name, cluster, ok := findNode(replica); ok {
+			return errors.Wrapf(ErrUnsafeReplica, "replica %s is a node of instance %s of cluster %s", replica, name, cluster)
+		}
+	}
+	if req.Force {
+		return nil
+	}
+	for _, replica := range req.Replicas {
+		if err := checkReplicaTarget(req.Inst.Name, replica); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// provisionErrorCode returns the error code of @err returned by provisionInstance
+func provisionErrorCode(err error) ErrorCode {
+	if errors.Cause(err) == ErrUnsafeReplica {
+		return EC_ILLEGAL_PARAM
+	}
+	return EC_SYS_ERROR
+}
+
+// attachReplica makes @replica a slave of the master of @inst. The replica uses
+// the instance auth to connect to the master.
+func attachReplica(inst gxredis.RawInstance, replica string) error {
+	conn, err := dialInstance(inst.Name, replica)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	auth := getInstanceAuth(inst.Name)
+	password, err := auth.GetPassword()
+	if err != nil {
+		return errors.Wrapf(err, "GetPassword()")
+	}
+	if password != "" {
+		if auth.Username != "" {
+			if _, err = conn.Do("config", "set", "masteruser", auth.Username); err != nil {
+				return errors.Wrapf(err, "config set masteruser of %s", replica)
+			}
+		}
+		if _, err = conn.Do("config", "set", "masterauth", password); err != nil {
+			return errors.Wrapf(err, "config set masterauth of %s", replica)
+		}
+	}
+	if err = replicaOf(conn, inst.Addr.IP, strconv.Itoa(int(inst.Addr.Port))); err != nil {
+		return errors.Wrapf(err, "replicaof of %s", replica)
+	}
+
+	return nil
+}
+
+// detachReplica turns @replica into a master again
+func detachReplica(name string, replica string) {
+	conn, err := dialInstance(name, replica)
+	if err == nil {
+		err = replicaOf(conn, "no", "one")
+		conn.Close()
+	}
+	if err != nil {
+		Log.Error("failed to detach replica %s of instance %s, error:%#v", replica, name, err)
+	}
+}
+
+// waitReplicaSync waits until master_link_status of @replica is up
+func waitReplicaSync(name string, replica string, deadline time.Time) error {
+	var status string
+	for {
+		conn, err := dialInstance(name, replica)
+		if err == nil {
+			var info string
+			info, err = redis.String(conn.Do("info", "replication"))
+			conn.Close()
+			if err == nil {
+				if status = parseMasterLinkStatus(info); status == masterLinkUp {
+					return nil
+				}
+			}
+		}
+		if err != nil {
+			Log.Warn("failed to get replication info of %s, error:%#v", replica, err)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("master_link_status of %s is %q at the deadline", replica, status)
+		}
+		time.Sleep(time.Duration(ReplicaCheckInterval))
+	}
+}
+
+// provisionInstance checks the replicas, attaches them to the master of the instance,
+// waits for them to finish syncing, and then lets the sentinels monitor the instance.
+// The replicas are detached if any step fails. The instance is added to the meta with
+// the replicas as its slaves.
+func (w *SentinelWorker) provisionInstance(req AddInstanceRequest) error {
+	if len(req.Replicas) == 0 {
+		if err := w.addInstance(req.Inst); err != nil {
+			return err
+		}
+		w.setNewInstanceNamespace(req.Inst.Name, req.Namespace)
+		return nil
+	}
+
+	var (
+		err      error
+		attached []string
+	)
+	if err = checkReplicas(req); err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, replica := range attached {
+			detachReplica(req.Inst.Name, replica)
+		}
+	}()
+
+	master := req.Inst.Addr.TcpAddr().String()
+	for _, replica := range req.Replicas {
+		if err = attachReplica(req.Inst, replica); err != nil {
+			return err
+		}
+		attached = append(attached, replica)
+		Log.Info("replica %s of instance %s starts to sync with master %s", replica, req.Inst.Name, master)
+	}
+	deadline := time.Now().Add(req.SyncTimeout)
+	for _, replica := range req.Replicas {
+		if err = waitReplicaSync(req.Inst.Name, replica, deadline); err != nil {
+			return err
+		}
+	}
+	if err = w.addInstance(req.Inst); err != nil {
+		return err
+	}
+	w.setNewInstanceNamespace(req.Inst.Name, req.Namespace)
+
+	inst := &gxredis.Instance{Name: req.Inst.Name, Master: &gxredis.IPAddr{IP: req.Inst.Addr.IP, Port: req.Inst.Addr.Port}}
+	for _, replica := range req.Replicas {
+		host, portStr, _ := net.SplitHostPort(replica)
+		port, _ := strconv.Atoi(portStr)
+		inst.Slaves = append(inst.Slaves, &gxredis.Slave{Addr: &gxredis.IPAddr{IP: host, Port: int32(port)}})
+	}
+	w.getAddrTranslator().TranslateInstance(inst)
+	w.Lock()
+	w.meta.Instances[inst.Name] = inst
+	w.meta.Version++
+	w.Unlock()
+	if storeErr := w.storeClusterMetaData(); storeErr != nil {
+		Log.Error("storeClusterMetaData() = error:%#v", storeErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+	"github.com/pkg/errors"
+)
+
+func Test_parseRoleReply(t *testing.T) {
+	cases := []struct {
+		reply  []interface{}
+		role   string
+		slaves int
+		err    bool
+	}{
+		{[]interface{}{[]byte("master"), int64(0), []interface{}{}}, "master", 0, false},
+		{[]interface{}{[]byte("master"), int64(3168), []interface{}{
+			[]interface{}{[]byte("192.168.11.101"), []byte("4001"), []byte("3168")},
+		}}, "master", 1, false},
+		{[]interface{}{[]byte("slave"), []byte("192.168.11.100"), int64(4001), []byte("connected"), int64(3168)}, "slave", 0, false},
+		{[]interface{}{[]byte("master")}, "", 0, true},
+		{nil, "", 0, true},
+	}
+	for i, c := range cases {
+		role, slaves, err := parseRoleReply(c.reply)
+		if role != c.role || slaves != c.slaves || (err != nil) != c.err {
+			t.Errorf("case %d: parseRoleReply() = {%s, %d, %v}", i, role, slaves, err)
+		}
+	}
+}
+
+func Test_checkReplicas(t *testing.T) {
+	emptyMaster := map[string]interface{}{"role": []interface{}{"master", 0, []interface{}{}}, "dbsize": 0}
+	nodes := map[string]*redisStandIn{
+		"empty": newRedisStandIn(t, "", emptyMaster),
+		"data":  newRedisStandIn(t, "", map[string]interface{}{"role": []interface{}{"master", 0, []interface{}{}}, "dbsize": 5}),
+		"slave": newRedisStandIn(t, "", map[string]interface{}{
+			"role": []interface{}{"slave", "192.168.11.100", 4001, "connected", 3168},
+		}),
+		"master": newRedisStandIn(t, "", map[string]interface{}{
+			"role": []interface{}{"master", 3168, []interface{}{[]string{"192.168.11.101", "4001", "3168"}}},
+		}),
+		"meta": newRedisStandIn(t, "", emptyMaster),
+	}
+	for _, node := range nodes {
+		defer node.Close()
+	}
+
+	translator, _ := NewAddrTranslator(nil, nil)
+	metaAddr := nodes["meta"].listener.Addr().(*net.TCPAddr)
+	slaveAddr := &gxredis.IPAddr{IP: metaAddr.IP.String(), Port: int32(metaAddr.Port)}
+	sw := &SentinelWorker{
+		cluster:    "dc2",
+		translator: translator,
+		meta: ClusterMeta{Instances: map[string]*gxredis.Instance{
+			"cache1": {
+				Name:   "cache1",
+				Master: &gxredis.IPAddr{IP: "192.168.11.100", Port: 4001},
+				Slaves: []*gxredis.Slave{{Addr: slaveAddr}},
+			},
+		}},
+	}
+	oldWorkers := clusterWorkers
+	clusterWorkers = map[string]*SentinelWorker{"dc2": sw}
+	defer func() {
+		clusterWorkers = oldWorkers
+	}()
+
+	cases := []struct {
+		replica string
+		force   bool
+		unsafe  bool
+	}{
+		{"empty", false, false},
+		{"data", false, true},
+		{"data", true, false},
+		{"slave", false, true},
+		{"master", false, true},
+		{"meta", false, true},
+		{"meta", true, true}, // a node in the meta can not be forced
+	}
+	for _, c := range cases {
+		req := AddInstanceRequest{
+			Inst:     gxredis.RawInstance{Name: "cache2", Addr: &gxredis.IPAddr{IP: "192.168.11.102", Port: 4001}},
+			Replicas: []string{nodes[c.replica].Addr()},
+			Force:    c.force,
+		}
+		if !c.unsafe {
+			if err := checkReplicas(req); err != nil {
+				t.Errorf("checkReplicas() of replica %s{force:%v} = error:%v", c.replica, c.force, err)
+			}
+			continue
+		}
+		// rejected before anything is changed
+		err := sw.provisionInstance(req)
+		if errors.Cause(err) != ErrUnsafeReplica || provisionErrorCode(err) != EC_ILLEGAL_PARAM {
+			t.Errorf("provisionInstance() of replica %s{force:%v} = error:%v, want ErrUnsafeReplica", c.replica, c.force, err)
+		}
+	}
+
+	for name, node := range nodes {
+		for _, cmd := range node.Commands() {
+			if strings.HasPrefix(cmd, "replicaof") || strings.HasPrefix(cmd, "slaveof") {
+				t.Errorf("%s is sent to replica %s", cmd, name)
+			}
+		}
+	}
+}
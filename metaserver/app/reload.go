@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/pkg/errors"
+)
+
+type (
+	// ConfState is the state of the running config
+	ConfState struct {
+		File            string    `json:"file"`
+		Version         int       `json:"version"` // increased by every successful reload
+		MD5             string    `json:"md5"`
+		LoadTime        time.Time `json:"load_time"`
+		LastReloadTime  time.Time `json:"last_reload_time"`
+		LastReloadError string    `json:"last_reload_error"`
+	}
+
+	confStateHolder struct {
+		sync.RWMutex
+		state ConfState
+	}
+
+	// confHolder holds the running config. A config is never changed after it is
+	// set, so the readers use the snapshot returned by get without lock.
+	confHolder struct {
+		sync.RWMutex
+		conf *ConfYaml
+	}
+)
+
+var (
+	confState confStateHolder
+	// the running config
+	runningConf = confHolder{conf: &ConfYaml{}}
+)
+
+func (h *confHolder) set(conf ConfYaml) {
+	h.Lock()
+	defer h.Unlock()
+	h.conf = &conf
+}
+
+// update sets a copy of the running config changed by @change
+func (h *confHolder) update(change func(conf *ConfYaml)) {
+	h.Lock()
+	defer h.Unlock()
+	conf := *h.conf
+	change(&conf)
+	h.conf = &conf
+}
+
+func (h *confHolder) get() *ConfYaml {
+	h.RLock()
+	defer h.RUnlock()
+	return h.conf
+}
+
+// getConf returns the snapshot of the running config, which must not be changed.
+// A function reading several items gets it once to see them from the same config.
+func getConf() *ConfYaml {
+	return runningConf.get()
+}
+
+func fileMD5(file string) string {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	sum := md5.Sum(content)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *confStateHolder) init(file string) {
+	h.Lock()
+	defer h.Unlock()
+	h.state = ConfState{
+		File:     file,
+		Version:  1,
+		MD5:      fileMD5(file),
+		LoadTime: time.Now(),
+	}
+}
+
+func (h *confStateHolder) succeed() {
+	h.Lock()
+	defer h.Unlock()
+	h.state.Version++
+	h.state.MD5 = fileMD5(h.state.File)
+	h.state.LoadTime = time.Now()
+	h.state.LastReloadTime = h.state.LoadTime
+	h.state.LastReloadError = ""
+}
+
+func (h *confStateHolder) fail(err error) {
+	h.Lock()
+	defer h.Unlock()
+	h.state.LastReloadTime = time.Now()
+	h.state.LastReloadError = err.Error()
+}
+
+func (h *confStateHolder) get() ConfState {
+	h.RLock()
+	defer h.RUnlock()
+	return h.state
+}
+
+// checkRestartItems returns error if @conf changes any config item
+// that can not take effect without restarting metaserver.
+func checkRestartItems(conf ConfYaml) error {
+	var items []string
+
+	old := getConf()
+	if old.Core.BindAddr != conf.Core.BindAddr {
+		items = append(items, "core.bind_addr")
+	}
+	if old.Core.PID != conf.Core.PID {
+		items = append(items, "core.pid")
+	}
+	if old.Core.TLS != conf.Core.TLS {
+		items = append(items, "core.tls")
+	}
+	if old.Core.DebugBindAddr != conf.Core.DebugBindAddr {
+		items = append(items, "core.debug_bind_addr")
+	}
+	if !reflect.DeepEqual(old.Redis.Namespaces, conf.Redis.Namespaces) {
+		items = append(items, "redis.namespaces")
+	}
+	if !reflect.DeepEqual(old.Redis.ClusterNames(), conf.Redis.ClusterNames()) {
+		items = append(items, "names of redis.clusters")
+	}
+	if !reflect.DeepEqual(old.Federation, conf.Federation) {
+		items = append(items, "federation")
+	}
+	if len(items) != 0 {
+		return fmt.Errorf("changes of {%s} need restart", strings.Join(items, ", "))
+	}
+
+	return nil
+}
+
+// reloadLog reinitializes the logger with the log configure file. The old
+// logger is closed after no call uses it.
+func reloadLog() {
+	logger := newLogger(logConfFile)
+	logger.SetAsDefaultLogger()
+	Log.set(logger).Close()
+}
+
+// reload rereads the configure file and applies it. The sentinel client and
+// its watchers of a cluster are rebuilt if its sentinel list changes, all the
+// clusters are kept on their old sentinels if any new list fails, and the
+// webhook notifier is rebuilt if the webhook config changes. The whole reload
+// is rejected if any item that needs restart has been changed.
+func reload() error {
+	var (
+		err        error
+		conf       ConfYaml
+		translator *AddrTranslator
+		zones      *ZoneResolver
+		auth       *APIAuthenticator
+		notifier   *WebhookNotifier
+	)
+
+	defer func() {
+		if err != nil {
+			confState.fail(err)
+		}
+	}()
+
+	if conf, err = loadConf(confFile); err != nil {
+		err = errors.Wrapf(err, "loadConf(%s)", confFile)
+		return err
+	}
+	for _, item := range conf.DeprecatedItems() {
+		Log.Warn("config item %s is deprecated and ignored", item)
+	}
+	if err = checkRestartItems(conf); err != nil {
+		return err
+	}
+	if translator, err = NewAddrTranslator(conf.Redis.AddrMap, conf.Redis.AddrRules); err != nil {
+		return err
+	}
+	if zones, err = NewZoneResolver(conf.Redis.ZoneRules); err != nil {
+		return err
+	}
+	// the token file and the hmac key file are reread
+	if auth, err = NewAPIAuthenticator(conf.Core.APIAuth); err != nil {
+		return err
+	}
+	// the running notifier and its queued events are kept if the webhook config
+	// is unchanged
+	webhookChanged := !reflect.DeepEqual(getConf().Webhook, conf.Webhook)
+	if webhookChanged {
+		if notifier, err = NewWebhookNotifier(conf.Webhook); err != nil {
+			return err
+		}
+	}
+
+	var (
+		sentinelChanged []string
+		metaKeyChanged  []*SentinelWorker
+	)
+	// all the new sentinel lists are checked before any cluster is changed
+	for _, name := range conf.Redis.ClusterNames() {
+		oldConf, newConf := getConf().Redis.Cluster(name), conf.Redis.Cluster(name)
+		if !reflect.DeepEqual(oldConf.Sentinels, newConf.Sentinels) {
+			if _, err = newSentinelClient(func() []string { return newConf.Sentinels }).GetInstances(); err != nil {
+				err = errors.Wrapf(err, "sentinels %s of cluster %s", newConf.Sentinels, name)
+				return err
+			}
+			sentinelChanged = append(sentinelChanged, name)
+		}
+		if oldConf.MetaHashtable != newConf.MetaHashtable ||
+			oldConf.MetaVersion != newConf.MetaVersion ||
+			oldConf.MetaInstNameList != newConf.MetaInstNameList {
+			metaKeyChanged = append(metaKeyChanged, clusterWorkers[name])
+		}
+	}
+	for i, name := range sentinelChanged {
+		sentinels := conf.Redis.Cluster(name).Sentinels
+		if err = clusterWorkers[name].resetSentinel(sentinels); err != nil {
+			err = errors.Wrapf(err, "resetSentinel(%s) of cluster %s", sentinels, name)
+			// the clusters reset before go back to the running config
+			for _, name := range sentinelChanged[:i] {
+				sentinels := getConf().Redis.Cluster(name).Sentinels
+				if resetErr := clusterWorkers[name].resetSentinel(sentinels); resetErr != nil {
+					Log.Error("resetSentinel(%s) of cluster %s = error:%#v", sentinels, name, resetErr)
+				}
+			}
+			return err
+		}
+	}
+
+	runningConf.set(conf)
+	for _, sw := range clusterWorkers {
+		sw.Lock()
+		sw.translator = translator
+		sw.zones = zones
+		sw.Unlock()
+	}
+	apiAuth.set(auth)
+	if webhookChanged {
+		// the events queued in the old notifier become dead letters
+		notifier.start()
+		webhooks.set(notifier).Close()
+	}
+
+	reloadLog()
+
+	for _, sw := range metaKeyChanged {
+		if err := sw.storeClusterMetaData(); err != nil {
+			Log.Error("storeClusterMetaData() of cluster %s = error:%#v", sw.cluster, err)
+		}
+	}
+
+	confState.succeed()
+	Log.Info("reload config %s successfully, config:%+v", confFile, redactedConf(conf))
+
+	return nil
+}
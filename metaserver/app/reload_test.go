@@ -0,0 +1,250 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/AlexStocks/goext/log"
+)
+
+const testReloadConfYaml = `
+core:
+  mode: "dev"
+  bind_addr: :10080
+  fail_fast_timeout: 3
+
+redis:
+  sentinels:
+    - %s
+  meta_db_name: meta
+  update_interval: 90
+  meta_hashtable: meta_hashtable
+  meta_version: version
+  meta_instance_name_list: instance_name_list
+`
+
+func Test_checkRestartItems(t *testing.T) {
+	file := writeTestConf(t, testConfYaml)
+	defer os.RemoveAll(filepath.Dir(file))
+	base, err := loadConf(file)
+	if err != nil {
+		t.Fatalf("loadConf() = error:%#v", err)
+	}
+	oldConf := *getConf()
+	runningConf.set(base)
+	defer runningConf.set(oldConf)
+
+	cases := []struct {
+		name   string
+		change func(conf *ConfYaml)
+		items  []string
+	}{
+		{"nothing", func(conf *ConfYaml) {}, nil},
+		{"update interval", func(conf *ConfYaml) { conf.Redis.UpdateInterval = 30 }, nil},
+		{"sentinels", func(conf *ConfYaml) { conf.Redis.Sentinels = []string{"192.168.11.101:26380"} }, nil},
+		{"bind addr", func(conf *ConfYaml) { conf.Core.BindAddr = ":10081" }, []string{"core.bind_addr"}},
+		{"pid", func(conf *ConfYaml) { conf.Core.PID.Enabled = true }, []string{"core.pid"}},
+		{"tls", func(conf *ConfYaml) { conf.Core.TLS.Enabled = true }, []string{"core.tls"}},
+		{"debug bind addr", func(conf *ConfYaml) { conf.Core.DebugBindAddr = ":10090" }, []string{"core.debug_bind_addr"}},
+		{"namespaces", func(conf *ConfYaml) { conf.Redis.Namespaces = []string{"ns1"} }, []string{"redis.namespaces"}},
+		{"cluster names", func(conf *ConfYaml) {
+			conf.Redis.Clusters = []SectionCluster{{Name: "dc2", Sentinels: []string{"192.168.12.100:26380"}}}
+		}, []string{"names of redis.clusters"}},
+		{"federation", func(conf *ConfYaml) {
+			conf.Federation.Peers = []SectionPeer{{Name: "dc2", URL: "http://192.168.12.100:10080"}}
+		}, []string{"federation"}},
+		{"several items", func(conf *ConfYaml) {
+			conf.Core.BindAddr = ":10081"
+			conf.Core.PID.Enabled = true
+			conf.Redis.UpdateInterval = 30
+		}, []string{"core.bind_addr", "core.pid"}},
+	}
+	for _, c := range cases {
+		conf, _ := loadConf(file)
+		c.change(&conf)
+		err := checkRestartItems(conf)
+		if len(c.items) == 0 {
+			if err != nil {
+				t.Errorf("%s: checkRestartItems() = error:%v", c.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "{"+strings.Join(c.items, ", ")+"}") {
+			t.Errorf("%s: checkRestartItems() = error:%v, want items %v", c.name, err, c.items)
+		}
+	}
+}
+
+func Test_reload(t *testing.T) {
+	sentinel := newRedisStandIn(t, "", map[string]interface{}{"sentinel masters": []interface{}{}})
+	defer sentinel.Close()
+	base := strings.Replace(testReloadConfYaml, "%s", "127.0.0.1:1", 1)
+
+	oldConf, oldWorkers, oldLog, oldNewLogger, oldConfFile := *getConf(), clusterWorkers, Log, newLogger, confFile
+	defer func() {
+		runningConf.set(oldConf)
+		clusterWorkers, Log, newLogger, confFile = oldWorkers, oldLog, oldNewLogger, oldConfFile
+	}()
+	newLogger = func(string) gxlog.Logger { return &fakeLogger{} }
+	Log = newReloadableLogger(&fakeLogger{})
+
+	cases := []struct {
+		name string
+		yaml string
+		// the sentinels and update interval after the reload
+		sentinels []string
+		interval  int
+		err       string
+	}{
+		{"update interval", strings.Replace(base, "update_interval: 90", "update_interval: 30", 1),
+			[]string{"127.0.0.1:1"}, 30, ""},
+		{"sentinels", strings.Replace(base, "127.0.0.1:1", sentinel.Addr(), 1),
+			[]string{sentinel.Addr()}, 90, ""},
+		{"unreachable sentinels", strings.Replace(base, "127.0.0.1:1", "127.0.0.1:2", 1),
+			[]string{"127.0.0.1:1"}, 90, "sentinels [127.0.0.1:2] of cluster default"},
+		{"bind addr", strings.Replace(base, ":10080", ":10081", 1), []string{"127.0.0.1:1"}, 90, "core.bind_addr"},
+		{"namespaces", base + "  namespaces:\n    - ns1\n", []string{"127.0.0.1:1"}, 90, "redis.namespaces"},
+		{"cluster names", base + "  clusters:\n    - name: dc2\n      sentinels:\n        - 192.168.12.100:26380\n      meta_db_name: meta\n",
+			[]string{"127.0.0.1:1"}, 90, "names of redis.clusters"},
+		{"federation", base + "federation:\n  peers:\n    - name: dc2\n      url: http://192.168.12.100:10080\n",
+			[]string{"127.0.0.1:1"}, 90, "federation"},
+		{"unknown key", strings.Replace(base, "update_interval: 90", "update_interval: 30\n  updates: 30", 1),
+			[]string{"127.0.0.1:1"}, 90, "loadConf"},
+	}
+	for _, c := range cases {
+		file := writeTestConf(t, base)
+		conf, err := loadConf(file)
+		if err != nil {
+			t.Fatalf("loadConf() = error:%#v", err)
+		}
+		runningConf.set(conf)
+		confFile = file
+		confState.init(file)
+		sw := &SentinelWorker{cluster: DefaultCluster, sentinels: conf.Redis.Sentinels}
+		clusterWorkers = map[string]*SentinelWorker{DefaultCluster: sw}
+
+		if err = ioutil.WriteFile(file, []byte(c.yaml), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile() = error:%#v", err)
+		}
+		err = reload()
+		state := confState.get()
+		switch {
+		case c.err == "" && (err != nil || state.Version != 2):
+			t.Errorf("%s: reload() = error:%v, state:%+v", c.name, err, state)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err) ||
+			state.Version != 1 || state.LastReloadError == ""):
+			t.Errorf("%s: reload() = error:%v, state:%+v, want error %s", c.name, err, state, c.err)
+		}
+		if conf := getConf(); conf.Redis.UpdateInterval != c.interval || !reflect.DeepEqual(conf.Redis.Sentinels, c.sentinels) {
+			t.Errorf("%s: running config = %+v", c.name, conf.Redis)
+		}
+		if !reflect.DeepEqual(sw.getSentinels(), c.sentinels) {
+			t.Errorf("%s: sentinels of worker = %v, want %v", c.name, sw.getSentinels(), c.sentinels)
+		}
+		os.RemoveAll(filepath.Dir(file))
+	}
+}
+
+func Test_reloadWebhook(t *testing.T) {
+	base := strings.Replace(testReloadConfYaml, "%s", "127.0.0.1:1", 1)
+	file := writeTestConf(t, base)
+	defer os.RemoveAll(filepath.Dir(file))
+	conf, err := loadConf(file)
+	if err != nil {
+		t.Fatalf("loadConf() = error:%#v", err)
+	}
+
+	oldConf, oldWorkers, oldLog, oldNewLogger, oldConfFile := *getConf(), clusterWorkers, Log, newLogger, confFile
+	oldNotifier := webhooks.set(nil)
+	defer func() {
+		runningConf.set(oldConf)
+		clusterWorkers, Log, newLogger, confFile = oldWorkers, oldLog, oldNewLogger, oldConfFile
+		webhooks.set(oldNotifier).Close()
+	}()
+	newLogger = func(string) gxlog.Logger { return &fakeLogger{} }
+	Log = newReloadableLogger(&fakeLogger{})
+	runningConf.set(conf)
+	confFile = file
+	confState.init(file)
+	clusterWorkers = map[string]*SentinelWorker{DefaultCluster: {cluster: DefaultCluster, sentinels: conf.Redis.Sentinels}}
+	notifier, err := NewWebhookNotifier(conf.Webhook)
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier() = error:%#v", err)
+	}
+	webhooks.set(notifier)
+
+	cases := []struct {
+		yaml string
+		kept bool
+	}{
+		{strings.Replace(base, "update_interval: 90", "update_interval: 30", 1), true},
+		{base + "webhook:\n  endpoints:\n    - name: ops\n      url: http://127.0.0.1:1/hook\n", false},
+		{base + "webhook:\n  endpoints:\n    - name: ops\n      url: http://127.0.0.1:1/hook\n", true},
+	}
+	for i, c := range cases {
+		if err = ioutil.WriteFile(file, []byte(c.yaml), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile() = error:%#v", err)
+		}
+		if err = reload(); err != nil {
+			t.Fatalf("case %d: reload() = error:%#v", i, err)
+		}
+		webhooks.RLock()
+		current := webhooks.notifier
+		webhooks.RUnlock()
+		if kept := current == notifier; kept != c.kept {
+			t.Errorf("case %d: notifier kept = %v, want %v", i, kept, c.kept)
+		}
+		notifier = current
+	}
+}
+
+func Test_reloadSentinelsOfClusters(t *testing.T) {
+	sentinel := newRedisStandIn(t, "", map[string]interface{}{"sentinel masters": []interface{}{}})
+	defer sentinel.Close()
+	base := strings.Replace(testReloadConfYaml, "%s", "127.0.0.1:1", 1) +
+		"  clusters:\n    - name: dc2\n      sentinels:\n        - 127.0.0.1:3\n      meta_db_name: meta\n"
+
+	oldConf, oldWorkers, oldLog, oldConfFile := *getConf(), clusterWorkers, Log, confFile
+	defer func() {
+		runningConf.set(oldConf)
+		clusterWorkers, Log, confFile = oldWorkers, oldLog, oldConfFile
+	}()
+	Log = newReloadableLogger(&fakeLogger{})
+
+	file := writeTestConf(t, base)
+	defer os.RemoveAll(filepath.Dir(file))
+	conf, err := loadConf(file)
+	if err != nil {
+		t.Fatalf("loadConf() = error:%#v", err)
+	}
+	runningConf.set(conf)
+	confFile = file
+	confState.init(file)
+	clusterWorkers = map[string]*SentinelWorker{
+		DefaultCluster: {cluster: DefaultCluster, sentinels: []string{"127.0.0.1:1"}},
+		"dc2":          {cluster: "dc2", sentinels: []string{"127.0.0.1:3"}},
+	}
+
+	// the default cluster moves to a reachable sentinel, but dc2 does not
+	yaml := strings.Replace(strings.Replace(base, "127.0.0.1:3", "127.0.0.1:2", 1), "127.0.0.1:1", sentinel.Addr(), 1)
+	if err = ioutil.WriteFile(file, []byte(yaml), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile() = error:%#v", err)
+	}
+	if err = reload(); err == nil || !strings.Contains(err.Error(), "of cluster dc2") {
+		t.Errorf("reload() = error:%v, want error of cluster dc2", err)
+	}
+	for name, sentinels := range map[string][]string{DefaultCluster: {"127.0.0.1:1"}, "dc2": {"127.0.0.1:3"}} {
+		if got := clusterWorkers[name].getSentinels(); !reflect.DeepEqual(got, sentinels) {
+			t.Errorf("sentinels of cluster %s = %v, want %v", name, got, sentinels)
+		}
+		if got := getConf().Redis.Cluster(name).Sentinels; !reflect.DeepEqual(got, sentinels) {
+			t.Errorf("sentinels of cluster %s in the running config = %v, want %v", name, got, sentinels)
+		}
+	}
+}
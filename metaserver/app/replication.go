@@ -0,0 +1,165 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	slaveStateOnline = "online"
+)
+
+type (
+	// SlaveReplInfo is a slave's replication state reported by its master's "INFO replication"
+	SlaveReplInfo struct {
+		State  string // wait_bgsave, send_bulk, online
+		Offset int64  // replication offset acked by the slave
+		Lag    int64  // seconds since the last ack of the slave
+	}
+
+	// ReplInfo is the replication state of a master and its slaves
+	ReplInfo struct {
+		MasterOffset int64
+		// slave "ip:port" -> replication state
+		Slaves map[string]SlaveReplInfo
+	}
+)
+
+// parseReplInfo parses the output of "INFO replication" of a master, whose slave lines are like
+// "slave0:ip=192.168.11.100,port=4002,state=online,offset=3168,lag=1".
+func parseReplInfo(info string) (ReplInfo, error) {
+	var (
+		err  error
+		repl ReplInfo
+	)
+
+	repl.Slaves = make(map[string]SlaveReplInfo, 4)
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+		key, value := line[:idx], line[idx+1:]
+		if key == "master_repl_offset" {
+			if repl.MasterOffset, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return repl, errors.Wrapf(err, "strconv.ParseInt(%s)", value)
+			}
+			continue
+		}
+		if !strings.HasPrefix(key, "slave") {
+			continue
+		}
+		if _, err = strconv.Atoi(key[len("slave"):]); err != nil {
+			continue // slave_repl_offset, slave_read_only...
+		}
+
+		var (
+			ip, port string
+			slave    SlaveReplInfo
+		)
+		for _, field := range strings.Split(value, ",") {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "ip":
+				ip = kv[1]
+			case "port":
+				port = kv[1]
+			case "state":
+				slave.State = kv[1]
+			case "offset":
+				if slave.Offset, err = strconv.ParseInt(kv[1], 10, 64); err != nil {
+					return repl, errors.Wrapf(err, "strconv.ParseInt(%s)", kv[1])
+				}
+			case "lag":
+				if slave.Lag, err = strconv.ParseInt(kv[1], 10, 64); err != nil {
+					return repl, errors.Wrapf(err, "strconv.ParseInt(%s)", kv[1])
+				}
+			}
+		}
+		if ip != "" && port != "" {
+			repl.Slaves[ip+":"+port] = slave
+		}
+	}
+
+	return repl, nil
+}
+
+// getReplInfo gets the replication state of @master of redis instance @name
+func (w *SentinelWorker) getReplInfo(name string, master *gxredis.IPAddr) (ReplInfo, error) {
+	var (
+		err  error
+		info string
+		conn redis.Conn
+	)
+
+	addr := master.TcpAddr().String()
+	if conn, err = getMasterConn(name, addr); err != nil {
+		return ReplInfo{}, errors.Wrapf(err, "getMasterConn(%s, %s)", name, addr)
+	}
+	defer conn.Close()
+
+	if info, err = redis.String(conn.Do("info", "replication")); err != nil {
+		return ReplInfo{}, errors.Wrapf(err, "info replication")
+	}
+
+	return parseReplInfo(info)
+}
+
+// filterSlaves returns the slaves of @master of redis instance @name that are available and whose replication lag is
+// not greater than redis.slave_max_lag. If the lag check is enabled, a slave that has not
+// finished its full resync is excluded too.
+func (w *SentinelWorker) filterSlaves(name string, master *gxredis.IPAddr, slaves []*gxredis.Slave) []*gxredis.Slave {
+	var (
+		err       error
+		repl      ReplInfo
+		available []*gxredis.Slave
+	)
+
+	for _, slave := range slaves {
+		if slave.Available() {
+			available = append(available, slave)
+		}
+	}
+	maxLag := getConf().Redis.SlaveMaxLag
+	if maxLag <= 0 || master == nil || len(available) == 0 {
+		return available
+	}
+
+	if repl, err = w.getReplInfo(name, master); err != nil {
+		// do not punish the slaves for an unreachable master
+		Log.Warn("failed to get replication info of master %s, error:%#v", master, err)
+		return available
+	}
+
+	slaves = available
+	available = nil
+	for _, slave := range slaves {
+		addr := slave.Addr.TcpAddr().String()
+		state, ok := repl.Slaves[addr]
+		if !ok {
+			Log.Warn("slave %s is not connected to master %s", addr, master)
+			continue
+		}
+		if state.State != slaveStateOnline {
+			Log.Warn("slave %s of master %s is in state %s", addr, master, state.State)
+			continue
+		}
+		if lag := repl.MasterOffset - state.Offset; maxLag < lag {
+			Log.Warn("slave %s of master %s lags %d bytes behind", addr, master, lag)
+			continue
+		}
+		available = append(available, slave)
+	}
+
+	return available
+}
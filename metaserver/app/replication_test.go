@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_parseReplInfo(t *testing.T) {
+	info := "# Replication\r\n" +
+		"role:master\r\n" +
+		"connected_slaves:2\r\n" +
+		"slave0:ip=192.168.11.100,port=4002,state=online,offset=3168,lag=1\r\n" +
+		"slave1:ip=192.168.11.101,port=4002,state=wait_bgsave,offset=0,lag=0\r\n" +
+		"master_repl_offset:3180\r\n" +
+		"repl_backlog_active:1\r\n"
+
+	repl, err := parseReplInfo(info)
+	if err != nil {
+		t.Fatalf("parseReplInfo() = error:%#v", err)
+	}
+	if repl.MasterOffset != 3180 {
+		t.Fatalf("master offset %d != 3180", repl.MasterOffset)
+	}
+	if len(repl.Slaves) != 2 {
+		t.Fatalf("slaves:%#v", repl.Slaves)
+	}
+	slave := repl.Slaves["192.168.11.100:4002"]
+	if slave.State != slaveStateOnline || slave.Offset != 3168 || slave.Lag != 1 {
+		t.Fatalf("slave0:%#v", slave)
+	}
+	if slave = repl.Slaves["192.168.11.101:4002"]; slave.State != "wait_bgsave" {
+		t.Fatalf("slave1:%#v", slave)
+	}
+}
+
+func Test_parseMasterLinkStatus(t *testing.T) {
+	info := "# Replication\r\nrole:slave\r\nmaster_host:192.168.11.100\r\nmaster_port:4001\r\nmaster_link_status:up\r\n"
+	if status := parseMasterLinkStatus(info); status != "up" {
+		t.Errorf("parseMasterLinkStatus() = %q", status)
+	}
+	if status := parseMasterLinkStatus("# Replication\r\nrole:master\r\n"); status != "" {
+		t.Errorf("parseMasterLinkStatus() = %q", status)
+	}
+}
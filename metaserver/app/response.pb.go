@@ -41,6 +41,9 @@ const (
 	EC_ILLEGAL_PARAM       ErrorCode = 1
 	EC_ILLEGAL_HTTP_METHOD ErrorCode = 2
 	EC_SYS_ERROR           ErrorCode = 3
+	EC_NOT_FOUND           ErrorCode = 4
+	EC_UNAUTHORIZED        ErrorCode = 5
+	EC_FORBIDDEN           ErrorCode = 6
 )
 
 var ErrorCode_name = map[int32]string{
@@ -48,12 +51,18 @@ var ErrorCode_name = map[int32]string{
 	1: "EC_ILLEGAL_PARAM",
 	2: "EC_ILLEGAL_HTTP_METHOD",
 	3: "EC_SYS_ERROR",
+	4: "EC_NOT_FOUND",
+	5: "EC_UNAUTHORIZED",
+	6: "EC_FORBIDDEN",
 }
 var ErrorCode_value = map[string]int32{
 	"EC_OK":                  0,
 	"EC_ILLEGAL_PARAM":       1,
 	"EC_ILLEGAL_HTTP_METHOD": 2,
 	"EC_SYS_ERROR":           3,
+	"EC_NOT_FOUND":           4,
+	"EC_UNAUTHORIZED":        5,
+	"EC_FORBIDDEN":           6,
 }
 
 func (ErrorCode) EnumDescriptor() ([]byte, []int) { return fileDescriptorResponse, []int{0} }
@@ -451,20 +460,23 @@ var (
 func init() { proto.RegisterFile("response.proto", fileDescriptorResponse) }
 
 var fileDescriptorResponse = []byte{
-	// 232 bytes of a gzipped FileDescriptorProto
+	// 277 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x2b, 0x4a, 0x2d, 0x2e,
 	0xc8, 0xcf, 0x2b, 0x4e, 0xd5, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0xc9, 0x4d, 0xcc, 0xcc,
 	0x53, 0xf2, 0xe4, 0xe2, 0x08, 0x82, 0x8a, 0x0b, 0x29, 0x73, 0xb1, 0x38, 0xe7, 0xa7, 0xa4, 0x4a,
 	0x30, 0x2a, 0x30, 0x6a, 0xf0, 0x19, 0xf1, 0xeb, 0x81, 0x14, 0xe8, 0xb9, 0x16, 0x15, 0xe5, 0x17,
 	0x81, 0x84, 0x83, 0xc0, 0x92, 0x42, 0x12, 0x5c, 0xec, 0xbe, 0xa9, 0xc5, 0xc5, 0x89, 0xe9, 0xa9,
-	0x12, 0x4c, 0x0a, 0x8c, 0x1a, 0x9c, 0x41, 0x30, 0xae, 0x56, 0x14, 0x17, 0x27, 0x5c, 0xb1, 0x10,
-	0x27, 0x17, 0xab, 0xab, 0x73, 0xbc, 0xbf, 0xb7, 0x00, 0x83, 0x90, 0x08, 0x97, 0x80, 0xab, 0x73,
-	0xbc, 0xa7, 0x8f, 0x8f, 0xab, 0xbb, 0xa3, 0x4f, 0x7c, 0x80, 0x63, 0x90, 0xa3, 0xaf, 0x00, 0xa3,
-	0x90, 0x14, 0x97, 0x18, 0x92, 0xa8, 0x47, 0x48, 0x48, 0x40, 0xbc, 0xaf, 0x6b, 0x88, 0x87, 0xbf,
-	0x8b, 0x00, 0x93, 0x90, 0x00, 0x17, 0x8f, 0xab, 0x73, 0x7c, 0x70, 0x64, 0x70, 0xbc, 0x6b, 0x50,
-	0x90, 0x7f, 0x90, 0x00, 0xb3, 0x93, 0xce, 0x85, 0x87, 0x72, 0x0c, 0x37, 0x1e, 0xca, 0x31, 0x7c,
+	0x12, 0x4c, 0x0a, 0x8c, 0x1a, 0x9c, 0x41, 0x30, 0xae, 0xd6, 0x64, 0x46, 0x2e, 0x4e, 0xb8, 0x6a,
+	0x21, 0x4e, 0x2e, 0x56, 0x57, 0xe7, 0x78, 0x7f, 0x6f, 0x01, 0x06, 0x21, 0x11, 0x2e, 0x01, 0x57,
+	0xe7, 0x78, 0x4f, 0x1f, 0x1f, 0x57, 0x77, 0x47, 0x9f, 0xf8, 0x00, 0xc7, 0x20, 0x47, 0x5f, 0x01,
+	0x46, 0x21, 0x29, 0x2e, 0x31, 0x24, 0x51, 0x8f, 0x90, 0x90, 0x80, 0x78, 0x5f, 0xd7, 0x10, 0x0f,
+	0x7f, 0x17, 0x01, 0x26, 0x21, 0x01, 0x2e, 0x1e, 0x57, 0xe7, 0xf8, 0xe0, 0xc8, 0xe0, 0x78, 0xd7,
+	0xa0, 0x20, 0xff, 0x20, 0x01, 0x66, 0xa8, 0x88, 0x9f, 0x7f, 0x48, 0xbc, 0x9b, 0x7f, 0xa8, 0x9f,
+	0x8b, 0x00, 0x8b, 0x90, 0x30, 0x17, 0xbf, 0xab, 0x73, 0x7c, 0xa8, 0x9f, 0x63, 0x68, 0x88, 0x87,
+	0x7f, 0x90, 0x67, 0x94, 0xab, 0x8b, 0x00, 0x2b, 0x54, 0x99, 0x9b, 0x7f, 0x90, 0x93, 0xa7, 0x8b,
+	0x8b, 0xab, 0x9f, 0x00, 0x9b, 0x93, 0xce, 0x85, 0x87, 0x72, 0x0c, 0x37, 0x1e, 0xca, 0x31, 0x7c,
 	0x78, 0x28, 0xc7, 0xd8, 0xf0, 0x48, 0x8e, 0x71, 0xc5, 0x23, 0x39, 0xc6, 0x13, 0x8f, 0xe4, 0x18,
 	0x2f, 0x3c, 0x92, 0x63, 0x7c, 0xf0, 0x48, 0x8e, 0xf1, 0xc5, 0x23, 0x39, 0x86, 0x0f, 0x8f, 0xe4,
-	0x18, 0x27, 0x3c, 0x96, 0x63, 0x48, 0x62, 0x03, 0xfb, 0xd0, 0x18, 0x10, 0x00, 0x00, 0xff, 0xff,
-	0x4c, 0xb0, 0x18, 0xb5, 0xf3, 0x00, 0x00, 0x00,
+	0x18, 0x27, 0x3c, 0x96, 0x63, 0x48, 0x62, 0x03, 0x87, 0x8d, 0x31, 0x60, 0x00, 0x14, 0x1e, 0x6e,
+	0x0d, 0x2d, 0x01, 0x00, 0x00,
 }
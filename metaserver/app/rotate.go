@@ -0,0 +1,382 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+type (
+	// RotatePasswordRequest rotates the password of all nodes of instance Name.
+	// If Username is not empty, the password of the ACL user Username is rotated
+	// and both passwords are valid in the following Overlap seconds; otherwise
+	// requirepass and masterauth are rotated.
+	RotatePasswordRequest struct {
+		Name        string `json:"name"`
+		Username    string `json:"username"`
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+		Overlap     int    `json:"overlap"`
+	}
+
+	// rotateStep is a command and its undo command on a connection
+	rotateStep struct {
+		desc string
+		conn redis.Conn
+		do   []interface{}
+		undo []interface{}
+	}
+
+	// passwordRemoval is the removal of the old password of an ACL user after the
+	// overlap window. It is kept in the meta db until done, so a restarted metaserver
+	// resumes it. Only the SHA-256 of the password is kept, which is enough for
+	// "ACL SETUSER <user> !<hash>".
+	passwordRemoval struct {
+		Name            string   `json:"name"`
+		Username        string   `json:"username"`
+		OldPasswordHash string   `json:"old_password_hash"`
+		Nodes           []string `json:"nodes"`
+		Due             int64    `json:"due"` // unix time
+	}
+)
+
+var (
+	rotateMutex sync.Mutex
+)
+
+func (r *RotatePasswordRequest) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("instance name is empty")
+	}
+	if r.OldPassword == "" || r.NewPassword == "" {
+		return fmt.Errorf("old password or new password is empty")
+	}
+	if r.OldPassword == r.NewPassword {
+		return fmt.Errorf("new password is the same as the old one")
+	}
+	if r.Overlap < 0 {
+		return fmt.Errorf("overlap %d is negative", r.Overlap)
+	}
+	if r.Username == "" && r.Overlap != 0 {
+		return fmt.Errorf("overlap window needs an ACL user")
+	}
+
+	return nil
+}
+
+func doCommand(conn redis.Conn, args []interface{}) error {
+	_, err := conn.Do(args[0].(string), args[1:]...)
+	return err
+}
+
+// runSteps runs @steps in turn. If any step fails, the finished steps are undone in reverse order.
+func runSteps(steps []rotateStep) error {
+	for i, step := range steps {
+		err := doCommand(step.conn, step.do)
+		if err == nil {
+			Log.Info("rotate password step %q done", step.desc)
+			continue
+		}
+
+		Log.Error("rotate password step %q failed, error:%#v, start to roll back", step.desc, err)
+		for j := i - 1; 0 <= j; j-- {
+			if undoErr := doCommand(steps[j].conn, steps[j].undo); undoErr != nil {
+				Log.Error("failed to roll back step %q, error:%#v", steps[j].desc, undoErr)
+			}
+		}
+		return errors.Wrapf(err, "step %q", step.desc)
+	}
+
+	return nil
+}
+
+// rotatePassword updates the password of the master and slaves of an instance and the
+// auth-pass of all sentinels in the order that keeps replication and failover working.
+func (w *SentinelWorker) rotatePassword(req RotatePasswordRequest) error {
+	rotateMutex.Lock()
+	defer rotateMutex.Unlock()
+
+	w.RLock()
+	inst, ok := w.meta.Instances[req.Name]
+	var master string
+	if ok && inst.Master != nil {
+		master = inst.Master.TcpAddr().String()
+	}
+	w.RUnlock()
+	if master == "" {
+		return fmt.Errorf("can not find the master of instance %s", req.Name)
+	}
+	slaves, err := w.sntl.Slaves(req.Name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get slaves of %s", req.Name)
+	}
+
+	// connect to all nodes before changing anything
+	var conns []redis.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	auth := SectionAuth{Username: req.Username, Password: req.OldPassword}
+	masterConn, err := dialRedis(master, auth, getConf().Redis.InstanceTLS, time.Duration(RedisConnTimeout))
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to master %s", master)
+	}
+	conns = append(conns, masterConn)
+	slaveConns := make(map[string]redis.Conn, len(slaves))
+	for _, slave := range slaves {
+		addr := w.getAddrTranslator().Translate(slave.Addr.TcpAddr().String())
+		conn, err := dialRedis(addr, auth, getConf().Redis.InstanceTLS, time.Duration(RedisConnTimeout))
+		if err != nil {
+			return errors.Wrapf(err, "failed to connect to slave %s", addr)
+		}
+		conns = append(conns, conn)
+		slaveConns[addr] = conn
+	}
+	sentinelConns := make(map[string]redis.Conn, 8)
+	for _, addr := range w.getSentinels() {
+		conn, err := dialSentinel(addr)
+		if err != nil {
+			return errors.Wrapf(err, "failed to connect to sentinel %s", addr)
+		}
+		conns = append(conns, conn)
+		sentinelConns[addr] = conn
+	}
+
+	var steps []rotateStep
+	configSet := func(addr string, conn redis.Conn, key string) {
+		steps = append(steps, rotateStep{
+			desc: fmt.Sprintf("config set %s of %s", key, addr),
+			conn: conn,
+			do:   []interface{}{"config", "set", key, req.NewPassword},
+			undo: []interface{}{"config", "set", key, req.OldPassword},
+		})
+	}
+	if req.Username != "" {
+		// both passwords are valid after adding the new one to the ACL user
+		for addr, conn := range slaveConns {
+			steps = append(steps, rotateStep{
+				desc: fmt.Sprintf("add new password of user %s of %s", req.Username, addr),
+				conn: conn,
+				do:   []interface{}{"acl", "setuser", req.Username, ">" + req.NewPassword},
+				undo: []interface{}{"acl", "setuser", req.Username, "<" + req.NewPassword},
+			})
+		}
+		steps = append(steps, rotateStep{
+			desc: fmt.Sprintf("add new password of user %s of %s", req.Username, master),
+			conn: masterConn,
+			do:   []interface{}{"acl", "setuser", req.Username, ">" + req.NewPassword},
+			undo: []interface{}{"acl", "setuser", req.Username, "<" + req.NewPassword},
+		})
+		for addr, conn := range slaveConns {
+			configSet(addr, conn, "masterauth")
+		}
+		configSet(master, masterConn, "masterauth")
+	} else {
+		// slaves should use the new password before the master requires it, and the
+		// slaves should require it before any of them is promoted to master
+		for addr, conn := range slaveConns {
+			configSet(addr, conn, "masterauth")
+		}
+		configSet(master, masterConn, "requirepass")
+		for addr, conn := range slaveConns {
+			configSet(addr, conn, "requirepass")
+		}
+		configSet(master, masterConn, "masterauth")
+	}
+	for addr, conn := range sentinelConns {
+		step := rotateStep{
+			desc: fmt.Sprintf("sentinel set %s auth-pass of %s", req.Name, addr),
+			conn: conn,
+			do:   []interface{}{"sentinel", "set", req.Name, "auth-pass", req.NewPassword},
+			undo: []interface{}{"sentinel", "set", req.Name, "auth-pass", req.OldPassword},
+		}
+		if req.Username != "" {
+			// the old password is valid for the user until the rotation is done, so
+			// the undo keeps auth-user
+			step.desc = fmt.Sprintf("sentinel set %s auth-user and auth-pass of %s", req.Name, addr)
+			step.do = []interface{}{"sentinel", "set", req.Name, "auth-user", req.Username, "auth-pass", req.NewPassword}
+		}
+		steps = append(steps, step)
+	}
+
+	if err = runSteps(steps); err != nil {
+		return err
+	}
+	w.useNewPassword(req)
+
+	slaveConns[master] = masterConn
+	nodes := make([]string, 0, len(slaveConns))
+	for addr, conn := range slaveConns {
+		nodes = append(nodes, addr)
+		// persist the new config, a node without config file(or acl file) fails here harmlessly
+		if err = doCommand(conn, []interface{}{"config", "rewrite"}); err != nil {
+			Log.Warn("config rewrite of %s error:%#v", addr, err)
+		}
+		if req.Username == "" {
+			continue
+		}
+		if err = doCommand(conn, []interface{}{"acl", "save"}); err != nil {
+			Log.Warn("acl save of %s error:%#v", addr, err)
+		}
+	}
+
+	if req.Username != "" {
+		sum := sha256.Sum256([]byte(req.OldPassword))
+		removal := passwordRemoval{
+			Name:            req.Name,
+			Username:        req.Username,
+			OldPasswordHash: hex.EncodeToString(sum[:]),
+			Nodes:           nodes,
+			Due:             time.Now().Add(time.Duration(req.Overlap) * time.Second).Unix(),
+		}
+		if req.Overlap == 0 {
+			w.removeOldPassword(removal)
+			return nil
+		}
+		if err = w.savePasswordRemoval(removal); err != nil {
+			Log.Warn("failed to save the removal of old password of user %s of instance %s, "+
+				"it is lost if metaserver restarts in %d seconds, error:%#v", req.Username, req.Name, req.Overlap, err)
+		}
+		w.schedulePasswordRemoval(removal)
+	}
+
+	return nil
+}
+
+// useNewPassword lets the metaserver connect to the nodes of the instance of @req
+// with the new password, if it used the old one. The change is lost on reload, so
+// the config file should be updated too.
+func (w *SentinelWorker) useNewPassword(req RotatePasswordRequest) {
+	auth := getInstanceAuth(req.Name)
+	if password, err := auth.GetPassword(); err != nil || auth.Username != req.Username || password != req.OldPassword {
+		return
+	}
+
+	setInstanceAuth(req.Name, SectionAuth{Username: req.Username, Password: req.NewPassword})
+	Log.Warn("auth of instance %s is set to the new password until the next reload, please update config file %s",
+		req.Name, confFile)
+}
+
+// passwordRemovalsKey returns the hashtable of the pending password removals in the meta db
+func passwordRemovalsKey(conf SectionRedis) string {
+	return conf.MetaHashtable + ":password_removals"
+}
+
+// field returns the field of @r in the hashtable of pending password removals
+func (r passwordRemoval) field() string {
+	return r.Name + "/" + r.Username
+}
+
+// getMetaDBConn connects to the master of the meta db
+func (w *SentinelWorker) getMetaDBConn() (redis.Conn, error) {
+	conf := w.conf()
+	w.RLock()
+	metaDB, ok := w.meta.Instances[conf.MetaDBName]
+	var addr string
+	if ok && metaDB.Master != nil {
+		addr = metaDB.Master.TcpAddr().String()
+	}
+	w.RUnlock()
+	if addr == "" {
+		return nil, fmt.Errorf("master of meta db %s is unknown", conf.MetaDBName)
+	}
+
+	return getMasterConn(conf.MetaDBName, addr)
+}
+
+// savePasswordRemoval keeps @removal in the meta db until it is done
+func (w *SentinelWorker) savePasswordRemoval(removal passwordRemoval) error {
+	data, err := json.Marshal(removal)
+	if err != nil {
+		return errors.Wrapf(err, "json.Marshal(%#v)", removal)
+	}
+	conn, err := w.getMetaDBConn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	key := passwordRemovalsKey(w.conf())
+	if _, err = conn.Do("hset", key, removal.field(), string(data)); err != nil {
+		return errors.Wrapf(err, "hset(%s, %s)", key, removal.field())
+	}
+
+	return nil
+}
+
+// resumePasswordRemovals schedules the password removals kept in the meta db
+func (w *SentinelWorker) resumePasswordRemovals() error {
+	conn, err := w.getMetaDBConn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	key := passwordRemovalsKey(w.conf())
+	values, err := redis.StringMap(conn.Do("hgetall", key))
+	if err != nil {
+		return errors.Wrapf(err, "hgetall(%s)", key)
+	}
+	for field, value := range values {
+		var removal passwordRemoval
+		if err = json.Unmarshal([]byte(value), &removal); err != nil {
+			Log.Error("illegal password removal %s: %s, error:%#v", field, value, err)
+			continue
+		}
+		Log.Info("resume the removal of old password of user %s of instance %s at %s",
+			removal.Username, removal.Name, time.Unix(removal.Due, 0))
+		w.schedulePasswordRemoval(removal)
+	}
+
+	return nil
+}
+
+// schedulePasswordRemoval runs @removal when it is due
+func (w *SentinelWorker) schedulePasswordRemoval(removal passwordRemoval) {
+	time.AfterFunc(time.Until(time.Unix(removal.Due, 0)), func() {
+		w.removeOldPassword(removal)
+	})
+}
+
+// removeOldPassword removes the old password of @removal from its nodes, and drops it
+// from the meta db. A node failing is not retried.
+func (w *SentinelWorker) removeOldPassword(removal passwordRemoval) {
+	for _, addr := range removal.Nodes {
+		conn, err := dialInstance(removal.Name, addr)
+		if err == nil {
+			err = doCommand(conn, []interface{}{"acl", "setuser", removal.Username, "!" + removal.OldPasswordHash})
+			if err == nil {
+				// persist it as rotatePassword does
+				doCommand(conn, []interface{}{"config", "rewrite"})
+				doCommand(conn, []interface{}{"acl", "save"})
+			}
+			conn.Close()
+		}
+		if err != nil {
+			Log.Error("failed to remove old password of user %s of %s, error:%#v", removal.Username, addr, err)
+		}
+	}
+	Log.Info("old password of user %s of instance %s has been removed from {%s}",
+		removal.Username, removal.Name, strings.Join(removal.Nodes, ", "))
+
+	conn, err := w.getMetaDBConn()
+	if err == nil {
+		_, err = conn.Do("hdel", passwordRemovalsKey(w.conf()), removal.field())
+		conn.Close()
+	}
+	if err != nil {
+		Log.Warn("failed to drop the removal of old password of user %s of instance %s, error:%#v",
+			removal.Username, removal.Name, err)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestRotatePasswordRequest_Validate(t *testing.T) {
+	cases := []struct {
+		req RotatePasswordRequest
+		err string
+	}{
+		{RotatePasswordRequest{Name: "cache1", OldPassword: "old", NewPassword: "new"}, ""},
+		{RotatePasswordRequest{Name: "cache1", Username: "ops", OldPassword: "old", NewPassword: "new", Overlap: 60}, ""},
+		{RotatePasswordRequest{OldPassword: "old", NewPassword: "new"}, "instance name is empty"},
+		{RotatePasswordRequest{Name: "cache1", NewPassword: "new"}, "old password or new password is empty"},
+		{RotatePasswordRequest{Name: "cache1", OldPassword: "old"}, "old password or new password is empty"},
+		{RotatePasswordRequest{Name: "cache1", OldPassword: "old", NewPassword: "old"}, "the same as the old one"},
+		{RotatePasswordRequest{Name: "cache1", Username: "ops", OldPassword: "old", NewPassword: "new", Overlap: -1},
+			"negative"},
+		{RotatePasswordRequest{Name: "cache1", OldPassword: "old", NewPassword: "new", Overlap: 60}, "needs an ACL user"},
+	}
+	for i, c := range cases {
+		err := c.req.Validate()
+		if (c.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("case %d: Validate() = error:%v, want %q", i, err, c.err)
+		}
+	}
+}
+
+func Test_runSteps(t *testing.T) {
+	oldLog := Log
+	Log = newReloadableLogger(&fakeLogger{})
+	defer func() {
+		Log = oldLog
+	}()
+	good := newRedisStandIn(t, "", nil)
+	defer good.Close()
+	bad := newRedisStandIn(t, "", map[string]interface{}{"config set": standInError("ERR unsupported CONFIG parameter")})
+	defer bad.Close()
+	dial := func(node *redisStandIn) redis.Conn {
+		conn, err := dialRedis(node.Addr(), SectionAuth{}, SectionTLS{}, time.Second)
+		if err != nil {
+			t.Fatalf("dialRedis(%s) = error:%#v", node.Addr(), err)
+		}
+		return conn
+	}
+	goodConn, badConn := dial(good), dial(bad)
+	defer goodConn.Close()
+	defer badConn.Close()
+	step := func(conn redis.Conn, key string) rotateStep {
+		return rotateStep{
+			desc: "config set " + key,
+			conn: conn,
+			do:   []interface{}{"config", "set", key, "new"},
+			undo: []interface{}{"config", "set", key, "old"},
+		}
+	}
+
+	cases := []struct {
+		steps []rotateStep
+		err   bool
+		// commands received by the good node
+		commands []string
+	}{
+		{[]rotateStep{step(goodConn, "masterauth"), step(goodConn, "requirepass")}, false,
+			[]string{"config set masterauth new", "config set requirepass new"}},
+		// the finished steps are undone in reverse order, and the failed one is not
+		{[]rotateStep{step(goodConn, "masterauth"), step(goodConn, "requirepass"), step(badConn, "requirepass"),
+			step(goodConn, "appendonly")}, true,
+			[]string{"config set masterauth new", "config set requirepass new",
+				"config set requirepass old", "config set masterauth old"}},
+		{[]rotateStep{step(badConn, "masterauth"), step(goodConn, "requirepass")}, true, nil},
+	}
+	for i, c := range cases {
+		before := len(good.Commands())
+		err := runSteps(c.steps)
+		if (err != nil) != c.err {
+			t.Errorf("case %d: runSteps() = error:%v", i, err)
+		}
+		if commands := good.Commands()[before:]; strings.Join(commands, "\n") != strings.Join(c.commands, "\n") {
+			t.Errorf("case %d: commands = %q, want %q", i, commands, c.commands)
+		}
+	}
+}
+
+// standInIPAddr returns the address of @node in the meta
+func standInIPAddr(node *redisStandIn) *gxredis.IPAddr {
+	addr := node.listener.Addr().(*net.TCPAddr)
+	return &gxredis.IPAddr{IP: addr.IP.String(), Port: int32(addr.Port)}
+}
+
+func setRotateTestConf(auth SectionAuth) func() {
+	oldConf := *getConf()
+	conf := oldConf
+	conf.Redis.MetaDBName = "meta"
+	conf.Redis.MetaHashtable = "meta_hashtable"
+	conf.Redis.InstanceAuths = map[string]SectionAuth{"cache1": auth}
+	runningConf.set(conf)
+	oldLog := Log
+	Log = newReloadableLogger(&fakeLogger{})
+
+	return func() {
+		runningConf.set(oldConf)
+		Log = oldLog
+	}
+}
+
+func TestSentinelWorker_rotatePassword(t *testing.T) {
+	master := newRedisStandIn(t, "old-pw", nil)
+	defer master.Close()
+	slave := newRedisStandIn(t, "old-pw", nil)
+	defer slave.Close()
+	slaveAddr := standInIPAddr(slave)
+	sentinel := newRedisStandIn(t, "", map[string]interface{}{
+		"sentinel slaves": []interface{}{
+			[]string{"ip", slaveAddr.IP, "port", slave.Addr()[strings.LastIndex(slave.Addr(), ":")+1:], "flags", "slave"},
+		},
+	})
+	defer sentinel.Close()
+	metaDB := newRedisStandIn(t, "", map[string]interface{}{"role": []interface{}{"master", 0, []interface{}{}}})
+	defer metaDB.Close()
+	defer setRotateTestConf(SectionAuth{Username: "ops", Password: "old-pw"})()
+
+	translator, _ := NewAddrTranslator(nil, nil)
+	sw := &SentinelWorker{
+		cluster:    DefaultCluster,
+		sentinels:  []string{sentinel.Addr()},
+		translator: translator,
+		meta: ClusterMeta{Instances: map[string]*gxredis.Instance{
+			"cache1": {Name: "cache1", Master: standInIPAddr(master)},
+			"meta":   {Name: "meta", Master: standInIPAddr(metaDB)},
+		}},
+	}
+	sw.sntl = newSentinelClient(sw.getSentinels)
+
+	err := sw.rotatePassword(RotatePasswordRequest{
+		Name:        "cache1",
+		Username:    "ops",
+		OldPassword: "old-pw",
+		NewPassword: "new-pw",
+		Overlap:     3600,
+	})
+	if err != nil {
+		t.Fatalf("rotatePassword() = error:%#v", err)
+	}
+
+	for _, node := range []*redisStandIn{master, slave} {
+		commands := strings.Join(node.Commands(), "\n")
+		if !strings.Contains(commands, "acl setuser ops >new-pw") || !strings.Contains(commands, "config set masterauth new-pw") ||
+			strings.Contains(commands, "acl setuser ops <") {
+			t.Errorf("commands of node %s = %q", node.Addr(), commands)
+		}
+	}
+	if commands := strings.Join(sentinel.Commands(), "\n"); !strings.Contains(commands,
+		"sentinel set cache1 auth-user ops auth-pass new-pw") {
+		t.Errorf("commands of sentinel = %q, want auth-user and auth-pass", commands)
+	}
+
+	// the removal of the old password is kept in the meta db without the password
+	var removal passwordRemoval
+	for _, cmd := range metaDB.Commands() {
+		if prefix := "hset meta_hashtable:password_removals cache1/ops "; strings.HasPrefix(cmd, prefix) {
+			if err = json.Unmarshal([]byte(strings.TrimPrefix(cmd, prefix)), &removal); err != nil {
+				t.Fatalf("json.Unmarshal(%s) = error:%#v", cmd, err)
+			}
+			if strings.Contains(cmd, "old-pw") {
+				t.Errorf("the old password is stored: %s", cmd)
+			}
+		}
+	}
+	sum := sha256.Sum256([]byte("old-pw"))
+	if removal.OldPasswordHash != hex.EncodeToString(sum[:]) || len(removal.Nodes) != 2 ||
+		removal.Due < time.Now().Add(3590*time.Second).Unix() {
+		t.Errorf("removal in meta db = %+v", removal)
+	}
+
+	if auth := getInstanceAuth("cache1"); auth.Username != "ops" || auth.Password != "new-pw" {
+		t.Errorf("auth of cache1 after rotation = %+v, want the new password", auth)
+	}
+}
+
+func TestSentinelWorker_resumePasswordRemovals(t *testing.T) {
+	node := newRedisStandIn(t, "new-pw", nil)
+	defer node.Close()
+	sum := sha256.Sum256([]byte("old-pw"))
+	removal := passwordRemoval{
+		Name:            "cache1",
+		Username:        "ops",
+		OldPasswordHash: hex.EncodeToString(sum[:]),
+		Nodes:           []string{node.Addr()},
+		Due:             time.Now().Add(-time.Minute).Unix(),
+	}
+	data, _ := json.Marshal(removal)
+	metaDB := newRedisStandIn(t, "", map[string]interface{}{
+		"role":    []interface{}{"master", 0, []interface{}{}},
+		"hgetall": []string{removal.field(), string(data)},
+	})
+	defer metaDB.Close()
+	defer setRotateTestConf(SectionAuth{Username: "ops", Password: "new-pw"})()
+
+	sw := &SentinelWorker{
+		cluster: DefaultCluster,
+		meta:    ClusterMeta{Instances: map[string]*gxredis.Instance{"meta": {Name: "meta", Master: standInIPAddr(metaDB)}}},
+	}
+	if err := sw.resumePasswordRemovals(); err != nil {
+		t.Fatalf("resumePasswordRemovals() = error:%#v", err)
+	}
+
+	// the due removal runs at once, and is dropped from the meta db after done
+	dropped := "hdel meta_hashtable:password_removals cache1/ops"
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		commands := metaDB.Commands()
+		if commands[len(commands)-1] == dropped {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("commands of meta db = %q, want %q", commands, dropped)
+		}
+	}
+	if commands := strings.Join(node.Commands(), "\n"); !strings.Contains(commands, "acl setuser ops !"+removal.OldPasswordHash) {
+		t.Errorf("commands of node = %q, want the removal of the old password", commands)
+	}
+}
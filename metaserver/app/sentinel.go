@@ -3,7 +3,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -11,65 +14,257 @@ import (
 import (
 	"github.com/AlexStocks/goext/database/redis"
 	"github.com/AlexStocks/goext/math/rand"
+	"github.com/AlexStocks/goext/time"
 	"github.com/garyburd/redigo/redis"
 	"github.com/pkg/errors"
 )
 
 type (
 	SentinelWorker struct {
-		sntl *gxredis.Sentinel
+		cluster string
+		sntl    *sentinelClient
 		// redis instances meta data
 		sync.RWMutex
-		meta          ClusterMeta
+		meta ClusterMeta
+		// operator-controlled attributes of instances
+		attrs map[string]InstanceAttrs
+		// versions of namespaces
+		nsLock        sync.Mutex
+		nsStates      map[string]namespaceState
 		wg            sync.WaitGroup
-		switchWatcher *gxredis.SentinelWatcher
-		sdownWatcher  *gxredis.SentinelWatcher
+		switchWatcher *SentinelWatcher
+		sdownWatcher  *SentinelWatcher
+		// sentinels used by the watchers and admin commands
+		sentinels  []string
+		splitBrain *SplitBrainDetector
+		translator *AddrTranslator
+		zones      *ZoneResolver
+		// single-zone instances found by the last checkZones
+		singleZone map[string]string
+		// waiters of switch events
+		switchNotifier *switchNotifier
+		// false in degraded mode
+		ready bool
+		// serializes start and resetSentinel, so a start does not use the sentinels
+		// being replaced
+		startLock sync.Mutex
+		// what the update loop and the meta store observed last
+		healthLock sync.Mutex
+		health     workerHealth
+		done       chan empty
 	}
 )
 
-func NewSentinelWorker() *SentinelWorker {
+const (
+	StartRetryInterval = 5e9 // 5s
+)
+
+// NewSentinelWorker creates a worker of @cluster in degraded mode, which serves the meta
+// cached on local disk, and starts it in the background. If the sentinels or the meta
+// db are unreachable, the worker keeps retrying to start.
+func NewSentinelWorker(cluster string) *SentinelWorker {
 	var (
-		err       error
-		instances []gxredis.Instance
-		metaDB    gxredis.Instance
-		sw        *SentinelWorker
+		err        error
+		sw         *SentinelWorker
+		translator *AddrTranslator
+		zones      *ZoneResolver
+		redisConf  = &getConf().Redis
+		conf       = redisConf.Cluster(cluster)
 	)
 
+	// the config has been validated
+	translator, _ = NewAddrTranslator(redisConf.AddrMap, redisConf.AddrRules)
+	zones, _ = NewZoneResolver(redisConf.ZoneRules)
 	sw = &SentinelWorker{
-		sntl: gxredis.NewSentinel(Conf.Redis.Sentinels),
+		cluster: cluster,
 		meta: ClusterMeta{
 			Instances: make(map[string]*gxredis.Instance, 32),
 		},
+		attrs:          make(map[string]InstanceAttrs),
+		nsStates:       make(map[string]namespaceState),
+		splitBrain:     NewSplitBrainDetector(),
+		switchNotifier: newSwitchNotifier(),
+		sentinels:      append([]string{}, conf.Sentinels...),
+		translator:     translator,
+		zones:          zones,
+		health:         workerHealth{loopTime: time.Now()},
+		done:           make(chan empty),
+	}
+	sw.sntl = newSentinelClient(sw.getSentinels)
+
+	if err = sw.loadMetaCache(); err != nil && !os.IsNotExist(errors.Cause(err)) {
+		Log.Error("loadMetaCache() of cluster %s = error:%#v", cluster, err)
 	}
+	sw.wg.Add(3)
+	go sw.retryStart()
+	go sw.updateLoop()
+	go sw.splitBrainLoop()
+
+	return sw
+}
+
+// conf returns the redis config of the cluster of the worker
+func (w *SentinelWorker) conf() SectionRedis {
+	return getConf().Redis.Cluster(w.cluster)
+}
 
-	instances, err = sw.sntl.GetInstances()
+// updateLoop checks the sentinels and the meta db, updates the meta and checks zones
+// every update_interval. A worker has its own loop, so a slow cluster does not
+// delay the others.
+func (w *SentinelWorker) updateLoop() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-time.After(gxtime.TimeSecondDuration(float64(getConf().Redis.UpdateInterval))):
+			w.touchLoop()
+			w.refreshDependencies()
+			if !w.Ready() {
+				continue
+			}
+			w.setUpdateResult(w.updateClusterMeta())
+			w.checkZones()
+		}
+	}
+}
+
+// start loads meta from meta db and starts the switch and sdown watchers
+func (w *SentinelWorker) start() error {
+	var (
+		err       error
+		instances []gxredis.Instance
+		metaDB    gxredis.Instance
+		conf      = w.conf()
+	)
+
+	w.startLock.Lock()
+	defer w.startLock.Unlock()
+
+	instances, err = w.sntl.GetInstances()
 	if err != nil {
-		panic(fmt.Sprintf("st.GetInstances, error:%#v\n", err))
+		return errors.Wrapf(err, "st.GetInstances")
 	}
 
 	for _, inst := range instances {
-		if inst.Name == Conf.Redis.MetaDBName {
+		if inst.Name == conf.MetaDBName {
 			metaDB = inst
 		}
 		// discover new sentinel
-		err = sw.sntl.Discover(inst.Name, []string{"127.0.0.1"})
-		if err != nil {
-			panic(fmt.Sprintf("failed to discover sentiinels of instance:%s, error:%#v", inst.Name, err))
+		if err = w.discoverSentinels(inst.Name); err != nil {
+			return err
 		}
 	}
 
-	// sw.meta.Version = 0
+	// w.meta.Version = 0
 	if metaDB.Name == "" {
-		panic("can not find meta db.")
+		return fmt.Errorf("can not find meta db.")
+	}
+	if err = w.loadClusterMetaData(); err != nil {
+		return errors.Wrapf(err, "loadClusterMetaData()")
 	}
-	if err = sw.loadClusterMetaData(); err != nil {
-		panic(fmt.Sprintf("loadClusterMetaData() = error:%#v", err))
+	Log.Debug("after loadClusterMetaData(), worker.meta:%s", w.meta.Instances)
+	if err = w.updateClusterMeta(); err != nil {
+		// the meta loaded from the meta db is served until the update loop succeeds
+		Log.Warn("updateClusterMeta() of cluster %s = error:%#v", w.cluster, err)
 	}
-	Log.Debug("after loadClusterMetaData(), worker.meta:%s", sw.meta.Instances)
-	sw.updateClusterMeta()
-	Log.Debug("after updateClusterMetaData(), worker.meta:%s", sw.meta.Instances)
+	w.setUpdateResult(err)
+	Log.Debug("after updateClusterMetaData(), worker.meta:%s", w.meta.Instances)
 
-	return sw
+	if err = w.WatchInstanceSwitch(); err != nil {
+		return errors.Wrapf(err, "failed to start watch instance switch goroutine")
+	}
+	if err = w.WatchSdown(); err != nil {
+		w.switchWatcher.Close()
+		w.switchWatcher = nil
+		return errors.Wrapf(err, "failed to start watch +sdown goroutine")
+	}
+
+	w.Lock()
+	w.ready = true
+	w.Unlock()
+	if err = w.resumePasswordRemovals(); err != nil {
+		Log.Warn("resumePasswordRemovals() of cluster %s = error:%#v", w.cluster, err)
+	}
+
+	return nil
+}
+
+// retryStart tries to start the worker at once and then every StartRetryInterval until it succeeds.
+func (w *SentinelWorker) retryStart() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(StartRetryInterval))
+	defer ticker.Stop()
+	for retries := 0; ; retries++ {
+		err := w.start()
+		if err == nil {
+			Log.Info("sentinel worker of cluster %s leaves degraded mode", w.cluster)
+			return
+		}
+		if retries == 0 {
+			Log.Error("failed to start sentinel worker of cluster %s, error:%#v, stay in degraded mode", w.cluster, err)
+		} else {
+			Log.Warn("failed to start sentinel worker of cluster %s, error:%#v", w.cluster, err)
+		}
+
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Ready returns false if the worker is in degraded mode.
+func (w *SentinelWorker) Ready() bool {
+	w.RLock()
+	defer w.RUnlock()
+	return w.ready
+}
+
+// parseMetaHashtable parses the reply of "HGETALL" of a meta hashtable of @conf
+func parseMetaHashtable(conf SectionRedis, res interface{}) (MetaView, error) {
+	var (
+		err     error
+		key     string
+		value   []byte
+		version int
+		view    = MetaView{Instances: make(map[string]MetaInstance, 32)}
+	)
+
+	if res == nil {
+		return view, nil
+	}
+	arr := res.([]interface{})
+	for _, elem := range arr {
+		if len(key) == 0 {
+			key = string(elem.([]byte))
+			continue
+		}
+
+		value = elem.([]byte)
+		if key == conf.MetaVersion {
+			if version, err = strconv.Atoi(string(value)); err != nil {
+				return view, errors.Wrapf(err, "strconv.Atoi(%s)", string(value))
+			}
+			view.Version = int32(version)
+		} else if key == conf.MetaInstNameList {
+		} else {
+			var inst MetaInstance
+			if err = json.Unmarshal(value, &inst); err != nil {
+				return view, errors.Wrapf(err, "json.Unmarshal(value:%s)", string(value))
+			}
+			if inst.Instance == nil {
+				return view, fmt.Errorf("instance %s is null", key)
+			}
+			view.Instances[key] = inst
+		}
+		key = ""
+	}
+
+	return view, nil
 }
 
 func (w *SentinelWorker) loadClusterMetaData() error {
@@ -79,9 +274,8 @@ func (w *SentinelWorker) loadClusterMetaData() error {
 		instances []gxredis.Instance
 		metaDB    gxredis.Instance
 		metaConn  redis.Conn
-		key       string
-		value     []byte
-		version   int
+		view      MetaView
+		conf      = w.conf()
 	)
 
 	instances, err = w.sntl.GetInstances()
@@ -90,81 +284,55 @@ func (w *SentinelWorker) loadClusterMetaData() error {
 	}
 
 	for _, inst := range instances {
-		if inst.Name == Conf.Redis.MetaDBName {
+		if inst.Name == conf.MetaDBName {
 			metaDB = inst
 			break
 		}
 	}
+	if metaDB.Master == nil {
+		return fmt.Errorf("can not find master of meta db %s", conf.MetaDBName)
+	}
 
-	if metaConn, err = w.sntl.GetConnByRole(metaDB.Master.TcpAddr().String(), gxredis.RR_Master); err != nil {
-		return errors.Wrapf(err, "gxsentinel.GetConnByRole(%s, RR_Master)", metaDB.Master.TcpAddr().String())
+	if metaConn, err = getMasterConn(conf.MetaDBName, metaDB.Master.TcpAddr().String()); err != nil {
+		return errors.Wrapf(err, "getMasterConn(%s)", metaDB.Master.TcpAddr().String())
 	}
 	defer metaConn.Close()
 
-	if res, err = metaConn.Do("hgetall", Conf.Redis.MetaHashtable); err != nil {
-		return errors.Wrapf(err, "hgetall(%s)", Conf.Redis.MetaHashtable)
+	if res, err = metaConn.Do("hgetall", conf.MetaHashtable); err != nil {
+		return errors.Wrapf(err, "hgetall(%s)", conf.MetaHashtable)
 	}
-	if res != nil {
-		arr := res.([]interface{})
-		for _, elem := range arr {
-			if len(key) == 0 {
-				key = string(elem.([]byte))
-				continue
-			}
-
-			value = elem.([]byte)
-			if key == Conf.Redis.MetaVersion {
-				if version, err = strconv.Atoi(string(value)); err != nil {
-					return errors.Wrapf(err, "strconv.Atoi(%s)", string(value))
-				}
-				w.meta.Version = int32(version)
-			} else if key == Conf.Redis.MetaInstNameList {
-			} else {
-				var inst gxredis.Instance
-				if err = json.Unmarshal(value, &inst); err != nil {
-					return errors.Wrapf(err, "json.Unmarshal(value:%s)", string(value))
-				}
-				Log.Debug("name:%s, inst:%s", key, inst)
-				w.meta.Instances[key] = &inst
-			}
-			key = ""
-		}
+	if view, err = parseMetaHashtable(conf, res); err != nil {
+		return err
 	}
+	meta, attrs := splitMetaView(view)
+	Log.Debug("version:%d, instances:%s, attrs:%+v", meta.Version, meta.Instances, attrs)
+	if err = w.loadNamespaces(metaConn); err != nil {
+		return errors.Wrapf(err, "loadNamespaces()")
+	}
+
+	w.Lock()
+	w.meta = meta
+	w.attrs = attrs
+	w.Unlock()
 
 	return nil
 }
 
-func (w *SentinelWorker) storeClusterMetaData() error {
+// writeMetaHashtable writes @instances and @version into a temporary hashtable,
+// and renames it to @key in a transaction. The field names are the ones of @conf.
+func writeMetaHashtable(conf SectionRedis, metaConn redis.Conn, key string, version int32, instances map[string]MetaInstance) error {
 	var (
 		err              error
-		ok               bool
 		queued           interface{}
 		jsonStr          []byte
-		metaDB           *gxredis.Instance
-		metaConn         redis.Conn
 		instanceNameList InstanceNameList
 	)
 
-	w.RLock()
-	defer w.RUnlock()
-
-	if len(w.meta.Instances) == 0 {
-		return fmt.Errorf("redis cluster instance pool is empty")
+	htName := key + "-" + time.Now().Format("20060102-150405") + "-" + gxrand.RandString(8)
+	if _, err = metaConn.Do("hset", htName, conf.MetaVersion, version); err != nil {
+		return errors.Wrapf(err, "hset(%s, %s, %d)", htName, conf.MetaVersion, version)
 	}
-
-	if metaDB, ok = w.meta.Instances[Conf.Redis.MetaDBName]; !ok {
-		return fmt.Errorf("can not find meta db")
-	}
-
-	if metaConn, err = w.sntl.GetConnByRole(metaDB.Master.TcpAddr().String(), gxredis.RR_Master); err != nil {
-		return errors.Wrapf(err, "gxsentinel.GetConnByRole(%s, RR_Master)", metaDB.Master.TcpAddr().String())
-	}
-
-	htName := Conf.Redis.MetaHashtable + "-" + time.Now().Format("20060102-150405") + "-" + gxrand.RandString(8)
-	if _, err = metaConn.Do("hset", htName, Conf.Redis.MetaVersion, w.meta.Version); err != nil {
-		return errors.Wrapf(err, "hset(%s, %s, %s)", htName, Conf.Redis.MetaVersion, w.meta.Version)
-	}
-	for k, v := range w.meta.Instances {
+	for k, v := range instances {
 		if jsonStr, err = json.Marshal(v); err != nil {
 			Log.Error("json.Marshal(%#v) = %#v", v, err)
 			continue
@@ -178,8 +346,8 @@ func (w *SentinelWorker) storeClusterMetaData() error {
 	if jsonStr, err = json.Marshal(instanceNameList); err != nil {
 		return errors.Wrapf(err, "json.Marshal(%#v)", instanceNameList)
 	}
-	if _, err = metaConn.Do("hset", htName, Conf.Redis.MetaInstNameList, string(jsonStr)); err != nil {
-		return errors.Wrapf(err, "hset(%s, %s, %s)", htName, Conf.Redis.MetaInstNameList, string(jsonStr))
+	if _, err = metaConn.Do("hset", htName, conf.MetaInstNameList, string(jsonStr)); err != nil {
+		return errors.Wrapf(err, "hset(%s, %s, %s)", htName, conf.MetaInstNameList, string(jsonStr))
 	}
 
 	// redis.tx
@@ -187,16 +355,15 @@ func (w *SentinelWorker) storeClusterMetaData() error {
 		if err != nil {
 			metaConn.Do("discard")
 		}
-		metaConn.Close()
 	}()
 
-	if _, err = metaConn.Do("watch", Conf.Redis.MetaHashtable); err != nil {
-		return errors.Wrapf(err, "watch %s", Conf.Redis.MetaHashtable)
+	if _, err = metaConn.Do("watch", key); err != nil {
+		return errors.Wrapf(err, "watch %s", key)
 	}
 
 	metaConn.Send("multi")
-	if _, err = metaConn.Do("rename", htName, Conf.Redis.MetaHashtable); err != nil {
-		return errors.Wrapf(err, "rename(%s, %s)", htName, Conf.Redis.MetaHashtable)
+	if _, err = metaConn.Do("rename", htName, key); err != nil {
+		return errors.Wrapf(err, "rename(%s, %s)", htName, key)
 	}
 	queued, err = metaConn.Do("exec")
 	if err != nil {
@@ -209,6 +376,55 @@ func (w *SentinelWorker) storeClusterMetaData() error {
 	return nil
 }
 
+// storeClusterMetaData writes the meta into the meta hashtable, and every changed
+// namespace into its own hashtable.
+func (w *SentinelWorker) storeClusterMetaData() (err error) {
+	var (
+		ok       bool
+		metaDB   *gxredis.Instance
+		metaConn redis.Conn
+		conf     = w.conf()
+	)
+
+	defer observeStoreMeta(w.cluster, time.Now(), &err)
+	defer func() {
+		w.setStoreResult(err)
+		if err != nil {
+			w.notify(EventStoreFailure, StoreFailureData{Error: err.Error()})
+		}
+	}()
+	w.RLock()
+	defer w.RUnlock()
+
+	if len(w.meta.Instances) == 0 {
+		return fmt.Errorf("redis cluster instance pool is empty")
+	}
+
+	if metaDB, ok = w.meta.Instances[conf.MetaDBName]; !ok {
+		return fmt.Errorf("can not find meta db")
+	}
+
+	if metaConn, err = getMasterConn(conf.MetaDBName, metaDB.Master.TcpAddr().String()); err != nil {
+		return errors.Wrapf(err, "getMasterConn(%s)", metaDB.Master.TcpAddr().String())
+	}
+	defer metaConn.Close()
+
+	view := w.metaView()
+	if err = writeMetaHashtable(conf, metaConn, conf.MetaHashtable, view.Version, view.Instances); err != nil {
+		return err
+	}
+	nsErr := w.storeNamespaces(metaConn, view)
+
+	if err := w.saveMetaCache(); err != nil {
+		Log.Warn("saveMetaCache() = error:%#v", err)
+	}
+	if nsErr != nil {
+		return errors.Wrapf(nsErr, "storeNamespaces()")
+	}
+
+	return nil
+}
+
 func (w *SentinelWorker) updateClusterMeta() error {
 	instances, err := w.sntl.GetInstances()
 	if err != nil {
@@ -220,18 +436,12 @@ func (w *SentinelWorker) updateClusterMeta() error {
 	for _, i := range instances {
 		inst := i
 		// discover new sentinel
-		err = w.sntl.Discover(inst.Name, []string{"127.0.0.1"})
-		if err != nil {
-			return errors.Wrapf(err, "failed to discover sentiinels of instance:%s, error:%#v", inst.Name, err)
-		}
-		slaves := inst.Slaves
-		// delete unavailable slave
-		inst.Slaves = inst.Slaves[:0]
-		for _, slave := range slaves {
-			if slave.Available() {
-				inst.Slaves = append(inst.Slaves, slave)
-			}
+		if err = w.discoverSentinels(inst.Name); err != nil {
+			return err
 		}
+		// delete unavailable or lagging slave
+		inst.Slaves = w.filterSlaves(inst.Name, inst.Master, inst.Slaves)
+		w.getAddrTranslator().TranslateInstance(&inst)
 
 		w.RLock()
 		redisInst, ok := w.meta.Instances[inst.Name]
@@ -242,6 +452,9 @@ func (w *SentinelWorker) updateClusterMeta() error {
 			// Log.Debug("instance{name:%s, old:%s, current:%s}, ok:%v", inst.Name, redisInst, inst, ok)
 		}
 		if !ok {
+			if redisInst != nil && redisInst.Master != nil && !redisInst.Master.Equal(inst.Master) {
+				w.splitBrain.AddFormerMaster(inst.Name, redisInst.Master)
+			}
 			w.Lock()
 			Log.Debug("meta:%s, new inst:%s", w.meta, inst)
 			flag = true
@@ -263,30 +476,33 @@ func (w *SentinelWorker) updateClusterMeta() error {
 	return nil
 }
 
+// updateClusterMetaByInstanceSwitch sets the new master of the instance in @info. The
+// slaves are fetched and filtered before the meta is locked, and the instance in the meta
+// is replaced rather than changed in place. It returns false if the meta is unchanged.
 func (w *SentinelWorker) updateClusterMetaByInstanceSwitch(info gxredis.MasterSwitchInfo) bool {
-	w.Lock()
-	defer w.Unlock()
 	Log.Info("got switch info:%s", info)
-	inst := w.meta.Instances[info.Name]
-	inst.Name = info.Name
-	inst.Master = &(info.NewMaster)
-	inst.Slaves = []*gxredis.Slave{}
-	slaves, err := w.sntl.Slaves(inst.Name)
+	translator := w.getAddrTranslator()
+	slaves, err := w.sntl.Slaves(info.Name)
 	if err != nil {
-		Log.Error("failed to get slaves of %s", inst)
+		Log.Error("failed to get slaves of %s, error:%#v", info.Name, err)
 		return false
-	} else {
-		var slaveArray []*gxredis.Slave
-		for _, slave := range slaves {
-			if slave.Available() {
-				slaveArray = append(slaveArray, slave)
-			}
-		}
-		if 0 < len(slaveArray) {
-			inst.Slaves = slaveArray
-		}
 	}
+	master := info.NewMaster
+	inst := &gxredis.Instance{Name: info.Name, Master: &master, Slaves: []*gxredis.Slave{}}
+	if slaveArray := w.filterSlaves(inst.Name, inst.Master, slaves); 0 < len(slaveArray) {
+		inst.Slaves = slaveArray
+	}
+	translator.TranslateInstance(inst)
 
+	w.Lock()
+	defer w.Unlock()
+	if old, ok := w.meta.Instances[inst.Name]; ok && old.Master != nil {
+		if old.Master.Equal(inst.Master) {
+			// the switch has been handled, maybe by the former watcher
+			return false
+		}
+		w.splitBrain.AddFormerMaster(inst.Name, old.Master)
+	}
 	w.meta.Instances[inst.Name] = inst
 	w.meta.Version++
 	Log.Debug("get switch info:%#v, new inst:%#v, version:%d", info, inst, w.meta.Version)
@@ -294,25 +510,32 @@ func (w *SentinelWorker) updateClusterMetaByInstanceSwitch(info gxredis.MasterSw
 	return true
 }
 
+// updateClusterMetaByInstanceDown removes the node in @info from its instance. The
+// instance in the meta is replaced rather than changed in place. It returns false if
+// the meta is unchanged.
 func (w *SentinelWorker) updateClusterMetaByInstanceDown(info gxredis.SdownInfo) bool {
 	Log.Info("get +sdown info %s", info)
+	info.Addr = w.getAddrTranslator().TranslateIPAddr(info.Addr)
 	w.Lock()
 	defer w.Unlock()
 
-	inst, ok := w.meta.Instances[info.Name]
+	old, ok := w.meta.Instances[info.Name]
 	if !ok {
 		Log.Error("cat not find instance of %s", info.Name)
 		return false
 	}
+	inst := &gxredis.Instance{Name: old.Name, Master: old.Master}
 	if info.Role == gxredis.RR_Master {
 		if inst.Master.Equal(info.Addr) {
+			w.splitBrain.AddFormerMaster(inst.Name, inst.Master)
 			inst.Master = nil
+			inst.Slaves = old.Slaves
 			goto END
 		}
 	} else {
-		for idx, slave := range inst.Slaves {
+		for idx, slave := range old.Slaves {
 			if info.Addr.Equal(slave.Addr) {
-				inst.Slaves = append(inst.Slaves[:idx], inst.Slaves[idx+1:]...)
+				inst.Slaves = append(append([]*gxredis.Slave{}, old.Slaves[:idx]...), old.Slaves[idx+1:]...)
 				goto END
 			}
 		}
@@ -333,15 +556,60 @@ END:
 	return true
 }
 
+// handleSwitch applies a +switch-master message to the meta. The waiters of the
+// switch and the webhooks are only notified if the meta is changed, so a switch
+// seen by both the old and new watchers of resetSentinel is notified once.
+func (w *SentinelWorker) handleSwitch(info gxredis.MasterSwitchInfo) {
+	sentinelEventsTotal.WithLabelValues(w.cluster, SwitchMasterChannel).Inc()
+	Log.Info("redis instance switch info: %#v\n", info)
+	if !w.updateClusterMetaByInstanceSwitch(info) {
+		return
+	}
+	w.storeClusterMetaData()
+
+	w.RLock()
+	version := w.meta.Version
+	w.RUnlock()
+	w.switchNotifier.notify(SwitchEvent{Info: info, Version: version})
+	w.notify(EventSwitchMaster, SwitchMasterData{
+		Name:      info.Name,
+		OldMaster: w.ipAddrString(&info.OldMaster),
+		NewMaster: w.ipAddrString(&info.NewMaster),
+		Version:   version,
+	})
+}
+
+// handleSdown applies a +sdown message to the meta. The webhooks are only notified
+// if the meta is changed.
+func (w *SentinelWorker) handleSdown(info gxredis.SdownInfo) {
+	sentinelEventsTotal.WithLabelValues(w.cluster, SdownChannel).Inc()
+	Log.Info("redis sentinel +sdown info: %#s\n", info)
+	if !w.updateClusterMetaByInstanceDown(info) {
+		return
+	}
+	w.storeClusterMetaData()
+	w.notify(EventSdown, SdownData{Name: info.Name, Role: info.Role.String(), Addr: w.ipAddrString(info.Addr)})
+}
+
 func (w *SentinelWorker) WatchInstanceSwitch() error {
-	var (
-		err error
-	)
-	w.switchWatcher, err = w.sntl.MakeMasterSwitchSentinelWatcher()
+	watcher, err := w.watchInstanceSwitch(w.getSentinels())
 	if err != nil {
-		return errors.Wrapf(err, "WatchInstanceSwitch")
+		return err
+	}
+	w.Lock()
+	w.switchWatcher = watcher
+	w.Unlock()
+
+	return nil
+}
+
+// watchInstanceSwitch starts a switch watcher on one of @sentinels and handles its events
+func (w *SentinelWorker) watchInstanceSwitch(sentinels []string) (*SentinelWatcher, error) {
+	watcher := NewSentinelWatcher(SwitchMasterChannel, parseSwitchMasterMessage, w.getSentinels)
+	c, err := watcher.WatchOn(sentinels)
+	if err != nil {
+		return nil, errors.Wrapf(err, "WatchInstanceSwitch")
 	}
-	c, _ := w.switchWatcher.Watch()
 	w.wg.Add(1)
 	go func() {
 		defer w.wg.Done()
@@ -352,26 +620,33 @@ func (w *SentinelWorker) WatchInstanceSwitch() error {
 				Log.Error("%#v is not of type gxredis.MasterSwitchInfo", elem)
 				continue
 			}
-			Log.Info("redis instance switch info: %#v\n", info)
-			if w.updateClusterMetaByInstanceSwitch(info) {
-				w.storeClusterMetaData()
-			}
+			w.handleSwitch(info)
 		}
 		Log.Info("instance switch watch exit")
 	}()
 
-	return nil
+	return watcher, nil
 }
 
 func (w *SentinelWorker) WatchSdown() error {
-	var (
-		err error
-	)
-	w.sdownWatcher, err = w.sntl.MakeSdownSentinelWatcher()
+	watcher, err := w.watchSdown(w.getSentinels())
+	if err != nil {
+		return err
+	}
+	w.Lock()
+	w.sdownWatcher = watcher
+	w.Unlock()
+
+	return nil
+}
+
+// watchSdown starts a sdown watcher on one of @sentinels and handles its events
+func (w *SentinelWorker) watchSdown(sentinels []string) (*SentinelWatcher, error) {
+	watcher := NewSentinelWatcher(SdownChannel, parseSdownMessage, w.getSentinels)
+	c, err := watcher.WatchOn(sentinels)
 	if err != nil {
-		return errors.Wrapf(err, "MakeSdownSentinelWatcher")
+		return nil, errors.Wrapf(err, "WatchSdown")
 	}
-	c, _ := w.sdownWatcher.Watch()
 	w.wg.Add(1)
 	go func() {
 		defer w.wg.Done()
@@ -382,28 +657,219 @@ func (w *SentinelWorker) WatchSdown() error {
 				Log.Error("%#v is not of type gxredis.SdownInfo", elem)
 				continue
 			}
-			Log.Info("redis sentinel +sdown info: %#s\n", info)
-			if w.updateClusterMetaByInstanceDown(info) {
-				w.storeClusterMetaData()
-			}
+			w.handleSdown(info)
 		}
-		Log.Info("instance switch watch exit")
+		Log.Info("instance sdown watch exit")
 	}()
 
+	return watcher, nil
+}
+
+// resetSentinel replaces the sentinel list with @addrs, and moves the switch and
+// sdown watchers to it. The new watchers are started before the old ones are
+// closed, so the worker keeps the old sentinels and watchers if it fails.
+func (w *SentinelWorker) resetSentinel(addrs []string) error {
+	instances, err := newSentinelClient(func() []string { return addrs }).GetInstances()
+	if err != nil {
+		return errors.Wrapf(err, "st.GetInstances")
+	}
+
+	w.startLock.Lock()
+	defer w.startLock.Unlock()
+	w.Lock()
+	if !w.ready {
+		// retryStart will start the watchers on the new sentinels
+		w.sentinels = append([]string{}, addrs...)
+		w.Unlock()
+		return nil
+	}
+	w.Unlock()
+
+	switchWatcher, err := w.watchInstanceSwitch(addrs)
+	if err != nil {
+		return err
+	}
+	sdownWatcher, err := w.watchSdown(addrs)
+	if err != nil {
+		switchWatcher.Close()
+		return err
+	}
+
+	w.Lock()
+	w.sentinels = append([]string{}, addrs...)
+	switchWatcher, w.switchWatcher = w.switchWatcher, switchWatcher
+	sdownWatcher, w.sdownWatcher = w.sdownWatcher, sdownWatcher
+	w.Unlock()
+	// an event may be handled by both the old and new watchers before the old ones
+	// are closed, which is harmless as the meta updates are idempotent
+	switchWatcher.Close()
+	sdownWatcher.Close()
+
+	for _, inst := range instances {
+		if err = w.discoverSentinels(inst.Name); err != nil {
+			Log.Warn("discoverSentinels(%s) = error:%#v", inst.Name, err)
+		}
+	}
+
 	return nil
 }
 
+// getAddrTranslator returns the translator of the addresses of redis nodes
+func (w *SentinelWorker) getAddrTranslator() *AddrTranslator {
+	w.RLock()
+	defer w.RUnlock()
+	return w.translator
+}
+
+func isExcludedHost(host string) bool {
+	for _, h := range getConf().Redis.DiscoverExcludeHosts {
+		if h == host {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getSentinels returns the sentinels used by the watchers and admin commands
+func (w *SentinelWorker) getSentinels() []string {
+	w.RLock()
+	defer w.RUnlock()
+	return append([]string{}, w.sentinels...)
+}
+
+// discoverSentinels asks the known sentinels for the other sentinels monitoring
+// instance @name, and adds them to the sentinel list.
+func (w *SentinelWorker) discoverSentinels(name string) error {
+	var err error
+
+	for _, addr := range w.getSentinels() {
+		var (
+			conn   redis.Conn
+			values []interface{}
+		)
+		if conn, err = dialSentinel(addr); err != nil {
+			continue
+		}
+		values, err = redis.Values(conn.Do("sentinel", "sentinels", name))
+		conn.Close()
+		if err != nil {
+			err = errors.Wrapf(err, "sentinel sentinels %s", name)
+			continue
+		}
+
+		w.Lock()
+		for _, value := range values {
+			fields, err := redis.StringMap(value, nil)
+			if err != nil || isExcludedHost(fields["ip"]) {
+				continue
+			}
+			sentinel := net.JoinHostPort(fields["ip"], fields["port"])
+			found := false
+			for _, s := range w.sentinels {
+				if s == sentinel {
+					found = true
+					break
+				}
+			}
+			if !found {
+				Log.Info("discover new sentinel %s of instance %s", sentinel, name)
+				w.sentinels = append(w.sentinels, sentinel)
+			}
+		}
+		w.Unlock()
+
+		return nil
+	}
+
+	return errors.Wrapf(err, "failed to discover sentinels of instance:%s", name)
+}
+
 func (w *SentinelWorker) addInstance(inst gxredis.RawInstance) error {
-	return w.sntl.AddInstance(inst)
+	if err := w.sntl.AddInstance(inst); err != nil {
+		return err
+	}
+	w.notify(EventInstanceAdd, InstanceData{Name: inst.Name, Addr: w.ipAddrString(inst.Addr)})
+
+	return nil
 }
 
+// removeInstance stops monitoring instance @name and deletes it from the meta.
 func (w *SentinelWorker) removeInstance(name string) error {
-	return w.sntl.RemoveInstance(name)
+	if err := w.sntl.RemoveInstance(name); err != nil {
+		return err
+	}
+	w.notify(EventInstanceRemove, InstanceData{Name: name})
+
+	w.Lock()
+	_, ok := w.meta.Instances[name]
+	if ok {
+		delete(w.meta.Instances, name)
+		w.meta.Version++
+	}
+	delete(w.attrs, name)
+	w.Unlock()
+	if ok {
+		if err := w.storeClusterMetaData(); err != nil {
+			Log.Error("storeClusterMetaData() = error:%#v", err)
+		}
+	}
+
+	return nil
+}
+
+// getInstance returns the copy of instance @name in the meta
+func (w *SentinelWorker) getInstance(name string) (gxredis.Instance, bool) {
+	w.RLock()
+	defer w.RUnlock()
+
+	inst, ok := w.meta.Instances[name]
+	if !ok || inst == nil {
+		return gxredis.Instance{}, false
+	}
+	return *inst, true
+}
+
+// sentinelSet runs "SENTINEL SET @name @params..." on all sentinels. It goes on
+// if some sentinels fail, and returns their errors.
+func (w *SentinelWorker) sentinelSet(name string, params ...interface{}) error {
+	var errs []string
+
+	args := append([]interface{}{"set", name}, params...)
+	for _, addr := range w.getSentinels() {
+		conn, err := dialSentinel(addr)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("failed to connect to sentinel %s: %v", addr, err))
+			continue
+		}
+		_, err = conn.Do("sentinel", args...)
+		conn.Close()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("sentinel %s: sentinel set %s %v: %v", addr, name, params, err))
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// getWatchers returns the switch and sdown watchers, which are nil before started
+func (w *SentinelWorker) getWatchers() (*SentinelWatcher, *SentinelWatcher) {
+	w.RLock()
+	defer w.RUnlock()
+	return w.switchWatcher, w.sdownWatcher
 }
 
 func (w *SentinelWorker) Close() {
-	w.switchWatcher.Close()
-	w.sdownWatcher.Close()
+	close(w.done)
+	switchWatcher, sdownWatcher := w.getWatchers()
+	if switchWatcher != nil {
+		switchWatcher.Close()
+	}
+	if sdownWatcher != nil {
+		sdownWatcher.Close()
+	}
 	w.wg.Wait()
-	w.sntl.Close()
 }
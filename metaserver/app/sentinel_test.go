@@ -0,0 +1,166 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+// setSwitchTestConf sets the running config and the logger of the switch tests, and
+// returns the function restoring them
+func setSwitchTestConf() func() {
+	oldConf := *getConf()
+	conf := oldConf
+	conf.Redis.MetaDBName = "meta"
+	conf.Redis.MetaHashtable = "meta_hashtable"
+	conf.Redis.SentinelAuth = SectionAuth{}
+	runningConf.set(conf)
+	oldLog := Log
+	Log = newReloadableLogger(&fakeLogger{})
+
+	return func() {
+		runningConf.set(oldConf)
+		Log = oldLog
+	}
+}
+
+// newSwitchTestWorker returns a ready worker of the sentinel stand-in with instance
+// cache1, whose master is 192.168.11.100:4001
+func newSwitchTestWorker(sentinel *redisStandIn) *SentinelWorker {
+	translator, _ := NewAddrTranslator(nil, nil)
+	sw := &SentinelWorker{
+		cluster:    DefaultCluster,
+		sentinels:  []string{sentinel.Addr()},
+		translator: translator,
+		meta: ClusterMeta{Version: 1, Instances: map[string]*gxredis.Instance{
+			"cache1": {Name: "cache1", Master: &gxredis.IPAddr{IP: "192.168.11.100", Port: 4001}},
+		}},
+		splitBrain:     NewSplitBrainDetector(),
+		switchNotifier: newSwitchNotifier(),
+		ready:          true,
+	}
+	sw.sntl = newSentinelClient(sw.getSentinels)
+
+	return sw
+}
+
+// handleSwitchInTime fails the test if handleSwitch of @info blocks
+func handleSwitchInTime(t *testing.T, sw *SentinelWorker, info gxredis.MasterSwitchInfo) {
+	done := make(chan empty)
+	go func() {
+		sw.handleSwitch(info)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("handleSwitch(%s) blocks", info.Name)
+	}
+}
+
+func TestSentinelWorker_handleSwitch(t *testing.T) {
+	sentinel := newRedisStandIn(t, "", map[string]interface{}{
+		"sentinel slaves": []interface{}{
+			[]string{"ip", "192.168.11.100", "port", "4001", "flags", "slave"},
+		},
+	})
+	defer sentinel.Close()
+	defer setSwitchTestConf()()
+
+	sw := newSwitchTestWorker(sentinel)
+	old := sw.meta.Instances["cache1"]
+	ch, cancel := sw.switchNotifier.wait("cache1")
+	defer cancel()
+	info := gxredis.MasterSwitchInfo{
+		Name:      "cache1",
+		OldMaster: gxredis.IPAddr{IP: "192.168.11.100", Port: 4001},
+		NewMaster: gxredis.IPAddr{IP: "192.168.11.101", Port: 4001},
+	}
+	handleSwitchInTime(t, sw, info)
+
+	inst := sw.meta.Instances["cache1"]
+	if inst == old || !inst.Master.Equal(&info.NewMaster) || len(inst.Slaves) != 1 || sw.meta.Version != 2 {
+		t.Errorf("meta = {version:%d, cache1:%s}", sw.meta.Version, inst)
+	}
+	if old.Master.IP != "192.168.11.100" || len(old.Slaves) != 0 {
+		t.Errorf("the former instance is changed in place: %s", old)
+	}
+	if masters := sw.splitBrain.getFormerMasters("cache1"); len(masters) != 1 || masters[0] != "192.168.11.100:4001" {
+		t.Errorf("former masters = %v", masters)
+	}
+	select {
+	case event := <-ch:
+		if event.Version != 2 {
+			t.Errorf("switch event = %+v", event)
+		}
+	default:
+		t.Errorf("switch waiter is not notified")
+	}
+
+	// the same switch seen by another watcher changes nothing and is not notified
+	ch, cancel = sw.switchNotifier.wait("cache1")
+	defer cancel()
+	handleSwitchInTime(t, sw, info)
+	if sw.meta.Version != 2 || sw.meta.Instances["cache1"] != inst {
+		t.Errorf("meta = {version:%d, cache1:%s}", sw.meta.Version, sw.meta.Instances["cache1"])
+	}
+	select {
+	case event := <-ch:
+		t.Errorf("handled switch is notified again: %+v", event)
+	default:
+	}
+}
+
+func TestSentinelWorker_handleSwitchSlavesError(t *testing.T) {
+	sentinel := newRedisStandIn(t, "", map[string]interface{}{
+		"sentinel slaves": standInError("ERR No such master with that name"),
+	})
+	defer sentinel.Close()
+	defer setSwitchTestConf()()
+
+	sw := newSwitchTestWorker(sentinel)
+	old := *sw.meta.Instances["cache1"]
+	handleSwitchInTime(t, sw, gxredis.MasterSwitchInfo{
+		Name:      "cache1",
+		OldMaster: gxredis.IPAddr{IP: "192.168.11.100", Port: 4001},
+		NewMaster: gxredis.IPAddr{IP: "192.168.11.101", Port: 4001},
+	})
+	if inst := sw.meta.Instances["cache1"]; sw.meta.Version != 1 || !inst.Equal(&old) {
+		t.Errorf("meta = {version:%d, cache1:%s}, want it unchanged", sw.meta.Version, inst)
+	}
+}
+
+func TestSentinelWorker_handleSdown(t *testing.T) {
+	defer setSwitchTestConf()()
+
+	sw := &SentinelWorker{
+		meta: ClusterMeta{Version: 1, Instances: map[string]*gxredis.Instance{
+			"cache1": {
+				Name:   "cache1",
+				Master: &gxredis.IPAddr{IP: "192.168.11.100", Port: 4001},
+				Slaves: []*gxredis.Slave{
+					{Addr: &gxredis.IPAddr{IP: "192.168.11.101", Port: 4001}},
+					{Addr: &gxredis.IPAddr{IP: "192.168.11.102", Port: 4001}},
+				},
+			},
+		}},
+		splitBrain: NewSplitBrainDetector(),
+	}
+	old := sw.meta.Instances["cache1"]
+	sw.handleSdown(gxredis.SdownInfo{
+		Name: "cache1",
+		Role: gxredis.RR_Slave,
+		Addr: &gxredis.IPAddr{IP: "192.168.11.101", Port: 4001},
+	})
+
+	inst := sw.meta.Instances["cache1"]
+	if inst == old || len(inst.Slaves) != 1 || inst.Slaves[0].Addr.IP != "192.168.11.102" || sw.meta.Version != 2 {
+		t.Errorf("meta = {version:%d, cache1:%s}", sw.meta.Version, inst)
+	}
+	if len(old.Slaves) != 2 || old.Slaves[0].Addr.IP != "192.168.11.101" {
+		t.Errorf("the former instance is changed in place: %s", old)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+type (
+	// sentinelClient runs the sentinel queries of a worker on the connections of
+	// dialSentinel, so they are authenticated and encrypted as the watchers are.
+	// The queries go to the first reachable sentinel, and the monitor changes go
+	// to all sentinels.
+	sentinelClient struct {
+		sentinels func() []string
+	}
+)
+
+// newSentinelClient creates a client of the sentinels returned by @sentinels
+func newSentinelClient(sentinels func() []string) *sentinelClient {
+	return &sentinelClient{sentinels: sentinels}
+}
+
+// query runs @f on the first sentinel which can be connected to and answers it
+func (s *sentinelClient) query(f func(conn redis.Conn) error) error {
+	var err error
+
+	for _, addr := range s.sentinels() {
+		var conn redis.Conn
+		if conn, err = dialSentinel(addr); err != nil {
+			continue
+		}
+		err = f(conn)
+		conn.Close()
+		if err == nil {
+			return nil
+		}
+		err = errors.Wrapf(err, "sentinel %s", addr)
+	}
+
+	if err == nil {
+		err = fmt.Errorf("sentinel list is empty")
+	}
+	return err
+}
+
+// broadcast runs command "sentinel @args..." of @name on all sentinels. It goes on if
+// some sentinels fail, and returns their errors. The errors of which @ignore returns
+// true are not taken as failures.
+func (s *sentinelClient) broadcast(name string, ignore func(error) bool, args ...interface{}) error {
+	var errs []string
+
+	sentinels := s.sentinels()
+	if len(sentinels) == 0 {
+		return fmt.Errorf("sentinel list is empty")
+	}
+	for _, addr := range sentinels {
+		conn, err := dialSentinel(addr)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("failed to connect to sentinel %s: %v", addr, err))
+			continue
+		}
+		_, err = conn.Do("sentinel", args...)
+		conn.Close()
+		if err != nil && (ignore == nil || !ignore(err)) {
+			errs = append(errs, fmt.Sprintf("sentinel %s: %s of %s: %v", addr, args[0], name, err))
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// parseIPAddr parses the "ip" and "port" fields of a sentinel reply
+func parseIPAddr(fields map[string]string) (*gxredis.IPAddr, error) {
+	port, err := strconv.Atoi(fields["port"])
+	if err != nil {
+		return nil, errors.Wrapf(err, "strconv.Atoi(%s)", fields["port"])
+	}
+	if fields["ip"] == "" {
+		return nil, fmt.Errorf("ip is empty")
+	}
+
+	return &gxredis.IPAddr{IP: fields["ip"], Port: int32(port)}, nil
+}
+
+// getSlaves returns the slaves of instance @name by "SENTINEL SLAVES" on @conn
+func getSlaves(conn redis.Conn, name string) ([]*gxredis.Slave, error) {
+	values, err := redis.Values(conn.Do("sentinel", "slaves", name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "sentinel slaves %s", name)
+	}
+
+	slaves := make([]*gxredis.Slave, 0, len(values))
+	for _, value := range values {
+		fields, err := redis.StringMap(value, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "sentinel slaves %s", name)
+		}
+		addr, err := parseIPAddr(fields)
+		if err != nil {
+			return nil, errors.Wrapf(err, "slave of %s", name)
+		}
+		slaves = append(slaves, &gxredis.Slave{Addr: addr, Flags: fields["flags"]})
+	}
+
+	return slaves, nil
+}
+
+// GetInstances returns all instances monitored by the sentinels with their masters and slaves
+func (s *sentinelClient) GetInstances() ([]gxredis.Instance, error) {
+	var instances []gxredis.Instance
+
+	err := s.query(func(conn redis.Conn) error {
+		values, err := redis.Values(conn.Do("sentinel", "masters"))
+		if err != nil {
+			return errors.Wrapf(err, "sentinel masters")
+		}
+
+		instances = make([]gxredis.Instance, 0, len(values))
+		for _, value := range values {
+			fields, err := redis.StringMap(value, nil)
+			if err != nil {
+				return errors.Wrapf(err, "sentinel masters")
+			}
+			inst := gxredis.Instance{Name: fields["name"]}
+			if inst.Master, err = parseIPAddr(fields); err != nil {
+				return errors.Wrapf(err, "master of %s", inst.Name)
+			}
+			if inst.Slaves, err = getSlaves(conn, inst.Name); err != nil {
+				return err
+			}
+			instances = append(instances, inst)
+		}
+
+		return nil
+	})
+
+	return instances, err
+}
+
+// Slaves returns the slaves of instance @name
+func (s *sentinelClient) Slaves(name string) ([]*gxredis.Slave, error) {
+	var slaves []*gxredis.Slave
+
+	err := s.query(func(conn redis.Conn) (err error) {
+		slaves, err = getSlaves(conn, name)
+		return err
+	})
+
+	return slaves, err
+}
+
+// AddInstance lets all sentinels monitor @inst with its params. If the nodes of the
+// instance need auth, the sentinels are told its auth too.
+func (s *sentinelClient) AddInstance(inst gxredis.RawInstance) error {
+	if inst.Addr == nil {
+		return fmt.Errorf("address of instance %s is empty", inst.Name)
+	}
+
+	if err := s.broadcast(inst.Name, nil, "monitor", inst.Name, inst.Addr.IP, inst.Addr.Port, inst.Epoch); err != nil {
+		return err
+	}
+	params := []interface{}{
+		"set", inst.Name,
+		"down-after-milliseconds", int64(inst.Sdowntime) * 1000,
+		"failover-timeout", int64(inst.FailoverTimeout) * 1000,
+	}
+	auth := getInstanceAuth(inst.Name)
+	password, err := auth.GetPassword()
+	if err != nil {
+		return errors.Wrapf(err, "GetPassword() of instance %s", inst.Name)
+	}
+	if password != "" {
+		params = append(params, "auth-pass", password)
+		if auth.Username != "" {
+			params = append(params, "auth-user", auth.Username)
+		}
+	}
+
+	return s.broadcast(inst.Name, nil, params...)
+}
+
+// RemoveInstance lets all sentinels stop monitoring instance @name. The sentinels
+// that do not monitor it are skipped.
+func (s *sentinelClient) RemoveInstance(name string) error {
+	notMonitored := func(err error) bool {
+		return strings.Contains(err.Error(), "No such master")
+	}
+
+	return s.broadcast(name, notMonitored, "remove", name)
+}
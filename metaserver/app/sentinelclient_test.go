@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+type (
+	// redisStandIn is a local redis or sentinel node speaking RESP. The reply of a command
+	// is the one of its lower-case name, or of its name and first argument such as
+	// "sentinel masters". Every command but AUTH fails with NOAUTH until authenticated
+	// if password is not empty.
+	redisStandIn struct {
+		sync.Mutex
+		password string
+		replies  map[string]interface{}
+		commands [][]string
+		listener net.Listener
+	}
+
+	// standInStatus is a simple string reply such as "+OK"
+	standInStatus string
+	// standInError is an error reply
+	standInError string
+)
+
+func newRedisStandIn(t *testing.T, password string, replies map[string]interface{}) *redisStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = error:%#v", err)
+	}
+	s := &redisStandIn{password: password, replies: replies, listener: listener}
+	go s.serve()
+
+	return s
+}
+
+func (s *redisStandIn) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *redisStandIn) Close() {
+	s.listener.Close()
+}
+
+// Commands returns the commands received, such as "sentinel monitor cache1 ..."
+func (s *redisStandIn) Commands() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	commands := make([]string, 0, len(s.commands))
+	for _, cmd := range s.commands {
+		commands = append(commands, strings.Join(cmd, " "))
+	}
+	return commands
+}
+
+func (s *redisStandIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *redisStandIn) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	authed := s.password == ""
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		cmd, err := readStandInCommand(r)
+		if err != nil || len(cmd) == 0 {
+			return
+		}
+		name := strings.ToLower(cmd[0])
+		s.Lock()
+		s.commands = append(s.commands, append([]string{name}, cmd[1:]...))
+		reply, ok := s.replies[name]
+		if 1 < len(cmd) {
+			if sub, found := s.replies[name+" "+strings.ToLower(cmd[1])]; found {
+				reply, ok = sub, true
+			}
+		}
+		s.Unlock()
+
+		switch {
+		case name == "auth":
+			if cmd[len(cmd)-1] == s.password {
+				authed, reply = true, standInStatus("OK")
+			} else {
+				reply = standInError("WRONGPASS invalid username-password pair")
+			}
+		case !authed:
+			reply = standInError("NOAUTH Authentication required.")
+		case !ok:
+			reply = standInStatus("OK")
+		}
+		writeStandInReply(w, reply)
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func readStandInLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readStandInCommand reads an array of bulk strings
+func readStandInCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readStandInLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if line, err = readStandInLine(r); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		cmd = append(cmd, string(buf[:size]))
+	}
+
+	return cmd, nil
+}
+
+func writeStandInReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case standInStatus:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case standInError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []string:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, elem := range v {
+			writeStandInReply(w, elem)
+		}
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, elem := range v {
+			writeStandInReply(w, elem)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unknown reply type %T\r\n", reply)
+	}
+}
+
+func TestSentinelClient(t *testing.T) {
+	sentinel := newRedisStandIn(t, "sentinel-pw", map[string]interface{}{
+		"sentinel masters": []interface{}{
+			[]string{"name", "cache1", "ip", "192.168.11.100", "port", "4001", "flags", "master"},
+		},
+		"sentinel slaves": []interface{}{
+			[]string{"name", "192.168.11.101:4002", "ip", "192.168.11.101", "port", "4002", "flags", "slave"},
+			[]string{"name", "192.168.11.102:4002", "ip", "192.168.11.102", "port", "4002", "flags", "slave,s_down"},
+		},
+		"sentinel remove": standInError("ERR No such master with that name"),
+	})
+	defer sentinel.Close()
+	oldConf := *getConf()
+	conf := oldConf
+	conf.Redis.SentinelAuth = SectionAuth{Password: "sentinel-pw"}
+	conf.Redis.InstanceAuths = map[string]SectionAuth{"cache2": {Username: "ops", Password: "cache2-pw"}}
+	runningConf.set(conf)
+	defer runningConf.set(oldConf)
+	sntl := newSentinelClient(func() []string { return []string{sentinel.Addr()} })
+
+	instances, err := sntl.GetInstances()
+	if err != nil {
+		t.Fatalf("GetInstances() = error:%#v", err)
+	}
+	if len(instances) != 1 || instances[0].Name != "cache1" || instances[0].Master.IP != "192.168.11.100" ||
+		instances[0].Master.Port != 4001 || len(instances[0].Slaves) != 2 || instances[0].Slaves[1].Available() {
+		t.Errorf("GetInstances() = %#v", instances)
+	}
+
+	err = sntl.AddInstance(gxredis.RawInstance{
+		Name:            "cache2",
+		Addr:            &gxredis.IPAddr{IP: "192.168.11.103", Port: 4003},
+		Epoch:           2,
+		Sdowntime:       15,
+		FailoverTimeout: 450,
+	})
+	if err != nil {
+		t.Fatalf("AddInstance() = error:%#v", err)
+	}
+	if err = sntl.RemoveInstance("cache3"); err != nil {
+		t.Errorf("RemoveInstance() of an instance not monitored = error:%#v", err)
+	}
+
+	var commands []string
+	for _, cmd := range sentinel.Commands() {
+		if cmd != "auth sentinel-pw" {
+			commands = append(commands, cmd)
+		}
+	}
+	expected := []string{
+		"sentinel masters",
+		"sentinel slaves cache1",
+		"sentinel monitor cache2 192.168.11.103 4003 2",
+		"sentinel set cache2 down-after-milliseconds 15000 failover-timeout 450000 auth-pass cache2-pw auth-user ops",
+		"sentinel remove cache3",
+	}
+	if strings.Join(commands, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("commands = %q, want %q", commands, expected)
+	}
+
+	// every connection is authenticated
+	conf.Redis.SentinelAuth = SectionAuth{}
+	runningConf.set(conf)
+	if _, err = sntl.Slaves("cache1"); err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Errorf("Slaves() without auth = error:%v, want NOAUTH", err)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/pkg/errors"
+)
+
+const (
+	CertCheckInterval = 10e9 // 10s
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                tls.NoClientCert,
+	"none":            tls.NoClientCert,
+	"verify_if_given": tls.VerifyClientCertIfGiven,
+	"require":         tls.RequireAndVerifyClientCert,
+}
+
+type (
+	// CertReloader serves the certificate and the client CA of the HTTP listener
+	// and reloads them from disk after the files change.
+	CertReloader struct {
+		sync.RWMutex
+		conf      SectionServerTLS
+		cert      *tls.Certificate
+		clientCAs *x509.CertPool
+		modTime   time.Time // latest modification time of the files
+		checkTime time.Time
+	}
+)
+
+// latestModTime returns the latest modification time of the certificate files
+func (c *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.conf.CertFile, c.conf.KeyFile, c.conf.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if latest.Before(info.ModTime()) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+func (c *CertReloader) load() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.conf.CertFile, c.conf.KeyFile)
+	if err != nil {
+		return errors.Wrapf(err, "tls.LoadX509KeyPair(%s, %s)", c.conf.CertFile, c.conf.KeyFile)
+	}
+	var clientCAs *x509.CertPool
+	if c.conf.ClientCAFile != "" {
+		ca, err := ioutil.ReadFile(c.conf.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("no certificate in client ca file %s", c.conf.ClientCAFile)
+		}
+	}
+
+	c.Lock()
+	c.cert = &cert
+	c.clientCAs = clientCAs
+	c.modTime = modTime
+	c.Unlock()
+
+	return nil
+}
+
+func NewCertReloader(conf SectionServerTLS) (*CertReloader, error) {
+	c := &CertReloader{conf: conf, checkTime: time.Now()}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// check reloads the files at most once every CertCheckInterval if any of them
+// has been changed. The old certificate is kept if the new one is illegal.
+func (c *CertReloader) check() {
+	c.Lock()
+	if time.Since(c.checkTime) < time.Duration(CertCheckInterval) {
+		c.Unlock()
+		return
+	}
+	c.checkTime = time.Now()
+	lastModTime := c.modTime
+	c.Unlock()
+
+	modTime, err := c.latestModTime()
+	if err != nil || !lastModTime.Before(modTime) {
+		return
+	}
+	if err = c.load(); err != nil {
+		Log.Error("failed to reload the certificate of HTTP listener, error:%#v", err)
+		return
+	}
+	Log.Info("reload the certificate of HTTP listener, cert file:%s", c.conf.CertFile)
+}
+
+// TLSConfig returns the tls.Config of a listener. The client certificate is
+// required if @requireClientCert is true, or else it is checked as conf.ClientAuth.
+func (c *CertReloader) TLSConfig(requireClientCert bool) *tls.Config {
+	clientAuth := clientAuthTypes[c.conf.ClientAuth]
+	if requireClientCert {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	minVersion := tlsVersions[c.conf.MinVersion]
+
+	return &tls.Config{
+		MinVersion: minVersion,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			c.RLock()
+			defer c.RUnlock()
+			return c.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.check()
+			c.RLock()
+			defer c.RUnlock()
+			return &tls.Config{
+				MinVersion:   minVersion,
+				Certificates: []tls.Certificate{*c.cert},
+				ClientAuth:   clientAuth,
+				ClientCAs:    c.clientCAs,
+			}, nil
+		},
+	}
+}
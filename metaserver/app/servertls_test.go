@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate of serial number @serial
+func writeTestCert(t *testing.T, certFile, keyFile string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = error:%#v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "metaserver"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() = error:%#v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey() = error:%#v", err)
+	}
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+}
+
+func TestCertReloader_TLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metaserver")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() = error:%#v", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	writeTestCert(t, certFile, keyFile, 1)
+
+	reloader, err := NewCertReloader(SectionServerTLS{
+		Enabled:    true,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		MinVersion: "1.2",
+	})
+	if err != nil {
+		t.Fatalf("NewCertReloader() = error:%#v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = reloader.TLSConfig(false)
+	server.StartTLS()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}}
+	serial := func() int64 {
+		rsp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("client.Get() = error:%#v", err)
+		}
+		rsp.Body.Close()
+		return rsp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+	if s := serial(); s != 1 {
+		t.Fatalf("serial number:%d, want 1", s)
+	}
+
+	writeTestCert(t, certFile, keyFile, 2)
+	if err = reloader.load(); err != nil {
+		t.Fatalf("load() = error:%#v", err)
+	}
+	if s := serial(); s != 2 {
+		t.Fatalf("serial number:%d, want 2", s)
+	}
+}
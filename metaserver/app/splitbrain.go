@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+	"github.com/AlexStocks/goext/time"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxFormerMasterNum = 8
+)
+
+type (
+	// SplitBrain records the nodes of an instance that accept writes
+	// besides its sentinel-elected master.
+	SplitBrain struct {
+		Name         string    `json:"name"`
+		Master       string    `json:"master"`
+		StaleMasters []string  `json:"stale_masters"`
+		DetectTime   time.Time `json:"detect_time"`
+	}
+
+	// formerMaster is a former master and the sequence number of its last addition
+	formerMaster struct {
+		addr *gxredis.IPAddr
+		seq  uint64
+	}
+
+	SplitBrainDetector struct {
+		sync.Mutex
+		// instance name -> former masters("ip:port" -> former master)
+		formerMasters map[string]map[string]formerMaster
+		seq           uint64
+		// instance name -> split brain alert
+		alerts map[string]SplitBrain
+	}
+)
+
+func NewSplitBrainDetector() *SplitBrainDetector {
+	return &SplitBrainDetector{
+		formerMasters: make(map[string]map[string]formerMaster, 32),
+		alerts:        make(map[string]SplitBrain, 4),
+	}
+}
+
+// AddFormerMaster remembers @master which has been replaced by a new master of instance @name.
+// If the instance has maxFormerMasterNum former masters, the one added least recently
+// is forgotten, so the dead nodes that never rejoin do not take all the places.
+func (d *SplitBrainDetector) AddFormerMaster(name string, master *gxredis.IPAddr) {
+	if master == nil {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	masters, ok := d.formerMasters[name]
+	if !ok {
+		masters = make(map[string]formerMaster, 2)
+		d.formerMasters[name] = masters
+	}
+	addr := master.TcpAddr().String()
+	if _, ok = masters[addr]; !ok && maxFormerMasterNum <= len(masters) {
+		var oldest string
+		for a, m := range masters {
+			if oldest == "" || m.seq < masters[oldest].seq {
+				oldest = a
+			}
+		}
+		delete(masters, oldest)
+		Log.Warn("former master %s of instance %s is forgotten for %s, at most %d ones are kept",
+			oldest, name, addr, maxFormerMasterNum)
+	}
+	d.seq++
+	masters[addr] = formerMaster{addr: master, seq: d.seq}
+}
+
+func (d *SplitBrainDetector) forgetFormerMaster(name string, addr string) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.formerMasters[name], addr)
+}
+
+func (d *SplitBrainDetector) getFormerMasters(name string) []string {
+	d.Lock()
+	defer d.Unlock()
+	var addrs []string
+	for addr := range d.formerMasters[name] {
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
+
+// update sets or clears the alert of instance @name
+func (d *SplitBrainDetector) update(name string, master string, staleMasters []string) {
+	d.Lock()
+	defer d.Unlock()
+
+	alert, ok := d.alerts[name]
+	if len(staleMasters) == 0 {
+		if ok {
+			Log.Info("split brain of instance %s has been resolved, master:%s", name, master)
+			delete(d.alerts, name)
+		}
+		return
+	}
+
+	sort.Strings(staleMasters)
+	if ok && alert.Master == master && fmt.Sprint(alert.StaleMasters) == fmt.Sprint(staleMasters) {
+		return
+	}
+	alert = SplitBrain{
+		Name:         name,
+		Master:       master,
+		StaleMasters: staleMasters,
+		DetectTime:   time.Now(),
+	}
+	d.alerts[name] = alert
+	Log.Critic("split brain detected! instance:%s, sentinel elected master:%s, stale masters:%s",
+		name, master, staleMasters)
+}
+
+// Alerts returns all unresolved split brain alerts.
+func (d *SplitBrainDetector) Alerts() []SplitBrain {
+	d.Lock()
+	defer d.Unlock()
+
+	alerts := make([]SplitBrain, 0, len(d.alerts))
+	for _, alert := range d.alerts {
+		alerts = append(alerts, alert)
+	}
+
+	return alerts
+}
+
+// isStaleMaster checks whether @addr has been reported as a stale master of instance @name.
+func (d *SplitBrainDetector) isStaleMaster(name string, addr string) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, master := range d.alerts[name].StaleMasters {
+		if master == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitBrainLoop detects split brain every update_interval. It runs apart from the
+// update loop, as the nodes that do not answer may delay it for long.
+func (w *SentinelWorker) splitBrainLoop() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-time.After(gxtime.TimeSecondDuration(float64(getConf().Redis.UpdateInterval))):
+			if w.Ready() {
+				w.detectSplitBrain()
+			}
+		}
+	}
+}
+
+// detectSplitBrain sends ROLE to the former masters and all slaves of every instance,
+// and raises an alert if any node other than the sentinel-elected master answers as master.
+func (w *SentinelWorker) detectSplitBrain() {
+	w.RLock()
+	masters := make(map[string]string, len(w.meta.Instances))
+	for name, inst := range w.meta.Instances {
+		if inst.Master != nil {
+			masters[name] = inst.Master.TcpAddr().String()
+		}
+	}
+	w.RUnlock()
+
+	for name, master := range masters {
+		nodes := make(map[string]struct{}, 8)
+		for _, addr := range w.splitBrain.getFormerMasters(name) {
+			nodes[addr] = struct{}{}
+		}
+		slaves, err := w.sntl.Slaves(name)
+		if err != nil {
+			Log.Warn("failed to get slaves of %s, error:%#v", name, err)
+		}
+		for _, slave := range slaves {
+			nodes[w.getAddrTranslator().Translate(slave.Addr.TcpAddr().String())] = struct{}{}
+		}
+		delete(nodes, master)
+
+		var staleMasters []string
+		for addr := range nodes {
+			role, err := getRole(name, addr)
+			if err != nil {
+				Log.Debug("getRole(%s, %s) = error:%#v", name, addr, err)
+				continue
+			}
+			switch role {
+			case "master":
+				staleMasters = append(staleMasters, addr)
+			case "slave":
+				// it has rejoined the instance
+				w.splitBrain.forgetFormerMaster(name, addr)
+			}
+		}
+		w.splitBrain.update(name, master, staleMasters)
+	}
+}
+
+// resolveSplitBrain turns the stale master @addr of instance @name into
+// a slave of the sentinel-elected master.
+func (w *SentinelWorker) resolveSplitBrain(name string, addr string) error {
+	if !w.splitBrain.isStaleMaster(name, addr) {
+		return fmt.Errorf("%s is not a stale master of instance %s", addr, name)
+	}
+
+	w.RLock()
+	inst, ok := w.meta.Instances[name]
+	var master *gxredis.IPAddr
+	if ok {
+		master = inst.Master
+	}
+	w.RUnlock()
+	if master == nil {
+		return fmt.Errorf("can not find the master of instance %s", name)
+	}
+
+	conn, err := dialInstance(name, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// REPLICAOF is an alias of SLAVEOF since redis 5.0
+	tcpAddr := master.TcpAddr()
+	if _, err = conn.Do("slaveof", tcpAddr.IP.String(), tcpAddr.Port); err != nil {
+		return errors.Wrapf(err, "slaveof(%s)", tcpAddr)
+	}
+	Log.Warn("stale master %s of instance %s has been turned into slave of %s", addr, name, tcpAddr)
+	w.splitBrain.forgetFormerMaster(name, addr)
+
+	return nil
+}
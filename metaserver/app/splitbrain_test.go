@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+func TestSplitBrainDetector_update(t *testing.T) {
+	oldLog := Log
+	Log = newReloadableLogger(&fakeLogger{})
+	defer func() {
+		Log = oldLog
+	}()
+
+	d := NewSplitBrainDetector()
+	cases := []struct {
+		name         string
+		master       string
+		staleMasters []string
+		// the stale masters of the alert of the instance, nil if there is no alert
+		alert []string
+		// whether the alert is a new one
+		renewed bool
+	}{
+		{"cache1", "192.168.11.100:4001", nil, nil, false},
+		{"cache1", "192.168.11.100:4001", []string{"192.168.11.102:4001", "192.168.11.101:4001"},
+			[]string{"192.168.11.101:4001", "192.168.11.102:4001"}, true},
+		// the same stale masters in another order keep the alert
+		{"cache1", "192.168.11.100:4001", []string{"192.168.11.101:4001", "192.168.11.102:4001"},
+			[]string{"192.168.11.101:4001", "192.168.11.102:4001"}, false},
+		{"cache1", "192.168.11.100:4001", []string{"192.168.11.101:4001"}, []string{"192.168.11.101:4001"}, true},
+		{"cache1", "192.168.11.103:4001", []string{"192.168.11.101:4001"}, []string{"192.168.11.101:4001"}, true},
+		{"cache2", "192.168.11.100:4002", []string{"192.168.11.101:4002"}, []string{"192.168.11.101:4002"}, true},
+		{"cache1", "192.168.11.103:4001", nil, nil, false},
+	}
+	alerts := map[string]SplitBrain{}
+	for i, c := range cases {
+		d.update(c.name, c.master, c.staleMasters)
+		var alert *SplitBrain
+		for _, a := range d.Alerts() {
+			if a.Name == c.name {
+				a := a
+				alert = &a
+			}
+		}
+		if c.alert == nil {
+			if alert != nil {
+				t.Errorf("case %d: alert of %s = %+v, want none", i, c.name, *alert)
+			}
+			delete(alerts, c.name)
+			continue
+		}
+		if alert == nil || alert.Master != c.master || !reflect.DeepEqual(alert.StaleMasters, c.alert) {
+			t.Errorf("case %d: alert of %s = %+v, want stale masters %v", i, c.name, alert, c.alert)
+			continue
+		}
+		if renewed := !alert.DetectTime.Equal(alerts[c.name].DetectTime); renewed != c.renewed {
+			t.Errorf("case %d: alert of %s renewed = %v, want %v", i, c.name, renewed, c.renewed)
+		}
+		alerts[c.name] = *alert
+	}
+	if len(d.Alerts()) != 1 || d.Alerts()[0].Name != "cache2" {
+		t.Errorf("Alerts() = %+v, want the one of cache2", d.Alerts())
+	}
+}
+
+func TestSplitBrainDetector_isStaleMaster(t *testing.T) {
+	oldLog := Log
+	Log = newReloadableLogger(&fakeLogger{})
+	defer func() {
+		Log = oldLog
+	}()
+
+	d := NewSplitBrainDetector()
+	d.update("cache1", "192.168.11.100:4001", []string{"192.168.11.101:4001"})
+	cases := []struct {
+		name  string
+		addr  string
+		stale bool
+	}{
+		{"cache1", "192.168.11.101:4001", true},
+		{"cache1", "192.168.11.100:4001", false}, // the elected master
+		{"cache1", "192.168.11.102:4001", false},
+		{"cache2", "192.168.11.101:4001", false},
+	}
+	for _, c := range cases {
+		if stale := d.isStaleMaster(c.name, c.addr); stale != c.stale {
+			t.Errorf("isStaleMaster(%s, %s) = %v, want %v", c.name, c.addr, stale, c.stale)
+		}
+	}
+
+	d.update("cache1", "192.168.11.100:4001", nil)
+	if d.isStaleMaster("cache1", "192.168.11.101:4001") {
+		t.Errorf("isStaleMaster() of a resolved split brain = true")
+	}
+}
+
+func TestSplitBrainDetector_AddFormerMaster(t *testing.T) {
+	oldLog := Log
+	Log = newReloadableLogger(&fakeLogger{})
+	defer func() {
+		Log = oldLog
+	}()
+
+	d := NewSplitBrainDetector()
+	d.AddFormerMaster("cache1", nil)
+	if masters := d.getFormerMasters("cache1"); len(masters) != 0 {
+		t.Errorf("former masters after adding nil = %v", masters)
+	}
+
+	addr := func(port int) string {
+		return fmt.Sprintf("192.168.11.100:%d", port)
+	}
+	for i := 0; i < maxFormerMasterNum+2; i++ {
+		d.AddFormerMaster("cache1", &gxredis.IPAddr{IP: "192.168.11.100", Port: int32(4001 + i)})
+	}
+	// an address added again is not counted twice, and becomes the latest one
+	d.AddFormerMaster("cache1", &gxredis.IPAddr{IP: "192.168.11.100", Port: 4004})
+	d.AddFormerMaster("cache1", &gxredis.IPAddr{IP: "192.168.11.100", Port: 4001 + maxFormerMasterNum + 2})
+	d.AddFormerMaster("cache2", &gxredis.IPAddr{IP: "192.168.11.100", Port: 4002})
+
+	// 4001 and 4002 are forgotten for the cap, and then 4003 as 4004 is added again
+	var expected []string
+	for port := 4004; port <= 4001+maxFormerMasterNum+2; port++ {
+		expected = append(expected, addr(port))
+	}
+	masters := d.getFormerMasters("cache1")
+	sort.Strings(masters)
+	if fmt.Sprint(masters) != fmt.Sprint(expected) {
+		t.Errorf("former masters of cache1 = %v, want the latest %d ones %v", masters, maxFormerMasterNum, expected)
+	}
+	if masters := d.getFormerMasters("cache2"); len(masters) != 1 {
+		t.Errorf("former masters of cache2 = %v", masters)
+	}
+
+	// forgetting one makes room for another
+	d.forgetFormerMaster("cache1", expected[0])
+	d.AddFormerMaster("cache1", &gxredis.IPAddr{IP: "192.168.11.101", Port: 4001})
+	masters = d.getFormerMasters("cache1")
+	if len(masters) != maxFormerMasterNum {
+		t.Errorf("former masters of cache1 = %v, want %d", masters, maxFormerMasterNum)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+	"github.com/pkg/errors"
+)
+
+type (
+	addrRule struct {
+		from  *net.IPNet
+		to    net.IP
+		toNet *net.IPNet
+	}
+
+	// AddrTranslator translates the internal address of a redis node into
+	// the address advertised to clients.
+	AddrTranslator struct {
+		addrMap map[string]string
+		rules   []addrRule
+	}
+)
+
+// NewAddrTranslator creates a translator. @addrMap maps "ip:port" to "ip:port",
+// and it takes precedence over @rules.
+func NewAddrTranslator(addrMap map[string]string, rules []SectionAddrRule) (*AddrTranslator, error) {
+	t := &AddrTranslator{addrMap: make(map[string]string, len(addrMap))}
+	for from, to := range addrMap {
+		if err := checkAddr(from); err != nil {
+			return nil, errors.Wrapf(err, "illegal address %q", from)
+		}
+		if err := checkAddr(to); err != nil {
+			return nil, errors.Wrapf(err, "illegal address %q", to)
+		}
+		t.addrMap[from] = to
+	}
+
+	for _, r := range rules {
+		var (
+			err  error
+			rule addrRule
+		)
+		if _, rule.from, err = net.ParseCIDR(r.CIDR); err != nil {
+			return nil, errors.Wrapf(err, "illegal cidr %q", r.CIDR)
+		}
+		if rule.to = net.ParseIP(r.To); rule.to == nil {
+			if _, rule.toNet, err = net.ParseCIDR(r.To); err != nil {
+				return nil, fmt.Errorf("illegal ip or cidr %q", r.To)
+			}
+			fromOnes, fromBits := rule.from.Mask.Size()
+			toOnes, toBits := rule.toNet.Mask.Size()
+			if fromOnes != toOnes || fromBits != toBits {
+				return nil, fmt.Errorf("prefix length of %q and %q are different", r.CIDR, r.To)
+			}
+		}
+		t.rules = append(t.rules, rule)
+	}
+
+	return t, nil
+}
+
+// translateIP returns the ip mapped by the first matched rule
+func (t *AddrTranslator) translateIP(ip net.IP) net.IP {
+	for _, rule := range t.rules {
+		if !rule.from.Contains(ip) {
+			continue
+		}
+		if rule.to != nil {
+			return rule.to
+		}
+
+		// keep the host part of @ip like iptables NETMAP
+		if ip4 := ip.To4(); ip4 != nil && len(rule.toNet.IP) == net.IPv4len {
+			ip = ip4
+		}
+		mapped := make(net.IP, len(ip))
+		for i := range ip {
+			mapped[i] = rule.toNet.IP[i]&rule.toNet.Mask[i] | ip[i]&^rule.toNet.Mask[i]
+		}
+		return mapped
+	}
+
+	return ip
+}
+
+// Translate translates "ip:port" @addr. It returns @addr if no rule matches.
+func (t *AddrTranslator) Translate(addr string) string {
+	if to, ok := t.addrMap[addr]; ok {
+		return to
+	}
+	if len(t.rules) == 0 {
+		return addr
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return addr
+	}
+
+	return net.JoinHostPort(t.translateIP(ip).String(), port)
+}
+
+// TranslateIPAddr translates @addr. It returns @addr itself if no rule matches.
+func (t *AddrTranslator) TranslateIPAddr(addr *gxredis.IPAddr) *gxredis.IPAddr {
+	if addr == nil || t == nil {
+		return addr
+	}
+
+	from := addr.TcpAddr().String()
+	to := t.Translate(from)
+	if to == from {
+		return addr
+	}
+	host, portStr, _ := net.SplitHostPort(to)
+	port, _ := strconv.Atoi(portStr)
+
+	return &gxredis.IPAddr{IP: host, Port: int32(port)}
+}
+
+// TranslateInstance translates the addresses of the master and slaves of @inst in place.
+func (t *AddrTranslator) TranslateInstance(inst *gxredis.Instance) {
+	inst.Master = t.TranslateIPAddr(inst.Master)
+	for _, slave := range inst.Slaves {
+		slave.Addr = t.TranslateIPAddr(slave.Addr)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAddrTranslator_Translate(t *testing.T) {
+	translator, err := NewAddrTranslator(
+		map[string]string{"127.0.0.1:4001": "192.168.11.100:4001"},
+		[]SectionAddrRule{
+			{CIDR: "127.0.0.0/8", To: "192.168.11.101"},
+			{CIDR: "10.1.0.0/16", To: "192.168.0.0/16"},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewAddrTranslator() = error:%#v", err)
+	}
+
+	for from, to := range map[string]string{
+		"127.0.0.1:4001":    "192.168.11.100:4001",
+		"127.0.0.1:4002":    "192.168.11.101:4002",
+		"10.1.2.3:4001":     "192.168.2.3:4001",
+		"192.168.11.1:4001": "192.168.11.1:4001",
+	} {
+		if addr := translator.Translate(from); addr != to {
+			t.Errorf("Translate(%s) = %s, want %s", from, addr, to)
+		}
+	}
+}
+
+func TestNewAddrTranslatorIllegalRule(t *testing.T) {
+	if _, err := NewAddrTranslator(nil, []SectionAddrRule{{CIDR: "10.1.0.0/16", To: "192.168.0.0/24"}}); err == nil {
+		t.Fatalf("NewAddrTranslator() should fail for different prefix length")
+	}
+	if _, err := NewAddrTranslator(map[string]string{"127.0.0.1": "192.168.11.100:4001"}, nil); err == nil {
+		t.Fatalf("NewAddrTranslator() should fail for address without port")
+	}
+}
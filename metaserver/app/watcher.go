@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	SwitchMasterChannel = "+switch-master"
+	SdownChannel        = "+sdown"
+
+	WatcherRetryInterval = 1e9 // 1s
+)
+
+type (
+	// SentinelWatcher subscribes to a channel of one of the sentinels and turns its
+	// messages into events. It switches to another sentinel if the connection breaks.
+	SentinelWatcher struct {
+		sync.Mutex
+		channel   string
+		parse     func(string) (interface{}, error)
+		sentinels func() []string
+		conn      redis.Conn
+		connected bool
+		events    chan interface{}
+		done      chan empty
+	}
+)
+
+func NewSentinelWatcher(channel string, parse func(string) (interface{}, error), sentinels func() []string) *SentinelWatcher {
+	return &SentinelWatcher{
+		channel:   channel,
+		parse:     parse,
+		sentinels: sentinels,
+		events:    make(chan interface{}, 16),
+		done:      make(chan empty),
+	}
+}
+
+// parseSwitchMasterMessage parses "<master name> <old ip> <old port> <new ip> <new port>"
+func parseSwitchMasterMessage(msg string) (interface{}, error) {
+	fields := strings.Fields(msg)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("illegal %s message %q", SwitchMasterChannel, msg)
+	}
+	oldPort, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, errors.Wrapf(err, "strconv.Atoi(%s)", fields[2])
+	}
+	newPort, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return nil, errors.Wrapf(err, "strconv.Atoi(%s)", fields[4])
+	}
+
+	return gxredis.MasterSwitchInfo{
+		Name:      fields[0],
+		OldMaster: gxredis.IPAddr{IP: fields[1], Port: int32(oldPort)},
+		NewMaster: gxredis.IPAddr{IP: fields[3], Port: int32(newPort)},
+	}, nil
+}
+
+// parseSdownMessage parses "<instance type> <name> <ip> <port> @ <master name> <master ip> <master port>".
+// The "@..." part is absent if the instance is a master. It returns nil if the instance is a sentinel.
+func parseSdownMessage(msg string) (interface{}, error) {
+	fields := strings.Fields(msg)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("illegal %s message %q", SdownChannel, msg)
+	}
+	port, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return nil, errors.Wrapf(err, "strconv.Atoi(%s)", fields[3])
+	}
+
+	info := gxredis.SdownInfo{
+		Name: fields[1],
+		Addr: &gxredis.IPAddr{IP: fields[2], Port: int32(port)},
+	}
+	switch fields[0] {
+	case "master":
+		info.Role = gxredis.RR_Master
+	case "slave":
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("illegal %s message %q", SdownChannel, msg)
+		}
+		info.Role = gxredis.RR_Slave
+		info.Name = fields[5]
+	default:
+		return nil, nil
+	}
+
+	return info, nil
+}
+
+// subscribe connects to the first reachable sentinel of @sentinels and subscribes to the channel.
+func (sw *SentinelWatcher) subscribe(sentinels []string) (redis.PubSubConn, error) {
+	var err error
+
+	for _, addr := range sentinels {
+		var conn redis.Conn
+		// pubsub connection has no read timeout
+		conf := &getConf().Redis
+		if conn, err = dialRedis(addr, conf.sentinelAuth(addr), conf.SentinelTLS, 0); err != nil {
+			continue
+		}
+		psc := redis.PubSubConn{Conn: conn}
+		if err = psc.Subscribe(sw.channel); err != nil {
+			conn.Close()
+			continue
+		}
+
+		sw.Lock()
+		select {
+		case <-sw.done:
+			sw.Unlock()
+			conn.Close()
+			return redis.PubSubConn{}, fmt.Errorf("watcher of %s has been closed", sw.channel)
+		default:
+		}
+		sw.conn = conn
+		sw.connected = true
+		sw.Unlock()
+		Log.Info("subscribe %s of sentinel %s", sw.channel, addr)
+
+		return psc, nil
+	}
+
+	if err == nil {
+		err = fmt.Errorf("sentinel list is empty")
+	}
+	return redis.PubSubConn{}, errors.Wrapf(err, "failed to subscribe %s", sw.channel)
+}
+
+// Watch subscribes to the channel and returns the event channel, which will be
+// closed after Close.
+func (sw *SentinelWatcher) Watch() (<-chan interface{}, error) {
+	return sw.WatchOn(sw.sentinels())
+}
+
+// WatchOn is Watch on one of @sentinels. It resubscribes to the sentinels of the
+// watcher if the connection breaks.
+func (sw *SentinelWatcher) WatchOn(sentinels []string) (<-chan interface{}, error) {
+	psc, err := sw.subscribe(sentinels)
+	if err != nil {
+		return nil, err
+	}
+	go sw.run(psc)
+
+	return sw.events, nil
+}
+
+func (sw *SentinelWatcher) run(psc redis.PubSubConn) {
+	var err error
+
+	defer close(sw.events)
+	for {
+		switch v := psc.ReceiveWithTimeout(0).(type) {
+		case redis.Message:
+			event, err := sw.parse(string(v.Data))
+			if err != nil {
+				Log.Error("failed to parse message %q, error:%#v", string(v.Data), err)
+				continue
+			}
+			if event == nil {
+				continue
+			}
+			select {
+			case sw.events <- event:
+			case <-sw.done:
+				return
+			}
+
+		case redis.Subscription:
+			Log.Debug("subscription: %#v", v)
+
+		case error:
+			psc.Close()
+			sw.Lock()
+			sw.connected = false
+			sw.Unlock()
+			select {
+			case <-sw.done:
+				return
+			default:
+			}
+			Log.Warn("watcher of %s got error:%#v, resubscribe", sw.channel, v)
+			for {
+				select {
+				case <-sw.done:
+					return
+				case <-time.After(time.Duration(WatcherRetryInterval)):
+				}
+				if psc, err = sw.subscribe(sw.sentinels()); err == nil {
+					break
+				}
+				Log.Warn("resubscribe %s error:%#v", sw.channel, err)
+			}
+		}
+	}
+}
+
+// Close stops the watcher.
+func (sw *SentinelWatcher) Close() {
+	sw.Lock()
+	defer sw.Unlock()
+
+	select {
+	case <-sw.done:
+		return
+	default:
+	}
+	close(sw.done)
+	sw.connected = false
+	if sw.conn != nil {
+		sw.conn.Close()
+	}
+}
+
+// Connected returns true if the watcher is subscribing to the channel
+func (sw *SentinelWatcher) Connected() bool {
+	if sw == nil {
+		return false
+	}
+
+	sw.Lock()
+	defer sw.Unlock()
+	return sw.connected
+}
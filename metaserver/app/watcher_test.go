@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+func Test_parseSwitchMasterMessage(t *testing.T) {
+	event, err := parseSwitchMasterMessage("cache1 192.168.11.100 4001 192.168.11.101 4002")
+	if err != nil {
+		t.Fatalf("parseSwitchMasterMessage() = error:%#v", err)
+	}
+	info := event.(gxredis.MasterSwitchInfo)
+	if info.Name != "cache1" || info.NewMaster.IP != "192.168.11.101" || info.NewMaster.Port != 4002 {
+		t.Fatalf("info:%#v", info)
+	}
+
+	if _, err = parseSwitchMasterMessage("cache1 192.168.11.100 4001"); err == nil {
+		t.Fatalf("parseSwitchMasterMessage() should fail")
+	}
+}
+
+func Test_parseSdownMessage(t *testing.T) {
+	event, err := parseSdownMessage("slave 192.168.11.101:4002 192.168.11.101 4002 @ cache1 192.168.11.100 4001")
+	if err != nil {
+		t.Fatalf("parseSdownMessage() = error:%#v", err)
+	}
+	info := event.(gxredis.SdownInfo)
+	if info.Role != gxredis.RR_Slave || info.Name != "cache1" || info.Addr.Port != 4002 {
+		t.Fatalf("info:%#v", info)
+	}
+
+	event, err = parseSdownMessage("master cache1 192.168.11.100 4001")
+	if err != nil {
+		t.Fatalf("parseSdownMessage() = error:%#v", err)
+	}
+	if info = event.(gxredis.SdownInfo); info.Role != gxredis.RR_Master || info.Name != "cache1" {
+		t.Fatalf("info:%#v", info)
+	}
+
+	if event, err = parseSdownMessage("sentinel 192.168.11.100:26380 192.168.11.100 26380 @ cache1 192.168.11.100 4001"); event != nil || err != nil {
+		t.Fatalf("parseSdownMessage() = {event:%#v, error:%#v}", event, err)
+	}
+}
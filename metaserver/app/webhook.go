@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+const (
+	EventSwitchMaster   = "switch-master"
+	EventSdown          = "sdown"
+	EventInstanceAdd    = "instance-add"
+	EventInstanceRemove = "instance-remove"
+	EventStoreFailure   = "meta-store-failure"
+
+	DefaultWebhookTimeout         = 5  // 5s
+	DefaultWebhookRetryBackoff    = 1  // 1s
+	DefaultWebhookMaxRetryBackoff = 60 // 60s
+	DefaultWebhookQueueSize       = 1024
+
+	WebhookEventHeader    = "X-Metaserver-Event"
+	WebhookDeliveryHeader = "X-Metaserver-Delivery"
+	// "HMAC-SHA256 <signature>". The signature is HMACSignature of the request, and
+	// the timestamp is in HMACTimestampHeader.
+	WebhookSignatureHeader = "X-Metaserver-Signature"
+
+	maxWebhookResponseSize = 64 * 1024
+)
+
+var webhookEvents = map[string]struct{}{
+	EventSwitchMaster:   {},
+	EventSdown:          {},
+	EventInstanceAdd:    {},
+	EventInstanceRemove: {},
+	EventStoreFailure:   {},
+}
+
+type (
+	// WebhookEvent is the JSON payload posted to the endpoints. ID is the same
+	// in all the retries of a delivery.
+	WebhookEvent struct {
+		ID      string      `json:"id"`
+		Event   string      `json:"event"`
+		Cluster string      `json:"cluster"`
+		Time    time.Time   `json:"time"`
+		Data    interface{} `json:"data"`
+	}
+
+	SwitchMasterData struct {
+		Name      string `json:"name"`
+		OldMaster string `json:"old_master"`
+		NewMaster string `json:"new_master"`
+		Version   int32  `json:"version"`
+	}
+
+	SdownData struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+		Addr string `json:"addr"`
+	}
+
+	InstanceData struct {
+		Name string `json:"name"`
+		Addr string `json:"addr,omitempty"`
+	}
+
+	StoreFailureData struct {
+		Error string `json:"error"`
+	}
+
+	// DeadLetter is a line of the dead letter file
+	DeadLetter struct {
+		Endpoint string       `json:"endpoint"`
+		URL      string       `json:"url"`
+		Attempts int          `json:"attempts"`
+		Error    string       `json:"error"`
+		Event    WebhookEvent `json:"event"`
+	}
+
+	// webhookStatusError is a delivery answered with a non-2xx status
+	webhookStatusError struct {
+		url    string
+		status string
+		code   int
+	}
+
+	webhookEndpoint struct {
+		conf   SectionWebhookEndpoint
+		events map[string]struct{} // nil means all events
+		client *http.Client
+		queue  chan WebhookEvent
+	}
+
+	// WebhookNotifier posts the events to the endpoints. Every endpoint has its own
+	// queue and goroutine, so a slow endpoint does not delay the others, and its
+	// events are delivered in order.
+	WebhookNotifier struct {
+		conf       SectionWebhook
+		endpoints  []*webhookEndpoint
+		maxRetries int
+		backoff    time.Duration
+		maxBackoff time.Duration
+		epoch      int64
+		seq        uint64
+		deadLock   sync.Mutex
+		wg         sync.WaitGroup
+		done       chan struct{}
+	}
+
+	webhookHolder struct {
+		sync.RWMutex
+		notifier *WebhookNotifier
+	}
+)
+
+var (
+	webhooks webhookHolder
+)
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("POST %s: %s", e.url, e.status)
+}
+
+// retryable returns false if retrying the delivery that failed with @err is useless
+func retryable(err error) bool {
+	var statusErr *webhookStatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	code := statusErr.code
+
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || 500 <= code
+}
+
+// NewWebhookNotifier creates a notifier of @conf. It does not deliver until started.
+func NewWebhookNotifier(conf SectionWebhook) (*WebhookNotifier, error) {
+	n := &WebhookNotifier{
+		conf:       conf,
+		maxRetries: conf.MaxRetries,
+		backoff:    time.Duration(conf.RetryBackoff) * time.Second,
+		maxBackoff: time.Duration(conf.MaxRetryBackoff) * time.Second,
+		epoch:      time.Now().UnixNano(),
+		done:       make(chan struct{}),
+	}
+	for _, endpointConf := range conf.Endpoints {
+		tlsConfig, err := endpointConf.TLS.GetTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("tls of webhook endpoint %s: %v", endpointConf.Name, err)
+		}
+		e := &webhookEndpoint{
+			conf: endpointConf,
+			client: &http.Client{
+				Timeout: time.Duration(conf.Timeout) * time.Second,
+				Transport: &http.Transport{
+					Proxy:           http.ProxyFromEnvironment,
+					TLSClientConfig: tlsConfig,
+				},
+			},
+			queue: make(chan WebhookEvent, conf.QueueSize),
+		}
+		if len(endpointConf.Events) != 0 {
+			e.events = make(map[string]struct{}, len(endpointConf.Events))
+			for _, event := range endpointConf.Events {
+				e.events[event] = struct{}{}
+			}
+		}
+		n.endpoints = append(n.endpoints, e)
+	}
+
+	return n, nil
+}
+
+func (n *WebhookNotifier) start() {
+	for _, e := range n.endpoints {
+		n.wg.Add(1)
+		go n.run(e)
+	}
+}
+
+// Close stops the delivery. The pending events are written to the dead letter file.
+func (n *WebhookNotifier) Close() {
+	if n == nil {
+		return
+	}
+	close(n.done)
+	n.wg.Wait()
+}
+
+func (e *webhookEndpoint) accepts(event string) bool {
+	if e.events == nil {
+		return true
+	}
+	_, ok := e.events[event]
+	return ok
+}
+
+// Notify queues @event of @cluster with @data for the endpoints accepting it. It never
+// blocks: the event is a dead letter of an endpoint whose queue is full.
+func (n *WebhookNotifier) Notify(cluster string, event string, data interface{}) {
+	if n == nil {
+		return
+	}
+
+	e := WebhookEvent{
+		ID:      fmt.Sprintf("%x-%d", n.epoch, atomic.AddUint64(&n.seq, 1)),
+		Event:   event,
+		Cluster: cluster,
+		Time:    time.Now().UTC(),
+		Data:    data,
+	}
+	for _, endpoint := range n.endpoints {
+		if !endpoint.accepts(event) {
+			continue
+		}
+		select {
+		case endpoint.queue <- e:
+		default:
+			n.deadLetter(endpoint, e, 0, fmt.Errorf("queue of %d events is full", cap(endpoint.queue)))
+		}
+	}
+}
+
+func (n *WebhookNotifier) run(e *webhookEndpoint) {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.done:
+			for {
+				select {
+				case event := <-e.queue:
+					n.deadLetter(e, event, 0, fmt.Errorf("webhook notifier is closed"))
+				default:
+					return
+				}
+			}
+		case event := <-e.queue:
+			n.deliver(e, event)
+		}
+	}
+}
+
+// deliver posts @event to @e, and retries with backoff if it fails
+func (n *WebhookNotifier) deliver(e *webhookEndpoint, event WebhookEvent) {
+	body, err := json.Marshal(&event)
+	if err != nil {
+		n.deadLetter(e, event, 0, err)
+		return
+	}
+
+	backoff := n.backoff
+	for attempt := 1; ; attempt++ {
+		if err = n.post(e, event, body); err == nil {
+			webhookDeliveriesTotal.WithLabelValues(e.conf.Name, "ok").Inc()
+			return
+		}
+		webhookDeliveriesTotal.WithLabelValues(e.conf.Name, "failed").Inc()
+		if n.maxRetries < attempt || !retryable(err) {
+			n.deadLetter(e, event, attempt, err)
+			return
+		}
+
+		select {
+		case <-n.done:
+			n.deadLetter(e, event, attempt, err)
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; n.maxBackoff < backoff {
+			backoff = n.maxBackoff
+		}
+	}
+}
+
+func (n *WebhookNotifier) post(e *webhookEndpoint, event WebhookEvent, body []byte) error {
+	req, err := http.NewRequest("POST", e.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event.Event)
+	req.Header.Set(WebhookDeliveryHeader, event.ID)
+	secret, err := e.conf.Secret.GetPassword()
+	if err != nil {
+		return err
+	}
+	if secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HMACTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader,
+			HMACScheme+" "+HMACSignature([]byte(secret), req.Method, req.URL.RequestURI(), timestamp, body))
+	}
+
+	rsp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(rsp.Body, maxWebhookResponseSize))
+	rsp.Body.Close()
+	if rsp.StatusCode < 200 || 300 <= rsp.StatusCode {
+		return &webhookStatusError{url: e.conf.URL, status: rsp.Status, code: rsp.StatusCode}
+	}
+
+	return nil
+}
+
+// deadLetter appends @event, which is not delivered to @e after @attempts attempts, to the dead letter file
+func (n *WebhookNotifier) deadLetter(e *webhookEndpoint, event WebhookEvent, attempts int, cause error) {
+	webhookDeliveriesTotal.WithLabelValues(e.conf.Name, "dead").Inc()
+	if n.conf.DeadLetterFile == "" {
+		return
+	}
+
+	line, err := json.Marshal(&DeadLetter{
+		Endpoint: e.conf.Name,
+		URL:      e.conf.URL,
+		Attempts: attempts,
+		Error:    cause.Error(),
+		Event:    event,
+	})
+	if err != nil {
+		// the payload can not be marshaled
+		line, _ = json.Marshal(&DeadLetter{Endpoint: e.conf.Name, URL: e.conf.URL, Attempts: attempts,
+			Error: cause.Error(), Event: WebhookEvent{ID: event.ID, Event: event.Event, Cluster: event.Cluster, Time: event.Time}})
+	}
+
+	n.deadLock.Lock()
+	defer n.deadLock.Unlock()
+	f, err := os.OpenFile(n.conf.DeadLetterFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		Log.Error("failed to write dead letter %s of webhook endpoint %s, error:%#v", event.ID, e.conf.Name, err)
+		return
+	}
+	defer f.Close()
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		Log.Error("failed to write dead letter %s of webhook endpoint %s, error:%#v", event.ID, e.conf.Name, err)
+	}
+}
+
+func (h *webhookHolder) set(notifier *WebhookNotifier) *WebhookNotifier {
+	h.Lock()
+	defer h.Unlock()
+	old := h.notifier
+	h.notifier = notifier
+	return old
+}
+
+// notify queues the event. The old notifier being replaced by set receives no events.
+func (h *webhookHolder) notify(cluster string, event string, data interface{}) {
+	h.RLock()
+	defer h.RUnlock()
+	h.notifier.Notify(cluster, event, data)
+}
+
+// startWebhooks starts the notifier of the webhook config
+func startWebhooks() {
+	// the config has been validated
+	notifier, err := NewWebhookNotifier(getConf().Webhook)
+	if err != nil {
+		Log.Error("NewWebhookNotifier() = error:%#v", err)
+		return
+	}
+	notifier.start()
+	webhooks.set(notifier)
+}
+
+// closeWebhooks stops the notifier. The pending events become dead letters.
+func closeWebhooks() {
+	webhooks.set(nil).Close()
+}
+
+// notify posts @event of the cluster of the worker to the webhooks
+func (w *SentinelWorker) notify(event string, data interface{}) {
+	webhooks.notify(w.cluster, event, data)
+}
+
+// ipAddrString returns the advertised address of @addr
+func (w *SentinelWorker) ipAddrString(addr *gxredis.IPAddr) string {
+	if addr = w.getAddrTranslator().TranslateIPAddr(addr); addr == nil {
+		return ""
+	}
+	return addr.TcpAddr().String()
+}
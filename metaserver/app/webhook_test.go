@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookStandIn is a local endpoint which answers the deliveries with the codes in turn
+type webhookStandIn struct {
+	sync.Mutex
+	codes    []int
+	requests []*http.Request
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newWebhookStandIn(codes ...int) (*webhookStandIn, *httptest.Server) {
+	s := &webhookStandIn{codes: codes, received: make(chan struct{}, 16)}
+	return s, httptest.NewServer(s)
+}
+
+func (s *webhookStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.Lock()
+	code := http.StatusOK
+	if len(s.requests) < len(s.codes) {
+		code = s.codes[len(s.requests)]
+	}
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+	s.Unlock()
+	w.WriteHeader(code)
+	s.received <- struct{}{}
+}
+
+func (s *webhookStandIn) wait(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d deliveries received, want %d", i, n)
+		}
+	}
+}
+
+func newTestWebhookNotifier(t *testing.T, conf SectionWebhook) *WebhookNotifier {
+	conf.Timeout, conf.QueueSize = 5, 16
+	n, err := NewWebhookNotifier(conf)
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier() = error:%#v", err)
+	}
+	n.backoff, n.maxBackoff = time.Millisecond, 4*time.Millisecond
+	n.start()
+	return n
+}
+
+func TestWebhookNotifier_Notify(t *testing.T) {
+	standIn, server := newWebhookStandIn()
+	defer server.Close()
+	n := newTestWebhookNotifier(t, SectionWebhook{
+		Endpoints: []SectionWebhookEndpoint{{
+			Name:   "ops",
+			URL:    server.URL + "/hook?team=ops",
+			Secret: SectionAuth{Password: "s3cret"},
+			Events: []string{EventSwitchMaster},
+		}},
+	})
+	defer n.Close()
+
+	n.Notify(DefaultCluster, EventSdown, SdownData{Name: "cache1", Role: "slave", Addr: "192.168.11.101:6379"})
+	n.Notify(DefaultCluster, EventSwitchMaster, SwitchMasterData{
+		Name:      "cache1",
+		OldMaster: "192.168.11.100:6379",
+		NewMaster: "192.168.11.101:6379",
+		Version:   3,
+	})
+	standIn.wait(t, 1)
+
+	standIn.Lock()
+	defer standIn.Unlock()
+	if len(standIn.requests) != 1 {
+		t.Fatalf("deliveries = %d, want only the switch-master one", len(standIn.requests))
+	}
+	r, body := standIn.requests[0], standIn.bodies[0]
+	if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" ||
+		r.Header.Get(WebhookEventHeader) != EventSwitchMaster || r.Header.Get(WebhookDeliveryHeader) == "" {
+		t.Errorf("request = %s %s, header:%v", r.Method, r.URL, r.Header)
+	}
+	timestamp := r.Header.Get(HMACTimestampHeader)
+	signature := HMACScheme + " " + HMACSignature([]byte("s3cret"), "POST", "/hook?team=ops", timestamp, body)
+	if r.Header.Get(WebhookSignatureHeader) != signature {
+		t.Errorf("%s = %q, want %q", WebhookSignatureHeader, r.Header.Get(WebhookSignatureHeader), signature)
+	}
+
+	var event struct {
+		WebhookEvent
+		Data SwitchMasterData `json:"data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatalf("json.Unmarshal(%s) = error:%#v", body, err)
+	}
+	if event.Event != EventSwitchMaster || event.Cluster != DefaultCluster ||
+		event.Data.NewMaster != "192.168.11.101:6379" || event.Data.Version != 3 {
+		t.Errorf("payload = %s", body)
+	}
+}
+
+func TestWebhookNotifier_Retry(t *testing.T) {
+	standIn, server := newWebhookStandIn(http.StatusInternalServerError, http.StatusTooManyRequests)
+	defer server.Close()
+	deadLetterFile := filepath.Join(t.TempDir(), "webhook.dead")
+	n := newTestWebhookNotifier(t, SectionWebhook{
+		MaxRetries:     2,
+		DeadLetterFile: deadLetterFile,
+		Endpoints:      []SectionWebhookEndpoint{{Name: "ops", URL: server.URL}},
+	})
+
+	n.Notify(DefaultCluster, EventInstanceAdd, InstanceData{Name: "cache3", Addr: "192.168.11.103:6379"})
+	standIn.wait(t, 3)
+	n.Close()
+
+	standIn.Lock()
+	defer standIn.Unlock()
+	id := standIn.requests[0].Header.Get(WebhookDeliveryHeader)
+	for i, r := range standIn.requests {
+		if r.Header.Get(WebhookDeliveryHeader) != id {
+			t.Errorf("delivery id of attempt %d = %s, want %s", i, r.Header.Get(WebhookDeliveryHeader), id)
+		}
+	}
+	if _, err := os.Stat(deadLetterFile); !os.IsNotExist(err) {
+		t.Errorf("dead letter file of a delivered event, os.Stat() = error:%#v", err)
+	}
+}
+
+func TestWebhookNotifier_deadLetter(t *testing.T) {
+	standIn, server := newWebhookStandIn(http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway,
+		http.StatusBadRequest)
+	defer server.Close()
+	deadLetterFile := filepath.Join(t.TempDir(), "webhook.dead")
+	n := newTestWebhookNotifier(t, SectionWebhook{
+		MaxRetries:     2,
+		DeadLetterFile: deadLetterFile,
+		Endpoints:      []SectionWebhookEndpoint{{Name: "ops", URL: server.URL}},
+	})
+
+	// 3 attempts of the first event, and the second one is not retried after 400
+	n.Notify(DefaultCluster, EventStoreFailure, StoreFailureData{Error: "can not find meta db"})
+	n.Notify(DefaultCluster, EventInstanceRemove, InstanceData{Name: "cache3"})
+	standIn.wait(t, 4)
+	n.Close()
+
+	f, err := os.Open(deadLetterFile)
+	if err != nil {
+		t.Fatalf("os.Open(%s) = error:%#v", deadLetterFile, err)
+	}
+	defer f.Close()
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var letter DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = error:%#v", scanner.Text(), err)
+		}
+		letters = append(letters, letter)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("dead letters = %+v", letters)
+	}
+	if letters[0].Endpoint != "ops" || letters[0].Attempts != 3 || letters[0].Event.Event != EventStoreFailure {
+		t.Errorf("dead letter[0] = %+v", letters[0])
+	}
+	if letters[1].Attempts != 1 || letters[1].Event.Event != EventInstanceRemove {
+		t.Errorf("dead letter[1] = %+v", letters[1])
+	}
+}
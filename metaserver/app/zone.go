@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ZoneLabel is the instance label whose value is the zone of the nodes
+	// matched by no zone rule
+	ZoneLabel = "zone"
+)
+
+type (
+	zoneRule struct {
+		cidr *net.IPNet
+		zone string
+	}
+
+	// ZoneResolver maps the address of a redis node to its zone by CIDR rules
+	ZoneResolver struct {
+		rules []zoneRule
+	}
+
+	// ZoneWarning is an instance whose master and slaves are all in one zone
+	ZoneWarning struct {
+		Name string `json:"name"`
+		Zone string `json:"zone"`
+	}
+
+	// ZoneReport is the node count of every zone and the single-zone instances
+	ZoneReport struct {
+		Zones      map[string]int `json:"zones"`
+		SingleZone []ZoneWarning  `json:"single_zone"`
+	}
+)
+
+// NewZoneResolver creates a resolver whose rules are matched in order
+func NewZoneResolver(rules []SectionZoneRule) (*ZoneResolver, error) {
+	z := &ZoneResolver{}
+	for _, r := range rules {
+		var (
+			err  error
+			rule zoneRule
+		)
+		if _, rule.cidr, err = net.ParseCIDR(r.CIDR); err != nil {
+			return nil, errors.Wrapf(err, "illegal cidr %q", r.CIDR)
+		}
+		if r.Zone == "" {
+			return nil, fmt.Errorf("zone of cidr %q is empty", r.CIDR)
+		}
+		if err = checkLabelValue(r.Zone); err != nil {
+			return nil, err
+		}
+		rule.zone = r.Zone
+		z.rules = append(z.rules, rule)
+	}
+
+	return z, nil
+}
+
+// Zone returns the zone of the first rule matching @addr, or "" if none matches.
+func (z *ZoneResolver) Zone(addr *gxredis.IPAddr) string {
+	if z == nil || addr == nil {
+		return ""
+	}
+	ip := net.ParseIP(addr.IP)
+	if ip == nil {
+		return ""
+	}
+	for _, rule := range z.rules {
+		if rule.cidr.Contains(ip) {
+			return rule.zone
+		}
+	}
+
+	return ""
+}
+
+// instanceZones returns the zones of the master and slaves of @inst, keyed by "ip:port".
+// A node matched by no rule is in the zone of the instance label @label, if any.
+func (z *ZoneResolver) instanceZones(inst *gxredis.Instance, label string) map[string]string {
+	if inst == nil {
+		return nil
+	}
+
+	zones := make(map[string]string, len(inst.Slaves)+1)
+	add := func(addr *gxredis.IPAddr) {
+		if addr == nil {
+			return
+		}
+		zone := z.Zone(addr)
+		if zone == "" {
+			zone = label
+		}
+		if zone != "" {
+			zones[addr.TcpAddr().String()] = zone
+		}
+	}
+	add(inst.Master)
+	for _, slave := range inst.Slaves {
+		add(slave.Addr)
+	}
+	if len(zones) == 0 {
+		return nil
+	}
+
+	return zones
+}
+
+// zoneOf returns the zone of node @addr of the instance
+func (i MetaInstance) zoneOf(addr *gxredis.IPAddr) string {
+	if addr == nil {
+		return ""
+	}
+	return i.Zones[addr.TcpAddr().String()]
+}
+
+// PreferZone returns @v in which the slaves in @zone come first. The other slaves
+// keep their order. The instances of @v are not changed.
+func (v MetaView) PreferZone(zone string) MetaView {
+	if zone == "" {
+		return v
+	}
+
+	view := MetaView{Version: v.Version, Instances: make(map[string]MetaInstance, len(v.Instances))}
+	for name, inst := range v.Instances {
+		if inst.Instance != nil && 1 < len(inst.Slaves) {
+			copied := *inst.Instance
+			copied.Slaves = append([]*gxredis.Slave{}, inst.Slaves...)
+			sort.SliceStable(copied.Slaves, func(i, j int) bool {
+				return inst.zoneOf(copied.Slaves[i].Addr) == zone && inst.zoneOf(copied.Slaves[j].Addr) != zone
+			})
+			inst.Instance = &copied
+		}
+		view.Instances[name] = inst
+	}
+
+	return view
+}
+
+// ZoneReport returns the node count of every zone, and the instances whose master
+// and slaves are all in one zone. An instance without slaves or with a node of
+// unknown zone is not checked.
+func (v MetaView) ZoneReport() ZoneReport {
+	report := ZoneReport{Zones: make(map[string]int), SingleZone: []ZoneWarning{}}
+	for name, inst := range v.Instances {
+		for _, zone := range inst.Zones {
+			report.Zones[zone]++
+		}
+		if inst.Instance == nil || inst.Master == nil || len(inst.Slaves) == 0 {
+			continue
+		}
+
+		zone := inst.zoneOf(inst.Master)
+		single := zone != ""
+		for _, slave := range inst.Slaves {
+			if inst.zoneOf(slave.Addr) != zone {
+				single = false
+				break
+			}
+		}
+		if single {
+			report.SingleZone = append(report.SingleZone, ZoneWarning{Name: name, Zone: zone})
+		}
+	}
+	sort.Slice(report.SingleZone, func(i, j int) bool {
+		return report.SingleZone[i].Name < report.SingleZone[j].Name
+	})
+
+	return report
+}
+
+// checkZones warns about the instances whose master and slaves are all in one zone.
+// An instance is only reported when it becomes single-zone. It is called by the
+// update loop only.
+func (w *SentinelWorker) checkZones() {
+	w.RLock()
+	report := w.metaView().ZoneReport()
+	w.RUnlock()
+
+	singleZone := make(map[string]string, len(report.SingleZone))
+	for _, warning := range report.SingleZone {
+		singleZone[warning.Name] = warning.Zone
+		if _, ok := w.singleZone[warning.Name]; !ok {
+			Log.Warn("master and all slaves of instance %s of cluster %s are in zone %s",
+				warning.Name, w.cluster, warning.Zone)
+		}
+	}
+	for name := range w.singleZone {
+		if _, ok := singleZone[name]; !ok {
+			Log.Info("instance %s of cluster %s is not in a single zone any more", name, w.cluster)
+		}
+	}
+	w.singleZone = singleZone
+}
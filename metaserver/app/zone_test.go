@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+)
+
+import (
+	"github.com/AlexStocks/goext/database/redis"
+)
+
+func newZoneInstance(name string, zones map[*gxredis.IPAddr]string, master *gxredis.IPAddr, slaves ...*gxredis.IPAddr) MetaInstance {
+	inst := MetaInstance{Instance: &gxredis.Instance{Name: name, Master: master}}
+	for _, addr := range slaves {
+		inst.Slaves = append(inst.Slaves, &gxredis.Slave{Addr: addr})
+	}
+	inst.Zones = make(map[string]string, len(zones))
+	for addr, zone := range zones {
+		inst.Zones[addr.TcpAddr().String()] = zone
+	}
+
+	return inst
+}
+
+func TestZoneResolver_Zone(t *testing.T) {
+	z, err := NewZoneResolver([]SectionZoneRule{
+		{CIDR: "192.168.11.0/24", Zone: "a"},
+		{CIDR: "192.168.0.0/16", Zone: "b"},
+	})
+	if err != nil {
+		t.Fatalf("NewZoneResolver() = error:%#v", err)
+	}
+
+	cases := []struct {
+		ip   string
+		zone string
+	}{
+		{"192.168.11.100", "a"},
+		{"192.168.12.100", "b"},
+		{"10.0.0.1", ""},
+		{"illegal", ""},
+	}
+	for _, c := range cases {
+		if zone := z.Zone(&gxredis.IPAddr{IP: c.ip, Port: 6379}); zone != c.zone {
+			t.Errorf("Zone(%s) = %q, want %q", c.ip, zone, c.zone)
+		}
+	}
+	if zone := (*ZoneResolver)(nil).Zone(&gxredis.IPAddr{IP: "192.168.11.100"}); zone != "" {
+		t.Errorf("nil.Zone() = %q", zone)
+	}
+
+	for _, rules := range [][]SectionZoneRule{
+		{{CIDR: "192.168.11.0", Zone: "a"}},
+		{{CIDR: "192.168.11.0/24"}},
+		{{CIDR: "192.168.11.0/24", Zone: "a b"}},
+	} {
+		if _, err := NewZoneResolver(rules); err == nil {
+			t.Errorf("NewZoneResolver(%+v) should fail", rules)
+		}
+	}
+}
+
+func TestZoneResolver_instanceZones(t *testing.T) {
+	z, _ := NewZoneResolver([]SectionZoneRule{{CIDR: "192.168.11.0/24", Zone: "a"}})
+	master := &gxredis.IPAddr{IP: "192.168.11.100", Port: 6379}
+	inst := &gxredis.Instance{Name: "cache1", Master: master}
+
+	zones := z.instanceZones(inst, "")
+	if len(zones) != 1 || zones[master.TcpAddr().String()] != "a" {
+		t.Errorf("instanceZones() = %v", zones)
+	}
+	if zones = z.instanceZones(&gxredis.Instance{Name: "cache2"}, ""); zones != nil {
+		t.Errorf("instanceZones() without nodes = %v", zones)
+	}
+
+	other := &gxredis.IPAddr{IP: "10.0.0.1", Port: 6379}
+	zones = z.instanceZones(&gxredis.Instance{Name: "cache3", Master: other}, "b")
+	if zones[other.TcpAddr().String()] != "b" {
+		t.Errorf("instanceZones() with label = %v", zones)
+	}
+}
+
+func TestMetaView_PreferZone(t *testing.T) {
+	var (
+		master = &gxredis.IPAddr{IP: "192.168.11.100", Port: 6379}
+		slave1 = &gxredis.IPAddr{IP: "192.168.12.100", Port: 6379}
+		slave2 = &gxredis.IPAddr{IP: "192.168.11.101", Port: 6379}
+		slave3 = &gxredis.IPAddr{IP: "192.168.13.100", Port: 6379}
+	)
+	zones := map[*gxredis.IPAddr]string{master: "a", slave1: "b", slave2: "a", slave3: "c"}
+	view := MetaView{
+		Version:   3,
+		Instances: map[string]MetaInstance{"cache1": newZoneInstance("cache1", zones, master, slave1, slave2, slave3)},
+	}
+
+	if same := view.PreferZone(""); same.Instances["cache1"].Instance != view.Instances["cache1"].Instance {
+		t.Errorf("PreferZone(\"\") should not copy the instances")
+	}
+
+	preferred := view.PreferZone("a")
+	if preferred.Version != 3 {
+		t.Errorf("PreferZone().Version = %d", preferred.Version)
+	}
+	slaves := preferred.Instances["cache1"].Slaves
+	if len(slaves) != 3 || slaves[0].Addr != slave2 || slaves[1].Addr != slave1 || slaves[2].Addr != slave3 {
+		t.Errorf("PreferZone(a).Slaves = %v", slaves)
+	}
+	if origin := view.Instances["cache1"].Slaves; origin[0].Addr != slave1 || origin[1].Addr != slave2 {
+		t.Errorf("PreferZone() changed the original slaves %v", origin)
+	}
+}
+
+func TestMetaView_ZoneReport(t *testing.T) {
+	var (
+		master1 = &gxredis.IPAddr{IP: "192.168.11.100", Port: 6379}
+		slave1  = &gxredis.IPAddr{IP: "192.168.11.101", Port: 6379}
+		master2 = &gxredis.IPAddr{IP: "192.168.11.102", Port: 6379}
+		slave2  = &gxredis.IPAddr{IP: "192.168.12.100", Port: 6379}
+		master3 = &gxredis.IPAddr{IP: "10.0.0.1", Port: 6379}
+	)
+	view := MetaView{
+		Instances: map[string]MetaInstance{
+			"cache1": newZoneInstance("cache1", map[*gxredis.IPAddr]string{master1: "a", slave1: "a"}, master1, slave1),
+			"cache2": newZoneInstance("cache2", map[*gxredis.IPAddr]string{master2: "a", slave2: "b"}, master2, slave2),
+			"cache3": newZoneInstance("cache3", map[*gxredis.IPAddr]string{master3: "c"}, master3),
+		},
+	}
+
+	report := view.ZoneReport()
+	if report.Zones["a"] != 3 || report.Zones["b"] != 1 || report.Zones["c"] != 1 {
+		t.Errorf("ZoneReport().Zones = %v", report.Zones)
+	}
+	if len(report.SingleZone) != 1 || report.SingleZone[0] != (ZoneWarning{Name: "cache1", Zone: "a"}) {
+		t.Errorf("ZoneReport().SingleZone = %+v", report.SingleZone)
+	}
+}